@@ -3,11 +3,13 @@
  * @Email: thepoy@163.com
  * @File Name: api.go
  * @Created: 2021-07-24 22:19:44
- * @Modified: 2021-07-31 09:26:34
+ * @Modified: 2026-10-18 23:14:47
  */
 
 package cache
 
+import "time"
+
 type Cache interface {
 	// 是否开启压缩。压缩后能减小数据量，但压缩过程会耗时。
 	// 如果原数据长度很长，压缩耗时要比查询耗时低得多，此时开启压缩功能是最佳选择。
@@ -24,9 +26,52 @@ type Cache interface {
 	Clear() error
 }
 
+// ExpirableCache 可以为每条缓存设置过期时间的缓存，过期的缓存视为未缓存
+type ExpirableCache interface {
+	Cache
+	// 将没有缓存过的请求保存到缓存中，ttl 为 0 时永不过期
+	CacheWithTTL(key string, val []byte, ttl time.Duration) error
+	// 删除所有已过期的缓存。能自动删除过期数据的缓存，如 Redis，可以什么都不做
+	PurgeExpired() error
+}
+
+// UpdatableCache 可以覆盖已有缓存的缓存，用于更新重新验证后的响应
+type UpdatableCache interface {
+	Cache
+	// 保存缓存，已存在时覆盖，ttl 为 0 时永不过期
+	Update(key string, val []byte, ttl time.Duration) error
+}
+
+// StaleCache 可以读取已过期但还未被删除的缓存，用于离线模式和 stale-if-error。
+// Redis 会自动删除过期的键，所以没有实现此接口
+type StaleCache interface {
+	Cache
+	// 与 IsCached 相同，但不检查缓存是否过期
+	IsCachedStale(key string) ([]byte, bool)
+}
+
 type CacheModel struct {
 	Key   string `gorm:"primaryKey"`
 	Value []byte
+	// 缓存的时间
+	CreatedAt time.Time
+	// 过期时间，为 nil 时永不过期
+	ExpiresAt *time.Time `gorm:"index"`
+	// 开启去重时响应体的 sha256，响应体保存在 BodyModel 中，Value 只保存其余部分
+	Hash string `gorm:"size:64;index"`
+}
+
+func newCacheModel(key string, val []byte, ttl time.Duration) *CacheModel {
+	cm := &CacheModel{
+		Key:       key,
+		Value:     val,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := cm.CreatedAt.Add(ttl)
+		cm.ExpiresAt = &expiresAt
+	}
+	return cm
 }
 
 func (CacheModel) TableName() string {
@@ -0,0 +1,289 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: batch.go
+ * @Created: 2026-10-18 23:09:26
+ * @Modified: 2026-10-18 23:09:26
+ */
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultBatchFlushSize 默认每批写入的条数
+	DefaultBatchFlushSize = 100
+	// DefaultBatchFlushInterval 默认的写入间隔
+	DefaultBatchFlushInterval = time.Second
+)
+
+// BatchSetter 可以一次写入多条缓存的缓存，BatchStore 会优先使用。
+// SQL 缓存用一条 INSERT ... ON CONFLICT 语句写入，redis 缓存用 pipeline 写入。
+type BatchSetter interface {
+	// 写入多条缓存，已存在时覆盖，Entry.ExpiresAt 为 nil 时永不过期
+	SetMany(ctx context.Context, entries []*Entry) error
+}
+
+// Flusher 有待写入数据的缓存，如开启了 WriteBehind 的 TieredCache 和 BatchStore
+type Flusher interface {
+	// 等待待写入的数据全部写入，返回写入中出现的第一个错误
+	Flush() error
+}
+
+// BatchStore 在后台批量写入缓存。
+//
+// Set 只把缓存放入内存中的队列，队列中的缓存达到 flushSize 条或距离上次写入超过
+// flushInterval 时，在后台一次写入 Store。队列中的缓存也可以被 Get 读取。
+// 写入速度跟不上时，队列超过 4 倍 flushSize 后 Set 会同步写入。
+//
+// 写入失败的缓存会被丢弃，错误由 Flush 返回。程序退出前需要调用 Flush 或 Close，
+// 否则队列中的缓存会丢失。
+type BatchStore struct {
+	store         Store
+	flushSize     int
+	flushInterval time.Duration
+
+	lock     sync.Mutex
+	pending  map[string]*Entry
+	flushing map[string]*Entry
+	err      error
+
+	// 保证同一时间只有一次写入
+	flushLock sync.Mutex
+
+	notify    chan struct{}
+	done      chan struct{}
+	stopped   sync.WaitGroup
+	closeOnce sync.Once
+
+	hits   uint64
+	errors uint64
+}
+
+// NewBatchStore 创建 BatchStore，flushSize 和 flushInterval 小于等于 0 时使用默认值
+func NewBatchStore(store Store, flushSize int, flushInterval time.Duration) *BatchStore {
+	if flushSize <= 0 {
+		flushSize = DefaultBatchFlushSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = DefaultBatchFlushInterval
+	}
+
+	bs := &BatchStore{
+		store:         store,
+		flushSize:     flushSize,
+		flushInterval: flushInterval,
+		pending:       make(map[string]*Entry),
+		notify:        make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+
+	bs.stopped.Add(1)
+	go bs.loop()
+
+	return bs
+}
+
+func (bs *BatchStore) loop() {
+	defer bs.stopped.Done()
+
+	ticker := time.NewTicker(bs.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bs.notify:
+		case <-ticker.C:
+		case <-bs.done:
+			return
+		}
+		bs.flush()
+	}
+}
+
+// lookup 在队列和正在写入的缓存中查找
+func (bs *BatchStore) lookup(key string) (*Entry, bool) {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	if e, ok := bs.pending[key]; ok {
+		return e, true
+	}
+	e, ok := bs.flushing[key]
+	return e, ok
+}
+
+func (bs *BatchStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if e, ok := bs.lookup(key); ok {
+		// 最近一次写入的缓存已经过期
+		if e.Expired() {
+			return nil, false, nil
+		}
+		atomic.AddUint64(&bs.hits, 1)
+		return copyBytes(e.Value), true, nil
+	}
+	return bs.store.Get(ctx, key)
+}
+
+// GetStale Store 没有实现 StaleStore 时，只能读取队列中的缓存
+func (bs *BatchStore) GetStale(ctx context.Context, key string) ([]byte, bool, error) {
+	if e, ok := bs.lookup(key); ok {
+		atomic.AddUint64(&bs.hits, 1)
+		return copyBytes(e.Value), true, nil
+	}
+
+	if ss, ok := bs.store.(StaleStore); ok {
+		return ss.GetStale(ctx, key)
+	}
+	return nil, false, nil
+}
+
+func (bs *BatchStore) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	e := &Entry{
+		Key:       key,
+		Value:     copyBytes(val),
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := e.CreatedAt.Add(ttl)
+		e.ExpiresAt = &expiresAt
+	}
+
+	bs.lock.Lock()
+	bs.pending[key] = e
+	n := len(bs.pending)
+	bs.lock.Unlock()
+
+	if n >= bs.flushSize*4 {
+		bs.flush()
+		return nil
+	}
+
+	if n >= bs.flushSize {
+		select {
+		case bs.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Delete 同时删除队列中的缓存
+func (bs *BatchStore) Delete(ctx context.Context, key string) error {
+	// 避免正在写入的缓存在删除后又被写入
+	bs.flushLock.Lock()
+	defer bs.flushLock.Unlock()
+
+	bs.lock.Lock()
+	delete(bs.pending, key)
+	bs.lock.Unlock()
+
+	return bs.store.Delete(ctx, key)
+}
+
+// flush 将队列中的缓存写入 Store
+func (bs *BatchStore) flush() {
+	bs.flushLock.Lock()
+	defer bs.flushLock.Unlock()
+
+	bs.lock.Lock()
+	if len(bs.pending) == 0 {
+		bs.lock.Unlock()
+		return
+	}
+	batch := bs.pending
+	bs.pending = make(map[string]*Entry, len(batch))
+	bs.flushing = batch
+	bs.lock.Unlock()
+
+	err := bs.write(batch)
+
+	bs.lock.Lock()
+	bs.flushing = nil
+	if err != nil {
+		atomic.AddUint64(&bs.errors, 1)
+		if bs.err == nil {
+			bs.err = err
+		}
+	}
+	bs.lock.Unlock()
+}
+
+func (bs *BatchStore) write(batch map[string]*Entry) error {
+	entries := make([]*Entry, 0, len(batch))
+	for _, e := range batch {
+		if !e.Expired() {
+			entries = append(entries, e)
+		}
+	}
+
+	// 后台写入不受调用方 ctx 的影响
+	ctx := context.Background()
+
+	if setter, ok := bs.store.(BatchSetter); ok {
+		return setter.SetMany(ctx, entries)
+	}
+
+	var firstErr error
+	for _, e := range entries {
+		if err := bs.store.Set(ctx, e.Key, e.Value, e.TTL()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Flush 立即写入队列中的缓存，返回上次调用 Flush 以来写入时出现的第一个错误
+func (bs *BatchStore) Flush() error {
+	bs.flush()
+
+	bs.lock.Lock()
+	err := bs.err
+	bs.err = nil
+	bs.lock.Unlock()
+
+	if f, ok := bs.store.(Flusher); ok {
+		if e := f.Flush(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Close 停止后台写入，写入队列中剩余的缓存后关闭 Store
+func (bs *BatchStore) Close() error {
+	var err error
+	bs.closeOnce.Do(func() {
+		close(bs.done)
+		bs.stopped.Wait()
+
+		err = bs.Flush()
+		if e := bs.store.Close(); e != nil && err == nil {
+			err = e
+		}
+	})
+	return err
+}
+
+// Stats 队列中命中的次数计入 Hits，写入失败的批次计入 Errors
+func (bs *BatchStore) Stats() Stats {
+	stats := bs.store.Stats()
+	stats.Hits += atomic.LoadUint64(&bs.hits)
+	stats.Errors += atomic.LoadUint64(&bs.errors)
+	return stats
+}
+
+func copyBytes(b []byte) []byte {
+	cp := make([]byte, len(b))
+	copy(cp, b)
+	return cp
+}
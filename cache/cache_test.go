@@ -0,0 +1,1081 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: cache_test.go
+ * @Created: 2026-10-18 14:58:20
+ * @Modified: 2026-10-19 00:19:33
+ */
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/thep0y/predator/dao"
+	"github.com/thep0y/predator/tools"
+)
+
+func tempDir() string {
+	dir, err := os.MkdirTemp("", "predator-cache-")
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
+
+func TestSQLiteCacheTTL(t *testing.T) {
+	Convey("测试 SQLite 缓存过期", t, func() {
+		dir := tempDir()
+		defer os.RemoveAll(dir)
+
+		for _, compressed := range []bool{false, true} {
+			sc := &SQLiteCache{URI: filepath.Join(dir, "cache.sqlite")}
+			sc.Compressed(compressed)
+			So(sc.Init(), ShouldBeNil)
+			So(sc.Clear(), ShouldBeNil)
+
+			So(sc.CacheWithTTL("short", []byte("v1"), 50*time.Millisecond), ShouldBeNil)
+			So(sc.Cache("forever", []byte("v2")), ShouldBeNil)
+
+			val, ok := sc.IsCached("short")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "v1")
+
+			time.Sleep(80 * time.Millisecond)
+
+			_, ok = sc.IsCached("short")
+			So(ok, ShouldBeFalse)
+
+			val, ok = sc.IsCached("forever")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "v2")
+
+			// 过期的缓存可以被覆盖
+			So(sc.CacheWithTTL("short", []byte("v3"), time.Hour), ShouldBeNil)
+			val, ok = sc.IsCached("short")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "v3")
+
+			So(sc.CacheWithTTL("expired", []byte("v4"), time.Millisecond), ShouldBeNil)
+			time.Sleep(5 * time.Millisecond)
+			So(sc.PurgeExpired(), ShouldBeNil)
+
+			var count int64
+			So(sc.db.DB.Model(&CacheModel{}).Count(&count).Error, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+		}
+	})
+}
+
+func TestFileCache(t *testing.T) {
+	Convey("测试文件缓存", t, func() {
+		dir := tempDir()
+		defer os.RemoveAll(dir)
+
+		for _, compressed := range []bool{false, true} {
+			fc := &FileCache{Dir: dir}
+			fc.Compressed(compressed)
+			So(fc.Init(), ShouldBeNil)
+			So(fc.Clear(), ShouldBeNil)
+
+			So(fc.Cache("key", []byte("v1")), ShouldBeNil)
+			// 已缓存的不会被覆盖
+			So(fc.Cache("key", []byte("v2")), ShouldBeNil)
+
+			val, ok := fc.IsCached("key")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "v1")
+
+			So(fc.Update("key", []byte("v3"), 0), ShouldBeNil)
+			val, ok = fc.IsCached("key")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "v3")
+
+			_, ok = fc.IsCached("missing")
+			So(ok, ShouldBeFalse)
+
+			path := fc.path("key")
+			So(strings.HasPrefix(path, dir), ShouldBeTrue)
+			_, err := os.Stat(path + fileCacheMetaExt)
+			So(err, ShouldBeNil)
+
+			So(fc.CacheWithTTL("expired", []byte("v4"), time.Millisecond), ShouldBeNil)
+			time.Sleep(5 * time.Millisecond)
+			_, ok = fc.IsCached("expired")
+			So(ok, ShouldBeFalse)
+
+			So(fc.PurgeExpired(), ShouldBeNil)
+			_, err = os.Stat(fc.path("expired") + fileCacheDataExt)
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			// Clear 不删除根目录中的其他文件
+			other := filepath.Join(dir, "other.txt")
+			So(os.WriteFile(other, []byte("other"), 0644), ShouldBeNil)
+			So(fc.Clear(), ShouldBeNil)
+			_, ok = fc.IsCached("key")
+			So(ok, ShouldBeFalse)
+			_, err = os.Stat(other)
+			So(err, ShouldBeNil)
+		}
+
+		Convey("更新时不会读取到不一致的元数据和缓存文件", func() {
+			fc := &FileCache{Dir: dir}
+			So(fc.Init(), ShouldBeNil)
+
+			ttls := map[string]time.Duration{"short": time.Hour, "long value": 2 * time.Hour}
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					val := "short"
+					if i%2 == 1 {
+						val = "long value"
+					}
+					fc.Update("key", []byte(val), ttls[val])
+				}
+			}()
+
+			var mismatched int
+			for i := 0; i < 5000; i++ {
+				e, ok, err := fc.GetEntry(context.Background(), "key")
+				if err != nil || !ok {
+					continue
+				}
+				if e.ExpiresAt.Sub(e.CreatedAt) != ttls[string(e.Value)] {
+					mismatched++
+				}
+			}
+			close(stop)
+			wg.Wait()
+			So(mismatched, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestMemoryCache(t *testing.T) {
+	Convey("测试内存缓存", t, func() {
+		Convey("按条数淘汰", func() {
+			mc := &MemoryCache{MaxEntries: 2}
+			So(mc.Init(), ShouldBeNil)
+
+			So(mc.Cache("a", []byte("1")), ShouldBeNil)
+			So(mc.Cache("b", []byte("2")), ShouldBeNil)
+			// a 最近被使用，淘汰 b
+			_, ok := mc.IsCached("a")
+			So(ok, ShouldBeTrue)
+			So(mc.Cache("c", []byte("3")), ShouldBeNil)
+
+			_, ok = mc.IsCached("b")
+			So(ok, ShouldBeFalse)
+			val, ok := mc.IsCached("c")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "3")
+
+			stats := mc.Stats()
+			So(stats.Hits, ShouldEqual, 2)
+			So(stats.Misses, ShouldEqual, 1)
+			So(stats.Evictions, ShouldEqual, 1)
+			So(stats.Entries, ShouldEqual, 2)
+			// 每条缓存有一个字节的压缩算法标记
+			So(stats.Bytes, ShouldEqual, 4)
+		})
+
+		Convey("按字节数淘汰", func() {
+			mc := &MemoryCache{MaxBytes: 12}
+			So(mc.Init(), ShouldBeNil)
+
+			So(mc.Cache("a", []byte("12345")), ShouldBeNil)
+			So(mc.Cache("b", []byte("12345")), ShouldBeNil)
+			So(mc.Cache("c", []byte("123")), ShouldBeNil)
+			_, ok := mc.IsCached("a")
+			So(ok, ShouldBeFalse)
+			So(mc.Stats().Bytes, ShouldEqual, 10)
+
+			// 超过上限的单条缓存不保存
+			So(mc.Cache("d", make([]byte, 12)), ShouldBeNil)
+			_, ok = mc.IsCached("d")
+			So(ok, ShouldBeFalse)
+			So(mc.Stats().Entries, ShouldEqual, 2)
+		})
+
+		Convey("压缩、覆盖与过期", func() {
+			mc := &MemoryCache{}
+			mc.Compressed(true)
+			So(mc.Init(), ShouldBeNil)
+
+			So(mc.Cache("a", []byte("v1")), ShouldBeNil)
+			So(mc.Cache("a", []byte("v2")), ShouldBeNil)
+			val, _ := mc.IsCached("a")
+			So(string(val), ShouldEqual, "v1")
+
+			So(mc.Update("a", []byte("v3"), 0), ShouldBeNil)
+			val, _ = mc.IsCached("a")
+			So(string(val), ShouldEqual, "v3")
+
+			So(mc.CacheWithTTL("b", []byte("v4"), time.Millisecond), ShouldBeNil)
+			time.Sleep(5 * time.Millisecond)
+			So(mc.PurgeExpired(), ShouldBeNil)
+			So(mc.Stats().Entries, ShouldEqual, 1)
+
+			So(mc.Clear(), ShouldBeNil)
+			_, ok := mc.IsCached("a")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestTieredCache(t *testing.T) {
+	Convey("测试多层缓存", t, func() {
+		dir := tempDir()
+		defer os.RemoveAll(dir)
+
+		for _, writeBehind := range []bool{false, true} {
+			mem := &MemoryCache{}
+			file := &FileCache{Dir: dir}
+			tc := Tiered(mem, file)
+			tc.WriteBehind = writeBehind
+			tc.Compressed(true)
+			So(tc.Init(), ShouldBeNil)
+			So(tc.Clear(), ShouldBeNil)
+
+			So(tc.Cache("a", []byte("v1")), ShouldBeNil)
+			So(tc.Flush(), ShouldBeNil)
+
+			val, ok := file.IsCached("a")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "v1")
+
+			// 只存在于较慢的层时，读取后回填到较快的层
+			So(mem.Clear(), ShouldBeNil)
+			val, ok = tc.IsCached("a")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "v1")
+			val, ok = mem.IsCached("a")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "v1")
+
+			So(tc.Update("a", []byte("v2"), 0), ShouldBeNil)
+			So(tc.Flush(), ShouldBeNil)
+			val, _ = file.IsCached("a")
+			So(string(val), ShouldEqual, "v2")
+
+			_, ok = tc.IsCached("missing")
+			So(ok, ShouldBeFalse)
+		}
+
+		So(Tiered().Init(), ShouldEqual, ErrNoCacheLayer)
+	})
+
+	Convey("测试回填时保留剩余的过期时间", t, func() {
+		dir := tempDir()
+		defer os.RemoveAll(dir)
+
+		mem := &MemoryCache{}
+		file := &FileCache{Dir: dir}
+		tc := Tiered(mem, file)
+		So(tc.Init(), ShouldBeNil)
+
+		So(file.CacheWithTTL("short", []byte("v"), time.Hour), ShouldBeNil)
+		So(file.Cache("forever", []byte("v")), ShouldBeNil)
+
+		e, ok, err := tc.GetEntry(context.Background(), "short")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(e.ExpiresAt, ShouldNotBeNil)
+
+		backfilled, ok, _ := mem.GetEntry(context.Background(), "short")
+		So(ok, ShouldBeTrue)
+		So(backfilled.ExpiresAt, ShouldNotBeNil)
+		So(backfilled.TTL(), ShouldBeBetweenOrEqual, 59*time.Minute, time.Hour)
+
+		// BackfillTTL 是回填的最长过期时间
+		So(mem.Clear(), ShouldBeNil)
+		tc.BackfillTTL = time.Minute
+		_, ok = tc.IsCached("short")
+		So(ok, ShouldBeTrue)
+		backfilled, _, _ = mem.GetEntry(context.Background(), "short")
+		So(backfilled.TTL(), ShouldBeLessThanOrEqualTo, time.Minute)
+
+		_, ok = tc.IsCached("forever")
+		So(ok, ShouldBeTrue)
+		backfilled, _, _ = mem.GetEntry(context.Background(), "forever")
+		So(backfilled.TTL(), ShouldBeLessThanOrEqualTo, time.Minute)
+		So(backfilled.ExpiresAt, ShouldNotBeNil)
+	})
+
+	Convey("测试关闭时还有后台写入", t, func() {
+		dir := tempDir()
+		defer os.RemoveAll(dir)
+
+		tc := Tiered(&MemoryCache{}, &FileCache{Dir: dir})
+		tc.WriteBehind = true
+		So(tc.Init(), ShouldBeNil)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					_ = tc.Set(context.Background(), fmt.Sprintf("%d-%d", i, j), []byte("v"), 0)
+				}
+			}(i)
+		}
+
+		So(tc.Close(), ShouldBeNil)
+		wg.Wait()
+	})
+}
+
+func TestExportImportMigrate(t *testing.T) {
+	Convey("测试导出、导入和迁移缓存", t, func() {
+		dir := tempDir()
+		defer os.RemoveAll(dir)
+
+		src := &MemoryCache{}
+		src.Compressed(true)
+		So(src.Init(), ShouldBeNil)
+		So(src.Cache("a", []byte("v1")), ShouldBeNil)
+		So(src.CacheWithTTL("b", []byte("v2"), time.Hour), ShouldBeNil)
+		So(src.CacheWithTTL("expired", []byte("v3"), time.Millisecond), ShouldBeNil)
+		time.Sleep(5 * time.Millisecond)
+
+		var buf bytes.Buffer
+		count, err := Export(src, &buf)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+
+		dst := &FileCache{Dir: dir}
+		So(dst.Init(), ShouldBeNil)
+		count, err = Import(dst, &buf)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+
+		val, ok := dst.IsCached("a")
+		So(ok, ShouldBeTrue)
+		So(string(val), ShouldEqual, "v1")
+
+		var keys []string
+		So(Range(dst, func(e *Entry) bool {
+			keys = append(keys, e.Key)
+			if e.Key == "b" {
+				So(e.ExpiresAt, ShouldNotBeNil)
+			}
+			return true
+		}), ShouldBeNil)
+		sort.Strings(keys)
+		So(keys, ShouldResemble, []string{"a", "b"})
+
+		mem := &MemoryCache{}
+		So(mem.Init(), ShouldBeNil)
+		count, err = Migrate(dst, mem)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+
+		So(Delete(mem, "a"), ShouldBeNil)
+		_, ok = mem.IsCached("a")
+		So(ok, ShouldBeFalse)
+	})
+}
+
+// brokenCache 模拟只实现了 Cache 且读写时 panic 的第三方缓存
+type brokenCache struct{}
+
+func (brokenCache) Compressed(bool)                {}
+func (brokenCache) Init() error                    { return nil }
+func (brokenCache) IsCached(string) ([]byte, bool) { panic("connection refused") }
+func (brokenCache) Cache(string, []byte) error     { panic(errors.New("connection refused")) }
+func (brokenCache) Clear() error                   { return nil }
+
+func TestStore(t *testing.T) {
+	Convey("测试 Store", t, func() {
+		ctx := context.Background()
+
+		Convey("已实现的缓存", func() {
+			dir := tempDir()
+			defer os.RemoveAll(dir)
+
+			sc := &SQLiteCache{URI: filepath.Join(dir, "cache.sqlite")}
+			sc.Compressed(true)
+			So(sc.Init(), ShouldBeNil)
+
+			for _, c := range []Cache{&MemoryCache{}, &FileCache{Dir: dir}, sc, Tiered(&MemoryCache{}, &FileCache{Dir: filepath.Join(dir, "tiered")})} {
+				So(c.Init(), ShouldBeNil)
+				s := NewStore(c)
+				So(Unwrap(s), ShouldEqual, c)
+
+				_, ok, err := s.Get(ctx, "a")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+
+				So(s.Set(ctx, "a", []byte("1"), 0), ShouldBeNil)
+				// Set 会覆盖已有的缓存
+				So(s.Set(ctx, "a", []byte("2"), 0), ShouldBeNil)
+				val, ok, err := s.Get(ctx, "a")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+				So(string(val), ShouldEqual, "2")
+
+				So(s.Set(ctx, "b", []byte("3"), time.Nanosecond), ShouldBeNil)
+				time.Sleep(time.Millisecond)
+				_, ok, err = s.Get(ctx, "b")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+				val, ok, err = s.(StaleStore).GetStale(ctx, "b")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+				So(string(val), ShouldEqual, "3")
+
+				So(s.Delete(ctx, "a"), ShouldBeNil)
+				_, ok, _ = s.Get(ctx, "a")
+				So(ok, ShouldBeFalse)
+
+				stats := s.Stats()
+				So(stats.Hits, ShouldEqual, 2)
+				So(stats.Misses, ShouldEqual, 3)
+				So(stats.Errors, ShouldEqual, 0)
+
+				So(s.Close(), ShouldBeNil)
+			}
+		})
+
+		Convey("取消的 context", func() {
+			s := NewStore(&MemoryCache{})
+			So(Unwrap(s).Init(), ShouldBeNil)
+
+			canceled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, _, err := s.Get(canceled, "a")
+			So(err, ShouldEqual, context.Canceled)
+			So(s.Set(canceled, "a", []byte("1"), 0), ShouldEqual, context.Canceled)
+		})
+
+		Convey("panic 转换为错误", func() {
+			s := NewStore(brokenCache{})
+
+			_, ok, err := s.Get(ctx, "a")
+			So(ok, ShouldBeFalse)
+			So(err, ShouldBeError, "cache panic: connection refused")
+
+			So(s.Set(ctx, "a", []byte("1"), 0), ShouldBeError, "connection refused")
+
+			_, ok, err = s.(StaleStore).GetStale(ctx, "a")
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			So(s.Delete(ctx, "a"), ShouldEqual, ErrNotSupported)
+			So(s.Stats().Errors, ShouldEqual, 2)
+		})
+
+		Convey("解码失败返回错误", func() {
+			dir := tempDir()
+			defer os.RemoveAll(dir)
+
+			fc := &FileCache{Dir: dir}
+			fc.Compressed(true)
+			So(fc.Init(), ShouldBeNil)
+			So(fc.Cache("a", bytes.Repeat([]byte("a"), 100)), ShouldBeNil)
+			// 破坏压缩后的数据
+			So(os.WriteFile(fc.path("a")+fileCacheDataExt, []byte{byte(tools.CodecZlib), 1, 2, 3}, 0644), ShouldBeNil)
+
+			_, ok, err := NewStore(fc).Get(ctx, "a")
+			So(ok, ShouldBeFalse)
+			So(err, ShouldNotBeNil)
+			// 旧接口不再 panic
+			_, ok = fc.IsCached("a")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestCompression(t *testing.T) {
+	Convey("测试压缩算法", t, func() {
+		val := bytes.Repeat([]byte(`{"status":200,"body":"hello"}`), 50)
+
+		Convey("编码和解码", func() {
+			for _, codec := range []tools.Codec{tools.CodecNone, tools.CodecZlib, tools.CodecGzip, tools.CodecZstd, tools.CodecSnappy} {
+				enc, err := tools.Encode(codec, val)
+				So(err, ShouldBeNil)
+				So(tools.CodecOf(enc), ShouldEqual, codec)
+
+				dec, err := tools.Decode(enc)
+				So(err, ShouldBeNil)
+				So(bytes.Equal(dec, val), ShouldBeTrue)
+
+				parsed, err := tools.ParseCodec(codec.String())
+				So(err, ShouldBeNil)
+				So(parsed, ShouldEqual, codec)
+			}
+
+			// 压缩后没有变小时不压缩
+			enc, err := tools.Encode(tools.CodecZstd, []byte("a"))
+			So(err, ShouldBeNil)
+			So(tools.CodecOf(enc), ShouldEqual, tools.CodecNone)
+
+			// 没有标记的旧数据
+			dec, err := tools.Decode(tools.Compress(val))
+			So(err, ShouldBeNil)
+			So(bytes.Equal(dec, val), ShouldBeTrue)
+			dec, err = tools.Decode(val)
+			So(err, ShouldBeNil)
+			So(bytes.Equal(dec, val), ShouldBeTrue)
+
+			_, err = tools.Decode([]byte{byte(tools.CodecZstd), 1, 2, 3})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("修改压缩设置后仍能读取之前的缓存", func() {
+			dir := tempDir()
+			defer os.RemoveAll(dir)
+
+			for _, newCache := range []func() Cache{
+				func() Cache { return &SQLiteCache{URI: filepath.Join(dir, "cache.sqlite")} },
+				func() Cache { return &FileCache{Dir: dir} },
+			} {
+				settings := []Compression{
+					{Codec: tools.CodecZstd},
+					{Codec: tools.CodecSnappy},
+					{Codec: tools.CodecGzip, MinSize: 1 << 20},
+					{compressed: true},
+					{},
+				}
+
+				for i, comp := range settings {
+					c := newCache()
+					switch cc := c.(type) {
+					case *SQLiteCache:
+						cc.Compression = comp
+					case *FileCache:
+						cc.Compression = comp
+					}
+					So(c.Init(), ShouldBeNil)
+					So(c.Cache(fmt.Sprint(i), val), ShouldBeNil)
+
+					for j := 0; j <= i; j++ {
+						got, ok := c.IsCached(fmt.Sprint(j))
+						So(ok, ShouldBeTrue)
+						So(bytes.Equal(got, val), ShouldBeTrue)
+					}
+				}
+			}
+		})
+
+		Convey("读取旧版本的缓存", func() {
+			dir := tempDir()
+			defer os.RemoveAll(dir)
+
+			sc := &SQLiteCache{URI: filepath.Join(dir, "cache.sqlite")}
+			So(sc.Init(), ShouldBeNil)
+			So(sc.sqlCache.db.Save(newCacheModel("zlib", tools.Compress(val), 0)).Error, ShouldBeNil)
+			So(sc.sqlCache.db.Save(newCacheModel("raw", val, 0)).Error, ShouldBeNil)
+
+			for _, key := range []string{"zlib", "raw"} {
+				got, ok := sc.IsCached(key)
+				So(ok, ShouldBeTrue)
+				So(bytes.Equal(got, val), ShouldBeTrue)
+			}
+		})
+	})
+}
+
+func TestBatchStore(t *testing.T) {
+	Convey("测试批量写入", t, func() {
+		ctx := context.Background()
+
+		Convey("SQL 缓存的写入", func() {
+			dir := tempDir()
+			defer os.RemoveAll(dir)
+
+			sc := &SQLiteCache{URI: filepath.Join(dir, "cache.sqlite")}
+			So(sc.Init(), ShouldBeNil)
+
+			// 未过期的缓存不会被覆盖
+			So(sc.CacheWithTTL("a", []byte("v1"), time.Hour), ShouldBeNil)
+			So(sc.CacheWithTTL("a", []byte("v2"), time.Hour), ShouldBeNil)
+			val, _ := sc.IsCached("a")
+			So(string(val), ShouldEqual, "v1")
+
+			expiresAt := time.Now().Add(time.Hour)
+			entries := []*Entry{
+				{Key: "a", Value: []byte("v3"), CreatedAt: time.Now()},
+				{Key: "b", Value: []byte("v4"), CreatedAt: time.Now(), ExpiresAt: &expiresAt},
+			}
+			for i := 0; i < sqlBatchSize; i++ {
+				entries = append(entries, &Entry{Key: fmt.Sprint("batch-", i), Value: []byte("v"), CreatedAt: time.Now()})
+			}
+			So(sc.SetMany(ctx, entries), ShouldBeNil)
+
+			val, _ = sc.IsCached("a")
+			So(string(val), ShouldEqual, "v3")
+			val, _ = sc.IsCached("b")
+			So(string(val), ShouldEqual, "v4")
+
+			var count int64
+			So(sc.sqlCache.db.Model(&CacheModel{}).Count(&count).Error, ShouldBeNil)
+			So(count, ShouldEqual, sqlBatchSize+2)
+		})
+
+		Convey("后台写入", func() {
+			mc := &MemoryCache{}
+			So(mc.Init(), ShouldBeNil)
+
+			bs := NewBatchStore(NewStore(mc), 3, time.Hour)
+			So(Unwrap(bs), ShouldEqual, mc)
+
+			So(bs.Set(ctx, "a", []byte("1"), 0), ShouldBeNil)
+			So(bs.Set(ctx, "b", []byte("2"), time.Nanosecond), ShouldBeNil)
+
+			// 还未写入时也能读取
+			_, ok := mc.IsCached("a")
+			So(ok, ShouldBeFalse)
+			val, ok, err := bs.Get(ctx, "a")
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "1")
+			time.Sleep(time.Millisecond)
+			_, ok, _ = bs.Get(ctx, "b")
+			So(ok, ShouldBeFalse)
+			_, ok, _ = bs.GetStale(ctx, "b")
+			So(ok, ShouldBeTrue)
+
+			So(bs.Flush(), ShouldBeNil)
+			val, ok = mc.IsCached("a")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "1")
+			// 写入前已过期的缓存被丢弃
+			So(mc.Stats().Entries, ShouldEqual, 1)
+
+			// 达到 flushSize 时在后台写入
+			for i := 0; i < 3; i++ {
+				So(bs.Set(ctx, fmt.Sprint(i), []byte("v"), 0), ShouldBeNil)
+			}
+			So(waitFor(func() bool { return mc.Stats().Entries == 4 }), ShouldBeTrue)
+
+			So(bs.Set(ctx, "c", []byte("3"), 0), ShouldBeNil)
+			So(bs.Delete(ctx, "c"), ShouldBeNil)
+			So(bs.Set(ctx, "d", []byte("4"), 0), ShouldBeNil)
+			So(bs.Close(), ShouldBeNil)
+			_, ok = mc.IsCached("c")
+			So(ok, ShouldBeFalse)
+			_, ok = mc.IsCached("d")
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("写入出错", func() {
+			bs := NewBatchStore(NewStore(brokenCache{}), 10, time.Hour)
+			So(bs.Set(ctx, "a", []byte("1"), 0), ShouldBeNil)
+			So(bs.Flush(), ShouldBeError, "connection refused")
+			So(bs.Stats().Errors, ShouldBeGreaterThan, 0)
+			So(bs.Flush(), ShouldBeNil)
+		})
+	})
+}
+
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestDedup(t *testing.T) {
+	Convey("测试响应体去重", t, func() {
+		Convey("拼接和拆分", func() {
+			val := JoinBody([]byte("meta"), []byte("body"))
+			meta, body, ok := SplitBody(val)
+			So(ok, ShouldBeTrue)
+			So(string(meta), ShouldEqual, "meta")
+			So(string(body), ShouldEqual, "body")
+
+			_, _, ok = SplitBody([]byte(`{"v":1}`))
+			So(ok, ShouldBeFalse)
+			// 长度超出范围
+			_, _, ok = SplitBody(append(append([]byte{}, bodyMagic...), 10, 'a'))
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("SQL 缓存", func() {
+			dir := tempDir()
+			defer os.RemoveAll(dir)
+
+			sc := &SQLiteCache{
+				URI:         filepath.Join(dir, "cache.sqlite"),
+				Compression: Compression{Codec: tools.CodecZstd},
+				Dedup:       true,
+			}
+			So(sc.Init(), ShouldBeNil)
+
+			body := bytes.Repeat([]byte("<p>mirror</p>"), 100)
+			values := map[string][]byte{
+				"a": JoinBody([]byte("https://a.com/"), body),
+				"b": JoinBody([]byte("https://b.com/"), body),
+				// 没有拼接的值整体作为响应体
+				"c": body,
+			}
+			for key, val := range values {
+				So(sc.Cache(key, val), ShouldBeNil)
+			}
+			for key, val := range values {
+				cached, ok := sc.IsCached(key)
+				So(ok, ShouldBeTrue)
+				So(cached, ShouldResemble, val)
+			}
+
+			stats, err := sc.DedupStats()
+			So(err, ShouldBeNil)
+			So(stats, ShouldResemble, DedupStats{
+				Entries:      3,
+				Bodies:       1,
+				LogicalBytes: int64(3 * len(body)),
+				StoredBytes:  int64(len(body)),
+			})
+			So(stats.SavedBytes(), ShouldEqual, 2*len(body))
+			So(stats.Ratio(), ShouldEqual, 3)
+
+			// 覆盖和删除后，不再被引用的响应体被删除
+			So(sc.Update("a", JoinBody([]byte("https://a.com/"), []byte("new")), 0), ShouldBeNil)
+			stats, _ = sc.DedupStats()
+			So(stats.Bodies, ShouldEqual, 2)
+			So(sc.Delete("b"), ShouldBeNil)
+			So(sc.Delete("c"), ShouldBeNil)
+			stats, _ = sc.DedupStats()
+			So(stats.Entries, ShouldEqual, 1)
+			So(stats.Bodies, ShouldEqual, 1)
+			So(stats.StoredBytes, ShouldEqual, 3)
+
+			// 批量写入和过期
+			expiresAt := time.Now().Add(time.Millisecond)
+			So(sc.SetMany(context.Background(), []*Entry{
+				{Key: "d", Value: body, CreatedAt: time.Now(), ExpiresAt: &expiresAt},
+				{Key: "e", Value: []byte("new"), CreatedAt: time.Now()},
+			}), ShouldBeNil)
+			stats, _ = sc.DedupStats()
+			So(stats.Entries, ShouldEqual, 3)
+			So(stats.Bodies, ShouldEqual, 2)
+
+			time.Sleep(2 * time.Millisecond)
+			So(sc.PurgeExpired(), ShouldBeNil)
+			stats, _ = sc.DedupStats()
+			So(stats.Bodies, ShouldEqual, 1)
+			So(stats.LogicalBytes, ShouldEqual, 6)
+
+			var keys []string
+			So(sc.Range(func(e *Entry) bool {
+				keys = append(keys, e.Key)
+				So(bytes.HasSuffix(e.Value, []byte("new")), ShouldBeTrue)
+				return true
+			}), ShouldBeNil)
+			sort.Strings(keys)
+			So(keys, ShouldResemble, []string{"a", "e"})
+
+			// 关闭去重后覆盖的缓存不再引用响应体，由 CollectGarbage 删除
+			sc.sqlCache.dedup = false
+			So(sc.Update("a", []byte("plain"), 0), ShouldBeNil)
+			So(sc.Update("e", []byte("plain"), 0), ShouldBeNil)
+			val, _ := sc.IsCached("e")
+			So(string(val), ShouldEqual, "plain")
+			stats, _ = sc.DedupStats()
+			So(stats.Entries, ShouldEqual, 0)
+			So(stats.Bodies, ShouldEqual, 1)
+			So(sc.CollectGarbage(), ShouldBeNil)
+			stats, _ = sc.DedupStats()
+			So(stats.Bodies, ShouldEqual, 0)
+
+			So(sc.Clear(), ShouldBeNil)
+		})
+
+		Convey("已存在的缓存不会被覆盖", func() {
+			dir := tempDir()
+			defer os.RemoveAll(dir)
+
+			sc := &SQLiteCache{URI: filepath.Join(dir, "cache.sqlite"), Dedup: true}
+			So(sc.Init(), ShouldBeNil)
+
+			So(sc.Cache("a", JoinBody([]byte("a"), []byte("v1"))), ShouldBeNil)
+			So(sc.Cache("a", JoinBody([]byte("a"), []byte("v2"))), ShouldBeNil)
+			val, ok := sc.IsCached("a")
+			So(ok, ShouldBeTrue)
+			So(val, ShouldResemble, JoinBody([]byte("a"), []byte("v1")))
+
+			// 没有写入的缓存不会留下响应体
+			stats, err := sc.DedupStats()
+			So(err, ShouldBeNil)
+			So(stats.Bodies, ShouldEqual, 1)
+
+			// 已过期的缓存被覆盖，旧的响应体被删除
+			So(sc.CacheWithTTL("b", JoinBody([]byte("b"), []byte("v3")), time.Millisecond), ShouldBeNil)
+			time.Sleep(2 * time.Millisecond)
+			So(sc.Cache("b", JoinBody([]byte("b"), []byte("v4"))), ShouldBeNil)
+			val, ok = sc.IsCached("b")
+			So(ok, ShouldBeTrue)
+			So(val, ShouldResemble, JoinBody([]byte("b"), []byte("v4")))
+
+			stats, _ = sc.DedupStats()
+			So(stats.Entries, ShouldEqual, 2)
+			So(stats.Bodies, ShouldEqual, 2)
+		})
+
+		Convey("并发写入重叠的批次", func() {
+			dir := tempDir()
+			defer os.RemoveAll(dir)
+
+			sc := &SQLiteCache{URI: filepath.Join(dir, "cache.sqlite"), Dedup: true}
+			So(sc.Init(), ShouldBeNil)
+
+			bodies := [][]byte{[]byte("b0"), []byte("b1"), []byte("b2"), []byte("b3")}
+			var wg sync.WaitGroup
+			errs := make(chan error, 40)
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 5; i++ {
+						// 每个批次引用相同的响应体，但顺序不同
+						entries := make([]*Entry, 0, len(bodies))
+						for j := range bodies {
+							body := bodies[(j+w)%len(bodies)]
+							key := fmt.Sprintf("%d-%d", (j+w+i)%6, j)
+							entries = append(entries, &Entry{
+								Key:       key,
+								Value:     JoinBody([]byte(key), body),
+								CreatedAt: time.Now(),
+							})
+						}
+						errs <- sc.SetMany(context.Background(), entries)
+					}
+				}(w)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				So(err, ShouldBeNil)
+			}
+
+			var refs []struct {
+				Hash string
+				Refs int64
+			}
+			So(sc.db.DB.Model(&BodyModel{}).Select("hash, refs").Scan(&refs).Error, ShouldBeNil)
+			So(len(refs), ShouldBeLessThanOrEqualTo, len(bodies))
+			for _, r := range refs {
+				var n int64
+				So(sc.db.DB.Model(&CacheModel{}).Where(&CacheModel{Hash: r.Hash}).Count(&n).Error, ShouldBeNil)
+				So(r.Refs, ShouldEqual, n)
+			}
+
+			So(sc.Range(func(e *Entry) bool {
+				meta, _, ok := SplitBody(e.Value)
+				So(ok, ShouldBeTrue)
+				So(string(meta), ShouldEqual, e.Key)
+				return true
+			}), ShouldBeNil)
+		})
+
+		Convey("加锁前排序并去重", func() {
+			So(sortedUnique([]string{"c", "a", "b", "a", "c"}), ShouldResemble, []string{"a", "b", "c"})
+			So(sortedUnique(nil), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestRedisCache(t *testing.T) {
+	Convey("测试 redis 缓存", t, func() {
+		s, err := miniredis.Run()
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		ctx := context.Background()
+
+		rc := &RedisCache{
+			Addr:        s.Addr(),
+			Prefix:      "test",
+			Compression: Compression{Codec: tools.CodecZstd},
+		}
+		So(rc.Init(), ShouldBeNil)
+		defer rc.Close()
+
+		Convey("SET NX EX 和二进制值", func() {
+			val := bytes.Repeat([]byte("predator"), 100)
+			So(rc.CacheWithTTL("a", val, time.Hour), ShouldBeNil)
+			So(rc.CacheWithTTL("a", []byte("v2"), time.Hour), ShouldBeNil)
+			cached, ok := rc.IsCached("a")
+			So(ok, ShouldBeTrue)
+			So(cached, ShouldResemble, val)
+
+			So(s.TTL("test:a"), ShouldEqual, time.Hour)
+			raw, err := s.Get("test:a")
+			So(err, ShouldBeNil)
+			So(tools.CodecOf([]byte(raw)), ShouldEqual, tools.CodecZstd)
+
+			So(rc.Update("a", []byte("v3"), 0), ShouldBeNil)
+			cached, _ = rc.IsCached("a")
+			So(string(cached), ShouldEqual, "v3")
+			So(s.TTL("test:a"), ShouldEqual, 0)
+
+			So(rc.CacheWithTTL("b", []byte("v"), time.Minute), ShouldBeNil)
+			s.FastForward(time.Minute)
+			_, ok = rc.IsCached("b")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("读取旧版本 base64 编码的值", func() {
+			So(s.Set("test:old", base64.StdEncoding.EncodeToString([]byte("old"))), ShouldBeNil)
+			compressed := tools.Compress([]byte("compressed"))
+			So(s.Set("test:zlib", base64.StdEncoding.EncodeToString(compressed)), ShouldBeNil)
+
+			val, ok := rc.IsCached("old")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "old")
+			val, ok = rc.IsCached("zlib")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "compressed")
+		})
+
+		Convey("批量读写", func() {
+			expired := time.Now().Add(-time.Second)
+			expiresAt := time.Now().Add(time.Hour)
+			So(rc.SetMany(ctx, []*Entry{
+				{Key: "a", Value: []byte("1")},
+				{Key: "b", Value: []byte("2"), ExpiresAt: &expiresAt},
+				{Key: "c", Value: []byte("3"), ExpiresAt: &expired},
+			}), ShouldBeNil)
+
+			vals, err := rc.GetMany(ctx, []string{"a", "b", "c"})
+			So(err, ShouldBeNil)
+			So(vals, ShouldResemble, map[string][]byte{"a": []byte("1"), "b": []byte("2")})
+			So(s.TTL("test:b"), ShouldBeGreaterThan, 0)
+		})
+
+		Convey("键前缀", func() {
+			other := &RedisCache{Addr: s.Addr()}
+			So(other.Init(), ShouldBeNil)
+			defer other.Close()
+			So(other.Cache("a", []byte("other")), ShouldBeNil)
+			So(s.Exists("predator-cache:a"), ShouldBeTrue)
+
+			for i := 0; i < 150; i++ {
+				So(rc.Cache(fmt.Sprint("key-", i), []byte("v")), ShouldBeNil)
+			}
+
+			var count int
+			So(rc.Range(func(e *Entry) bool {
+				So(e.Key, ShouldStartWith, "key-")
+				count++
+				return true
+			}), ShouldBeNil)
+			So(count, ShouldEqual, 150)
+
+			So(rc.Clear(), ShouldBeNil)
+			So(len(s.Keys()), ShouldEqual, 1)
+			val, ok := other.IsCached("a")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "other")
+		})
+
+		Convey("连接选项", func() {
+			uc := &RedisCache{Options: &redis.UniversalOptions{Addrs: []string{s.Addr()}}}
+			So(uc.Init(), ShouldBeNil)
+			So(uc.Cache("a", []byte("1")), ShouldBeNil)
+
+			cc := &RedisCache{Client: redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{s.Addr()}})}
+			So(cc.Init(), ShouldBeNil)
+			defer cc.Close()
+			val, ok := cc.IsCached("a")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "1")
+
+			var keys []string
+			So(cc.Range(func(e *Entry) bool {
+				keys = append(keys, e.Key)
+				return true
+			}), ShouldBeNil)
+			So(keys, ShouldResemble, []string{"a"})
+			So(cc.Clear(), ShouldBeNil)
+			So(s.Keys(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestSchemaMigration(t *testing.T) {
+	Convey("测试 SQL 缓存的表结构迁移", t, func() {
+		dir := tempDir()
+		defer os.RemoveAll(dir)
+		uri := filepath.Join(dir, "cache.sqlite")
+
+		// 旧版本创建的数据库，没有迁移表
+		db := &dao.Sqlite{URI: uri}
+		So(db.Init(), ShouldBeNil)
+		So(db.AutoMigrate(&cacheV1{}), ShouldBeNil)
+		So(db.Insert(&cacheV1{Key: "old", Value: []byte("old value")}), ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		sc := &SQLiteCache{URI: uri}
+		So(sc.Init(), ShouldBeNil)
+		defer sc.Close()
+
+		val, ok := sc.IsCached("old")
+		So(ok, ShouldBeTrue)
+		So(string(val), ShouldEqual, "old value")
+		So(sc.db.DB.Migrator().HasColumn(&CacheModel{}, "expires_at"), ShouldBeTrue)
+		So(sc.db.DB.Migrator().HasColumn(&CacheModel{}, "hash"), ShouldBeTrue)
+		So(sc.db.DB.Migrator().HasTable(&BodyModel{}), ShouldBeTrue)
+
+		m, err := SchemaMigrator(sc.db)
+		So(err, ShouldBeNil)
+		version, err := m.Version()
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, 3)
+
+		// 已执行的迁移不会重复执行
+		So(migrateSchema(sc.db), ShouldBeNil)
+		applied, err := m.Applied()
+		So(err, ShouldBeNil)
+		So(applied, ShouldResemble, []uint{1, 2, 3})
+
+		Convey("回滚", func() {
+			sc.sqlCache.dedup = true
+			So(sc.CacheWithTTL("dedup", []byte("body"), time.Hour), ShouldBeNil)
+
+			So(m.To(1), ShouldBeNil)
+			So(sc.db.DB.Migrator().HasColumn(&CacheModel{}, "expires_at"), ShouldBeFalse)
+			So(sc.db.DB.Migrator().HasColumn(&CacheModel{}, "hash"), ShouldBeFalse)
+			So(sc.db.DB.Migrator().HasTable(&BodyModel{}), ShouldBeFalse)
+			So(sc.db.DB.Migrator().HasIndex(&cacheV2{}, "ExpiresAt"), ShouldBeFalse)
+			So(sc.db.DB.Migrator().HasIndex(&cacheV3{}, "Hash"), ShouldBeFalse)
+
+			var keys []string
+			So(sc.db.DB.Model(&cacheV1{}).Pluck("key", &keys).Error, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"old"})
+
+			So(m.Up(), ShouldBeNil)
+			val, ok := sc.IsCached("old")
+			So(ok, ShouldBeTrue)
+			So(string(val), ShouldEqual, "old value")
+			So(sc.db.DB.Migrator().HasIndex(&CacheModel{}, "idx_cache_expires_at"), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,56 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: codec.go
+ * @Created: 2026-10-18 23:03:04
+ * @Modified: 2026-10-18 23:03:04
+ */
+
+package cache
+
+import "github.com/thep0y/predator/tools"
+
+// Compression 缓存值的压缩设置，已实现的缓存都内嵌了此结构体：
+//
+//	&cache.SQLiteCache{
+//		Compression: cache.Compression{Codec: tools.CodecZstd, MinSize: 1024},
+//	}
+//
+// 每条缓存都记录了使用的压缩算法，修改压缩设置后，之前的缓存仍然可以正常读取。
+type Compression struct {
+	// 压缩算法，为 0 时由 Compressed 决定：开启压缩时使用 zlib，否则不压缩。
+	// 设置后 Compressed 不再起作用
+	Codec tools.Codec
+	// 小于此字节数的值不压缩，压缩很短的值得不偿失
+	MinSize int
+
+	compressed bool
+}
+
+func (c *Compression) Compressed(yes bool) {
+	c.compressed = yes
+}
+
+func (c *Compression) codec() tools.Codec {
+	if c.Codec != 0 {
+		return c.Codec
+	}
+	if c.compressed {
+		return tools.CodecZlib
+	}
+	return tools.CodecNone
+}
+
+// encode 按压缩设置编码缓存值
+func (c *Compression) encode(val []byte) ([]byte, error) {
+	codec := c.codec()
+	if len(val) < c.MinSize {
+		codec = tools.CodecNone
+	}
+	return tools.Encode(codec, val)
+}
+
+// decode 解码任意压缩设置下编码的缓存值，以及旧版本没有标记的缓存值
+func decode(val []byte) ([]byte, error) {
+	return tools.Decode(val)
+}
@@ -0,0 +1,453 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: dedup.go
+ * @Created: 2026-10-18 23:15:06
+ * @Modified: 2026-10-19 00:19:33
+ */
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// bodyMagic 是 JoinBody 生成的缓存值的开头
+var bodyMagic = []byte{0xff, 'P', 'B'}
+
+// JoinBody 将元数据和响应体拼接为一条缓存值。
+//
+// 开启去重的 SQL 缓存只对响应体去重，元数据（如链接、抓取时间）每条缓存各自保存，
+// 所以同一个响应体在不同链接下只保存一次。没有用 JoinBody 拼接的缓存值整体去重。
+func JoinBody(meta, body []byte) []byte {
+	val := make([]byte, 0, len(bodyMagic)+binary.MaxVarintLen64+len(meta)+len(body))
+	val = append(val, bodyMagic...)
+	val = appendUvarint(val, uint64(len(meta)))
+	val = append(val, meta...)
+	return append(val, body...)
+}
+
+func appendUvarint(dst []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(dst, buf[:n]...)
+}
+
+// SplitBody 拆分 JoinBody 拼接的缓存值，不是 JoinBody 拼接的值返回 false
+func SplitBody(val []byte) (meta, body []byte, ok bool) {
+	if !bytes.HasPrefix(val, bodyMagic) {
+		return nil, nil, false
+	}
+
+	rest := val[len(bodyMagic):]
+	n, size := binary.Uvarint(rest)
+	if size <= 0 || n > uint64(len(rest)-size) {
+		return nil, nil, false
+	}
+	rest = rest[size:]
+	return rest[:n], rest[n:], true
+}
+
+// splitValue 将缓存值拆分为响应体之前的部分和响应体，head 和 body 拼接后与 val 相同
+func splitValue(val []byte) (head, body []byte) {
+	if _, body, ok := SplitBody(val); ok {
+		return val[:len(val)-len(body)], body
+	}
+	return nil, val
+}
+
+// BodyModel 是去重后的响应体，以内容的 sha256 为主键
+type BodyModel struct {
+	Hash  string `gorm:"primaryKey;size:64"`
+	Value []byte
+	// 未压缩的长度
+	Size int64
+	// 引用此响应体的缓存条数，为 0 时被删除
+	Refs      int64
+	CreatedAt time.Time
+}
+
+func (BodyModel) TableName() string {
+	return "cache_bodies"
+}
+
+// DedupStats 响应体去重的统计，字节数都是未压缩的长度
+type DedupStats struct {
+	// 引用了响应体的缓存条数
+	Entries int64
+	// 去重后保存的响应体个数
+	Bodies int64
+	// 去重前所有缓存的响应体的总字节数
+	LogicalBytes int64
+	// 去重后实际保存的响应体的总字节数
+	StoredBytes int64
+}
+
+// SavedBytes 去重节省的字节数
+func (s DedupStats) SavedBytes() int64 {
+	return s.LogicalBytes - s.StoredBytes
+}
+
+// Ratio 去重前后的字节数之比，没有缓存时为 1
+func (s DedupStats) Ratio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+	return float64(s.LogicalBytes) / float64(s.StoredBytes)
+}
+
+// DedupCache 按内容去重保存响应体的缓存
+type DedupCache interface {
+	Cache
+	DedupStats() (DedupStats, error)
+	// 重新计算响应体的引用数，并删除没有被引用的响应体
+	CollectGarbage() error
+}
+
+// saveDedup 将响应体和其余部分分开保存，需要在事务中调用
+func (sc *sqlCache) saveDedup(tx *gorm.DB, models []*CacheModel) error {
+	if len(models) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(models))
+	for _, m := range models {
+		keys = append(keys, m.Key)
+	}
+
+	// 被覆盖的缓存引用的响应体
+	var oldHashes []string
+	err := tx.Model(&CacheModel{}).
+		Where(map[string]interface{}{"key": keys}).
+		Where("hash <> ''").
+		Distinct().
+		Pluck("hash", &oldHashes).Error
+	if err != nil {
+		return err
+	}
+
+	bodies, err := sc.splitBodies(models)
+	if err != nil {
+		return err
+	}
+
+	hashes, err := saveBodies(tx, bodies, oldHashes)
+	if err != nil {
+		return err
+	}
+
+	err = upsert(tx, models)
+	if err != nil {
+		return err
+	}
+
+	return recountBodies(tx, hashes)
+}
+
+// errCacheExists 同名的缓存已存在且未过期，回滚已写入的响应体
+var errCacheExists = errors.New("cache already exists")
+
+// cacheDedup 与非去重的 CacheWithTTL 相同，用 INSERT ... ON CONFLICT DO NOTHING 写入，
+// 只在缓存不存在或已过期时写入，写入后才重新计算引用数
+func (sc *sqlCache) cacheDedup(m *CacheModel) error {
+	bodies, err := sc.splitBodies([]*CacheModel{m})
+	if err != nil {
+		return err
+	}
+
+	err = sc.db.Transaction(func(tx *gorm.DB) error {
+		_, err := saveBodies(tx, bodies, nil)
+		if err != nil {
+			return err
+		}
+
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(m)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		var oldHashes []string
+		if res.RowsAffected == 0 {
+			// 已存在同名的缓存，只覆盖已过期的
+			expired := func(db *gorm.DB) *gorm.DB {
+				return db.Where(&CacheModel{Key: m.Key}).
+					Where("expires_at IS NOT NULL AND expires_at <= ?", m.CreatedAt)
+			}
+
+			err = tx.Model(&CacheModel{}).Scopes(expired).Where("hash <> ''").Pluck("hash", &oldHashes).Error
+			if err != nil {
+				return err
+			}
+
+			res = tx.Model(&CacheModel{}).Scopes(expired).Updates(map[string]interface{}{
+				"value":      m.Value,
+				"created_at": m.CreatedAt,
+				"expires_at": m.ExpiresAt,
+				"hash":       m.Hash,
+			})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return errCacheExists
+			}
+		}
+
+		return recountBodies(tx, sortedUnique(append(oldHashes, m.Hash)))
+	})
+	if errors.Is(err, errCacheExists) {
+		return nil
+	}
+	return err
+}
+
+// splitBodies 将缓存值中的响应体拆分出来，models 中只保留压缩后的其余部分和响应体的 hash。
+// 返回的响应体按 hash 排序，相同的响应体只有一个
+func (sc *sqlCache) splitBodies(models []*CacheModel) ([]*BodyModel, error) {
+	bodies := make(map[string]*BodyModel, len(models))
+	list := make([]*BodyModel, 0, len(models))
+	for _, m := range models {
+		head, body := splitValue(m.Value)
+
+		sum := sha256.Sum256(body)
+		m.Hash = hex.EncodeToString(sum[:])
+
+		if _, ok := bodies[m.Hash]; !ok {
+			val, err := sc.compression.encode(body)
+			if err != nil {
+				return nil, err
+			}
+			bm := &BodyModel{
+				Hash:      m.Hash,
+				Value:     val,
+				Size:      int64(len(body)),
+				CreatedAt: m.CreatedAt,
+			}
+			bodies[m.Hash] = bm
+			list = append(list, bm)
+		}
+
+		var err error
+		m.Value, err = sc.compression.encode(head)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Hash < list[j].Hash
+	})
+	return list, nil
+}
+
+// saveBodies 写入还没有保存过的响应体，并锁定 bodies 和 others 中的响应体直到事务结束，
+// 事务提交前其他事务不能把它们当作没有被引用的响应体删除。返回排序、去重后的全部 hash。
+//
+// 先写入再锁定，只锁定已存在的行：MySQL 在 REPEATABLE READ 下锁定不存在的行会加间隙锁，
+// 与之后的写入冲突。所有事务都按 hash 的顺序写入和锁定，重叠的批次不会互相死锁
+func saveBodies(tx *gorm.DB, bodies []*BodyModel, others []string) ([]string, error) {
+	hashes := make([]string, 0, len(bodies)+len(others))
+	for _, bm := range bodies {
+		hashes = append(hashes, bm.Hash)
+	}
+	hashes = sortedUnique(append(hashes, others...))
+
+	for len(bodies) > 0 {
+		// 已保存过的响应体不再写入
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(bodies, sqlBatchSize).Error
+		if err != nil {
+			return nil, err
+		}
+
+		locked, err := lockBodies(tx, hashes)
+		if err != nil {
+			return nil, err
+		}
+
+		// 写入后、锁定前被其他事务回收的响应体需要重新写入
+		exists := make(map[string]bool, len(locked))
+		for _, hash := range locked {
+			exists[hash] = true
+		}
+		missing := bodies[:0:0]
+		for _, bm := range bodies {
+			if !exists[bm.Hash] {
+				missing = append(missing, bm)
+			}
+		}
+		bodies = missing
+	}
+	return hashes, nil
+}
+
+// sortedUnique 排序并去重，按相同的顺序加锁才不会死锁
+func sortedUnique(hashes []string) []string {
+	sort.Strings(hashes)
+	n := 0
+	for i, hash := range hashes {
+		if i == 0 || hash != hashes[n-1] {
+			hashes[n] = hash
+			n++
+		}
+	}
+	return hashes[:n]
+}
+
+// lockBodies 用 SELECT ... FOR UPDATE 锁定响应体，直到事务结束，返回已锁定的 hash。
+// hashes 需要已经排序、去重。SQLite 的写事务本身是串行的，不需要也不支持行锁
+func lockBodies(tx *gorm.DB, hashes []string) ([]string, error) {
+	if tx.Dialector.Name() == "sqlite" {
+		return hashes, nil
+	}
+
+	locked := make([]string, 0, len(hashes))
+	for len(hashes) > 0 {
+		n := len(hashes)
+		if n > sqlBatchSize {
+			n = sqlBatchSize
+		}
+
+		var chunk []string
+		err := tx.Model(&BodyModel{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(map[string]interface{}{"hash": hashes[:n]}).
+			Order("hash").
+			Pluck("hash", &chunk).Error
+		if err != nil {
+			return nil, err
+		}
+		locked = append(locked, chunk...)
+		hashes = hashes[n:]
+	}
+	return locked, nil
+}
+
+// recountBodies 重新计算响应体的引用数，并删除其中没有被引用的响应体，需要在事务中调用。
+// hashes 需要已经排序、去重。
+//
+// 引用数每次都按缓存表重新统计而不是加减，所以中断的写入不会让引用数出错。
+// 统计前先锁定响应体：写入缓存的事务在写入前也会锁定引用的响应体，所以统计会
+// 等到这些事务提交后才进行，不会删除还未提交的缓存引用的响应体。
+func recountBodies(db *gorm.DB, hashes []string) error {
+	for len(hashes) > 0 {
+		n := len(hashes)
+		if n > sqlBatchSize {
+			n = sqlBatchSize
+		}
+		chunk := hashes[:n]
+		hashes = hashes[n:]
+
+		_, err := lockBodies(db, chunk)
+		if err != nil {
+			return err
+		}
+
+		// 锁定后的语句在 READ COMMITTED 下能看到已提交的引用
+		err = db.Model(&BodyModel{}).
+			Where(map[string]interface{}{"hash": chunk}).
+			Update("refs", countRefs).Error
+		if err != nil {
+			return err
+		}
+
+		err = db.Where(map[string]interface{}{"hash": chunk}).
+			Where("refs <= 0").
+			Delete(&BodyModel{}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countRefs 统计引用响应体的缓存条数
+var countRefs = gorm.Expr("(SELECT COUNT(*) FROM cache WHERE cache.hash = cache_bodies.hash)")
+
+// loadBodies 读取响应体并解压
+func loadBodies(db *gorm.DB, hashes []string) (map[string][]byte, error) {
+	bodies := make(map[string][]byte, len(hashes))
+	for len(hashes) > 0 {
+		n := len(hashes)
+		if n > sqlBatchSize {
+			n = sqlBatchSize
+		}
+
+		var models []BodyModel
+		err := db.Where(map[string]interface{}{"hash": hashes[:n]}).Find(&models).Error
+		if err != nil {
+			return nil, err
+		}
+		hashes = hashes[n:]
+
+		for i := range models {
+			val, err := decode(models[i].Value)
+			if err != nil {
+				return nil, err
+			}
+			bodies[models[i].Hash] = val
+		}
+	}
+	return bodies, nil
+}
+
+// join 将缓存的其余部分和响应体拼接为原始的缓存值
+func join(head, body []byte) []byte {
+	val := make([]byte, 0, len(head)+len(body))
+	val = append(val, head...)
+	return append(val, body...)
+}
+
+// CollectGarbage 重新计算所有响应体的引用数，并删除没有被引用的响应体。
+// PurgeExpired 删除过期的缓存后会调用此方法
+func (sc *sqlCache) CollectGarbage() error {
+	return sc.db.Transaction(func(tx *gorm.DB) error {
+		var hashes []string
+		err := tx.Model(&BodyModel{}).Order("hash").Pluck("hash", &hashes).Error
+		if err != nil {
+			return err
+		}
+		return recountBodies(tx, hashes)
+	})
+}
+
+// DedupStats 返回响应体去重的统计
+func (sc *sqlCache) DedupStats() (DedupStats, error) {
+	var stats DedupStats
+
+	err := sc.db.Model(&CacheModel{}).Where("hash <> ''").Count(&stats.Entries).Error
+	if err != nil {
+		return stats, err
+	}
+
+	err = sc.db.Model(&BodyModel{}).
+		Select("COUNT(*) AS bodies, COALESCE(SUM(size * refs), 0) AS logical_bytes, COALESCE(SUM(size), 0) AS stored_bytes").
+		Row().
+		Scan(&stats.Bodies, &stats.LogicalBytes, &stats.StoredBytes)
+	return stats, err
+}
+
+// errBodyNotFound 缓存引用的响应体已被删除，视为未缓存
+var errBodyNotFound = errors.New("cached body not found")
+
+// getBody 读取一条缓存引用的响应体
+func (sc *sqlCache) getBody(ctx context.Context, hash string) ([]byte, error) {
+	var bm BodyModel
+	err := sc.db.WithContext(ctx).Where(&BodyModel{Hash: hash}).First(&bm).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errBodyNotFound
+		}
+		return nil, err
+	}
+	return decode(bm.Value)
+}
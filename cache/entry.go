@@ -0,0 +1,174 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: entry.go
+ * @Created: 2026-10-18 22:45:19
+ * @Modified: 2026-10-18 22:45:19
+ */
+
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/thep0y/predator/json"
+)
+
+var ErrNotIterable = errors.New("the cache does not support iteration")
+
+// errStopRange 用于在回调函数返回 false 时中止遍历
+var errStopRange = errors.New("stop range")
+
+// Entry 是缓存中的一条记录，Value 是解压后的值
+type Entry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	// 缓存的时间，不支持的缓存为零值
+	CreatedAt time.Time `json:"created_at"`
+	// 过期时间，为 nil 时永不过期
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired 缓存是否已经过期
+func (e *Entry) Expired() bool {
+	return e.ExpiresAt != nil && !time.Now().Before(*e.ExpiresAt)
+}
+
+// TTL 返回剩余的过期时间，永不过期时为 0
+func (e *Entry) TTL() time.Duration {
+	if e.ExpiresAt == nil {
+		return 0
+	}
+	return time.Until(*e.ExpiresAt)
+}
+
+// IterableCache 可以遍历和删除的缓存，用于查看、导出和迁移缓存
+type IterableCache interface {
+	Cache
+	// 遍历所有缓存，包括已过期但还未被删除的缓存，fn 返回 false 时停止遍历
+	Range(fn func(e *Entry) bool) error
+	// 删除一条缓存，缓存不存在时不返回错误
+	Delete(key string) error
+}
+
+// Range 遍历缓存，缓存需要实现 IterableCache
+func Range(c Cache, fn func(e *Entry) bool) error {
+	ic, ok := c.(IterableCache)
+	if !ok {
+		return ErrNotIterable
+	}
+	return ic.Range(fn)
+}
+
+// Delete 删除缓存，缓存需要实现 IterableCache
+func Delete(c Cache, keys ...string) error {
+	ic, ok := c.(IterableCache)
+	if !ok {
+		return ErrNotIterable
+	}
+
+	for _, key := range keys {
+		if err := ic.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put 写入一条缓存，保留剩余的过期时间，已存在时覆盖
+func put(c Cache, e *Entry) error {
+	ttl := e.TTL()
+	if uc, ok := c.(UpdatableCache); ok {
+		return uc.Update(e.Key, e.Value, ttl)
+	}
+	return cacheWithTTL(c, e.Key, e.Value, ttl)
+}
+
+// Export 将未过期的缓存以 json lines 的格式写入 w，返回导出的条数
+func Export(c Cache, w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+
+	var count int
+	var err error
+	rangeErr := Range(c, func(e *Entry) bool {
+		if e.Expired() {
+			return true
+		}
+
+		var line []byte
+		line, err = json.Marshal(e)
+		if err != nil {
+			return false
+		}
+		if _, err = bw.Write(line); err != nil {
+			return false
+		}
+		if err = bw.WriteByte('\n'); err != nil {
+			return false
+		}
+		count++
+		return true
+	})
+	if rangeErr != nil {
+		return count, rangeErr
+	}
+	if err != nil {
+		return count, err
+	}
+
+	return count, bw.Flush()
+}
+
+// Import 读取 Export 导出的缓存并写入 c，已过期的缓存会被跳过，返回导入的条数
+func Import(c Cache, r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+
+	var count int
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var e Entry
+			if e2 := json.Unmarshal(line, &e); e2 != nil {
+				return count, e2
+			}
+
+			if !e.Expired() {
+				if e2 := put(c, &e); e2 != nil {
+					return count, e2
+				}
+				count++
+			}
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				return count, nil
+			}
+			return count, err
+		}
+	}
+}
+
+// Migrate 将 src 中未过期的缓存复制到 dst 中，保留剩余的过期时间，返回复制的条数
+func Migrate(src, dst Cache) (int, error) {
+	var count int
+	var err error
+	rangeErr := Range(src, func(e *Entry) bool {
+		if e.Expired() {
+			return true
+		}
+		if err = put(dst, e); err != nil {
+			return false
+		}
+		count++
+		return true
+	})
+	if rangeErr != nil {
+		return count, rangeErr
+	}
+	return count, err
+}
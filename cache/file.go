@@ -0,0 +1,433 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: file.go
+ * @Created: 2026-10-18 22:33:27
+ * @Modified: 2026-10-19 00:17:06
+ */
+
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/thep0y/predator/json"
+	"github.com/thep0y/predator/tools"
+)
+
+const (
+	fileCacheDataExt = ".cache"
+	fileCacheMetaExt = ".meta.json"
+	// 临时文件的前缀，写入完成后重命名为正式文件
+	fileCacheTempPrefix = ".tmp-"
+)
+
+var errFileMismatch = errors.New("the cache file does not match its metadata")
+
+// FileCache 将每条缓存保存为一个文件。
+//
+// 文件按键的 sha1 分为两层目录，如 Dir/ab/cd/abcd....cache，每个缓存文件都有一个同名的
+// .meta.json 元数据文件，记录键、压缩算法、大小和过期时间，可以直接用普通的工具查看和同步。
+// 文件先写入临时文件再重命名，不会读取到写了一半的缓存。元数据中记录了缓存文件的校验和，
+// 更新缓存时读取到新旧不一致的两个文件会重新读取。
+type FileCache struct {
+	// 缓存的根目录，默认为 predator-cache
+	Dir string
+	Compression
+}
+
+type fileCacheMeta struct {
+	Key string `json:"key"`
+	// 压缩算法
+	Codec string `json:"codec"`
+	// 原始数据的长度
+	Size      int        `json:"size"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// 缓存文件的 CRC-32 校验和，用来发现与元数据不匹配的缓存文件
+	Checksum uint32 `json:"crc32"`
+}
+
+func (m *fileCacheMeta) expired() bool {
+	return m.ExpiresAt != nil && !time.Now().Before(*m.ExpiresAt)
+}
+
+// entry 转换为 Entry，val 是解码后的值
+func (m *fileCacheMeta) entry(val []byte) *Entry {
+	return &Entry{
+		Key:       m.Key,
+		Value:     val,
+		CreatedAt: m.CreatedAt,
+		ExpiresAt: m.ExpiresAt,
+	}
+}
+
+func (fc *FileCache) Init() error {
+	if fc.Dir == "" {
+		fc.Dir = "predator-cache"
+	}
+	return os.MkdirAll(fc.Dir, 0755)
+}
+
+// path 返回缓存文件不含扩展名的路径
+func (fc *FileCache) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(fc.Dir, name[:2], name[2:4], name)
+}
+
+func (fc *FileCache) readMeta(path string) (*fileCacheMeta, error) {
+	data, err := os.ReadFile(path + fileCacheMetaExt)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta fileCacheMeta
+	err = json.Unmarshal(data, &meta)
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// read 读取元数据和未解码的缓存文件。
+//
+// 两个文件分别重命名，更新时可能读取到旧的元数据和新的缓存文件，校验和不一致时重新读取一次，
+// 仍不一致时缓存文件已损坏，返回错误
+func (fc *FileCache) read(path string) (*fileCacheMeta, []byte, error) {
+	for i := 0; i < 2; i++ {
+		meta, err := fc.readMeta(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		val, err := os.ReadFile(path + fileCacheDataExt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if crc32.ChecksumIEEE(val) == meta.Checksum {
+			return meta, val, nil
+		}
+	}
+	return nil, nil, errFileMismatch
+}
+
+// cached 判断未过期的缓存是否存在
+func (fc *FileCache) cached(path, key string) (bool, error) {
+	meta, err := fc.readMeta(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return meta.Key == key && !meta.expired(), nil
+}
+
+// IsCached 出错时视为未缓存，需要处理错误时使用 Get
+func (fc *FileCache) IsCached(key string) ([]byte, bool) {
+	val, ok, _ := fc.get(key, false)
+	return val, ok
+}
+
+func (fc *FileCache) IsCachedStale(key string) ([]byte, bool) {
+	val, ok, _ := fc.get(key, true)
+	return val, ok
+}
+
+func (fc *FileCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	return fc.get(key, false)
+}
+
+func (fc *FileCache) GetStale(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	return fc.get(key, true)
+}
+
+// GetEntry 与 Get 相同，但同时返回缓存的时间和过期时间
+func (fc *FileCache) GetEntry(ctx context.Context, key string) (*Entry, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	return fc.getEntry(key, false)
+}
+
+func (fc *FileCache) get(key string, stale bool) ([]byte, bool, error) {
+	e, ok, err := fc.getEntry(key, stale)
+	if !ok {
+		return nil, false, err
+	}
+	return e.Value, true, nil
+}
+
+func (fc *FileCache) getEntry(key string, stale bool) (*Entry, bool, error) {
+	// 元数据或缓存文件不存在，或者缓存文件被删除
+	meta, val, err := fc.read(fc.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	if meta.Key != key || (!stale && meta.expired()) {
+		return nil, false, nil
+	}
+
+	dec, err := decode(val)
+	if err != nil {
+		return nil, false, err
+	}
+	return meta.entry(dec), true, nil
+}
+
+func (fc *FileCache) Cache(key string, val []byte) error {
+	return fc.CacheWithTTL(key, val, 0)
+}
+
+func (fc *FileCache) CacheWithTTL(key string, val []byte, ttl time.Duration) error {
+	ok, err := fc.cached(fc.path(key), key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	return fc.Update(key, val, ttl)
+}
+
+func (fc *FileCache) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fc.Update(key, val, ttl)
+}
+
+func (fc *FileCache) Update(key string, val []byte, ttl time.Duration) error {
+	path := fc.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	meta := &fileCacheMeta{
+		Key:       key,
+		Size:      len(val),
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := meta.CreatedAt.Add(ttl)
+		meta.ExpiresAt = &expiresAt
+	}
+
+	val, err = fc.encode(val)
+	if err != nil {
+		return err
+	}
+	meta.Codec = tools.CodecOf(val).String()
+	meta.Checksum = crc32.ChecksumIEEE(val)
+
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	// 两个文件都写入临时文件后再重命名，最后重命名元数据，元数据存在时缓存文件一定是完整的
+	dataTmp, err := writeTemp(filepath.Dir(path), val)
+	if err != nil {
+		return err
+	}
+	metaTmp, err := writeTemp(filepath.Dir(path), metaData)
+	if err != nil {
+		os.Remove(dataTmp)
+		return err
+	}
+
+	err = os.Rename(dataTmp, path+fileCacheDataExt)
+	if err != nil {
+		os.Remove(dataTmp)
+		os.Remove(metaTmp)
+		return err
+	}
+	err = os.Rename(metaTmp, path+fileCacheMetaExt)
+	if err != nil {
+		os.Remove(metaTmp)
+	}
+	return err
+}
+
+// writeTemp 将 data 写入 dir 中的临时文件，返回临时文件的路径
+func writeTemp(dir string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, fileCacheTempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+// PurgeExpired 删除已过期的缓存，以及中断写入时残留的临时文件
+func (fc *FileCache) PurgeExpired() error {
+	return fc.walkShards(func(path string, d fs.DirEntry) error {
+		name := d.Name()
+
+		if strings.HasPrefix(name, fileCacheTempPrefix) {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			// 可能正在写入，只删除较早的临时文件
+			if time.Since(info.ModTime()) > time.Hour {
+				return ignoreNotExist(os.Remove(path))
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(name, fileCacheMetaExt) {
+			return nil
+		}
+
+		base := strings.TrimSuffix(path, fileCacheMetaExt)
+		meta, err := fc.readMeta(base)
+		if err != nil {
+			return ignoreNotExist(err)
+		}
+		if !meta.expired() {
+			return nil
+		}
+
+		err = ignoreNotExist(os.Remove(path))
+		if err != nil {
+			return err
+		}
+		return ignoreNotExist(os.Remove(base + fileCacheDataExt))
+	})
+}
+
+func (fc *FileCache) Range(fn func(e *Entry) bool) error {
+	err := fc.walkShards(func(path string, d fs.DirEntry) error {
+		if !strings.HasSuffix(d.Name(), fileCacheMetaExt) {
+			return nil
+		}
+
+		base := strings.TrimSuffix(path, fileCacheMetaExt)
+		meta, val, err := fc.read(base)
+		if err != nil {
+			return ignoreNotExist(err)
+		}
+		val, err = decode(val)
+		if err != nil {
+			return err
+		}
+
+		if !fn(meta.entry(val)) {
+			return errStopRange
+		}
+		return nil
+	})
+	if errors.Is(err, errStopRange) {
+		return nil
+	}
+	return err
+}
+
+func (fc *FileCache) Delete(key string) error {
+	path := fc.path(key)
+	// 先删除元数据，删除后缓存立即不可见
+	err := ignoreNotExist(os.Remove(path + fileCacheMetaExt))
+	if err != nil {
+		return err
+	}
+	return ignoreNotExist(os.Remove(path + fileCacheDataExt))
+}
+
+// Clear 只删除缓存所在的分片目录，不会删除根目录中的其他文件
+func (fc *FileCache) Clear() error {
+	entries, err := os.ReadDir(fc.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() && isShardName(e.Name()) {
+			err = os.RemoveAll(filepath.Join(fc.Dir, e.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkShards 遍历分片目录中的所有文件
+func (fc *FileCache) walkShards(fn func(path string, d fs.DirEntry) error) error {
+	entries, err := os.ReadDir(fc.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() || !isShardName(e.Name()) {
+			continue
+		}
+
+		err = filepath.WalkDir(filepath.Join(fc.Dir, e.Name()), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return ignoreNotExist(err)
+			}
+			if d.IsDir() {
+				return nil
+			}
+			return fn(path, d)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isShardName(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+func ignoreNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,301 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: memory.go
+ * @Created: 2026-10-18 22:34:10
+ * @Modified: 2026-10-18 23:54:12
+ */
+
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/thep0y/predator/tools"
+)
+
+// MemoryCache 是保存在内存中的 LRU 缓存，适用于短时间运行的任务和测试。
+//
+// 总字节数或条数超过限制时，淘汰最久未使用的缓存。进程退出后缓存即丢失。
+type MemoryCache struct {
+	// 缓存值的总字节数上限（压缩后的大小），小于等于 0 时不限制
+	MaxBytes int64
+	// 缓存条数上限，小于等于 0 时不限制
+	MaxEntries int
+	Compression
+
+	lock  sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int64
+	stats Stats
+}
+
+type memoryEntry struct {
+	key       string
+	val       []byte
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// entry 转换为 Entry，val 是解压后的值
+func (e *memoryEntry) entry(val []byte) *Entry {
+	entry := &Entry{
+		Key:       e.key,
+		Value:     val,
+		CreatedAt: e.createdAt,
+	}
+	if !e.expiresAt.IsZero() {
+		expiresAt := e.expiresAt
+		entry.ExpiresAt = &expiresAt
+	}
+	return entry
+}
+
+// Init 可以重复调用，多个 crawler 可以共用同一个 MemoryCache
+func (mc *MemoryCache) Init() error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if mc.ll == nil {
+		mc.reset()
+	}
+	return nil
+}
+
+func (mc *MemoryCache) reset() {
+	mc.ll = list.New()
+	mc.items = make(map[string]*list.Element)
+	mc.bytes = 0
+}
+
+// IsCached 出错时视为未缓存，需要处理错误时使用 Get
+func (mc *MemoryCache) IsCached(key string) ([]byte, bool) {
+	val, ok, _ := mc.get(key, false)
+	return val, ok
+}
+
+func (mc *MemoryCache) IsCachedStale(key string) ([]byte, bool) {
+	val, ok, _ := mc.get(key, true)
+	return val, ok
+}
+
+func (mc *MemoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	return mc.get(key, false)
+}
+
+func (mc *MemoryCache) GetStale(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	return mc.get(key, true)
+}
+
+// GetEntry 与 Get 相同，但同时返回缓存的过期时间
+func (mc *MemoryCache) GetEntry(ctx context.Context, key string) (*Entry, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	return mc.getEntry(key, false)
+}
+
+func (mc *MemoryCache) get(key string, stale bool) ([]byte, bool, error) {
+	e, ok, err := mc.getEntry(key, stale)
+	if !ok {
+		return nil, false, err
+	}
+	return e.Value, true, nil
+}
+
+func (mc *MemoryCache) getEntry(key string, stale bool) (*Entry, bool, error) {
+	mc.lock.Lock()
+	elem, ok := mc.items[key]
+	if !ok {
+		mc.stats.Misses++
+		mc.lock.Unlock()
+		return nil, false, nil
+	}
+
+	// 过期的缓存在 PurgeExpired 或被淘汰时才删除，在此之前仍可以用 IsCachedStale 读取
+	entry := elem.Value.(*memoryEntry)
+	if !stale && entry.expired(time.Now()) {
+		mc.stats.Misses++
+		mc.lock.Unlock()
+		return nil, false, nil
+	}
+
+	mc.ll.MoveToFront(elem)
+	mc.stats.Hits++
+	me := *entry
+	mc.lock.Unlock()
+
+	dec, err := decode(me.val)
+	if err != nil {
+		return nil, false, err
+	}
+	return me.entry(copyIfShared(me.val, dec)), true, nil
+}
+
+func (mc *MemoryCache) Cache(key string, val []byte) error {
+	return mc.CacheWithTTL(key, val, 0)
+}
+
+func (mc *MemoryCache) CacheWithTTL(key string, val []byte, ttl time.Duration) error {
+	return mc.set(key, val, ttl, false)
+}
+
+func (mc *MemoryCache) Update(key string, val []byte, ttl time.Duration) error {
+	return mc.set(key, val, ttl, true)
+}
+
+func (mc *MemoryCache) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return mc.set(key, val, ttl, true)
+}
+
+func (mc *MemoryCache) set(key string, val []byte, ttl time.Duration, overwrite bool) error {
+	now := time.Now()
+
+	// 编码结果总是新的切片，不会与调用方共用
+	val, err := mc.encode(val)
+	if err != nil {
+		return err
+	}
+
+	entry := &memoryEntry{key: key, val: val, createdAt: now}
+	if ttl > 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if elem, ok := mc.items[key]; ok {
+		if !overwrite && !elem.Value.(*memoryEntry).expired(now) {
+			return nil
+		}
+		mc.removeElement(elem)
+	}
+
+	// 单条缓存超过总字节数上限时不缓存
+	if mc.MaxBytes > 0 && int64(len(val)) > mc.MaxBytes {
+		return nil
+	}
+
+	mc.items[key] = mc.ll.PushFront(entry)
+	mc.bytes += int64(len(val))
+
+	mc.evict()
+
+	return nil
+}
+
+// evict 淘汰最久未使用的缓存，直到不超过限制
+func (mc *MemoryCache) evict() {
+	for mc.overflow() {
+		elem := mc.ll.Back()
+		if elem == nil {
+			return
+		}
+		mc.removeElement(elem)
+		mc.stats.Evictions++
+	}
+}
+
+func (mc *MemoryCache) overflow() bool {
+	return (mc.MaxBytes > 0 && mc.bytes > mc.MaxBytes) ||
+		(mc.MaxEntries > 0 && mc.ll.Len() > mc.MaxEntries)
+}
+
+func (mc *MemoryCache) removeElement(elem *list.Element) {
+	entry := mc.ll.Remove(elem).(*memoryEntry)
+	delete(mc.items, entry.key)
+	mc.bytes -= int64(len(entry.val))
+}
+
+func (mc *MemoryCache) PurgeExpired() error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	now := time.Now()
+	for elem := mc.ll.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*memoryEntry).expired(now) {
+			mc.removeElement(elem)
+		}
+		elem = next
+	}
+	return nil
+}
+
+// Range 遍历开始时的快照，遍历过程中可以修改缓存
+func (mc *MemoryCache) Range(fn func(e *Entry) bool) error {
+	mc.lock.Lock()
+	entries := make([]*memoryEntry, 0, mc.ll.Len())
+	for elem := mc.ll.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(*memoryEntry))
+	}
+	mc.lock.Unlock()
+
+	for _, me := range entries {
+		val, err := decode(me.val)
+		if err != nil {
+			return err
+		}
+		if !fn(me.entry(copyIfShared(me.val, val))) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (mc *MemoryCache) Delete(key string) error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if elem, ok := mc.items[key]; ok {
+		mc.removeElement(elem)
+	}
+	return nil
+}
+
+func (mc *MemoryCache) Clear() error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	mc.reset()
+	return nil
+}
+
+// copyIfShared 未压缩的值解码后与缓存中的数据共用底层数组，返回副本，避免调用方修改缓存中的数据
+func copyIfShared(stored, dec []byte) []byte {
+	if tools.CodecOf(stored) != tools.CodecNone {
+		return dec
+	}
+	cp := make([]byte, len(dec))
+	copy(cp, dec)
+	return cp
+}
+
+// Stats 返回当前的统计信息
+func (mc *MemoryCache) Stats() Stats {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	stats := mc.stats
+	stats.Entries = mc.ll.Len()
+	stats.Bytes = mc.bytes
+	return stats
+}
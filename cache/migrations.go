@@ -0,0 +1,208 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: migrations.go
+ * @Created: 2026-10-18 23:31:00
+ * @Modified: 2026-10-19 00:01:43
+ */
+
+package cache
+
+import (
+	"strings"
+	"time"
+
+	"github.com/thep0y/predator/dao"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MigrationTable 记录 SQL 缓存已执行的迁移的表
+const MigrationTable = "cache_migrations"
+
+// 每个版本的表结构快照。迁移发布后 CacheModel 和 BodyModel 仍可能修改，
+// 迁移中只能使用这些快照，修改表结构时需要增加新的快照和迁移
+
+type cacheV1 struct {
+	Key   string `gorm:"primaryKey"`
+	Value []byte
+}
+
+func (cacheV1) TableName() string {
+	return "cache"
+}
+
+type cacheV2 struct {
+	Key       string `gorm:"primaryKey"`
+	Value     []byte
+	CreatedAt time.Time
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+func (cacheV2) TableName() string {
+	return "cache"
+}
+
+type cacheV3 struct {
+	Key       string `gorm:"primaryKey"`
+	Value     []byte
+	CreatedAt time.Time
+	ExpiresAt *time.Time `gorm:"index"`
+	Hash      string     `gorm:"size:64;index"`
+}
+
+func (cacheV3) TableName() string {
+	return "cache"
+}
+
+type bodyV3 struct {
+	Hash      string `gorm:"primaryKey;size:64"`
+	Value     []byte
+	Size      int64
+	Refs      int64
+	CreatedAt time.Time
+}
+
+func (bodyV3) TableName() string {
+	return "cache_bodies"
+}
+
+// migrations 是 SQL 缓存的表结构迁移。
+//
+// 没有迁移表的旧版本数据库中已经有部分表和列，AutoMigrate 只会补充缺少的列，
+// 所以这些数据库也会从第一个版本开始迁移
+var migrations = []dao.Migration{
+	{
+		Version: 1,
+		Name:    "create cache",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&cacheV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&cacheV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add cache expiration",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&cacheV2{})
+		},
+		Down: func(tx *gorm.DB) error {
+			err := dropIndexes(tx, &cacheV2{}, "ExpiresAt")
+			if err != nil {
+				return err
+			}
+			return shrink(tx, &cacheV1{}, "created_at", "expires_at")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "deduplicate cache bodies",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&cacheV3{}, &bodyV3{})
+		},
+		Down: func(tx *gorm.DB) error {
+			// 响应体被删除后去重的缓存无法再读取
+			err := tx.Where("hash <> ''").Delete(&cacheV3{}).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Migrator().DropTable(&bodyV3{})
+			if err != nil {
+				return err
+			}
+			err = dropIndexes(tx, &cacheV3{}, "Hash")
+			if err != nil {
+				return err
+			}
+			return shrink(tx, &cacheV2{}, "hash")
+		},
+	},
+}
+
+// SchemaMigrator 返回 SQL 缓存的表结构的 Migrator，可以用来查看版本或回滚到旧版本的表结构
+func SchemaMigrator(db dao.Database) (*dao.Migrator, error) {
+	return dao.NewMigrator(db, MigrationTable, migrations...)
+}
+
+// migrateSchema 将 SQL 缓存的表迁移到当前版本
+func migrateSchema(db dao.Database) error {
+	m, err := SchemaMigrator(db)
+	if err != nil {
+		return err
+	}
+	return m.Up()
+}
+
+// dropIndexes 删除 model 中 fields 字段上的索引，用于回滚 AutoMigrate 创建的索引
+func dropIndexes(tx *gorm.DB, model interface{}, fields ...string) error {
+	m := tx.Migrator()
+	for _, field := range fields {
+		if !m.HasIndex(model, field) {
+			continue
+		}
+		err := m.DropIndex(model, field)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shrink 删除表中的列，将表恢复为 prev 的结构。
+//
+// 当前使用的 SQLite 版本不支持 DROP COLUMN，所以按 prev 重建表并复制数据
+func shrink(tx *gorm.DB, prev interface{}, columns ...string) error {
+	if tx.Dialector.Name() != "sqlite" {
+		for _, col := range columns {
+			err := tx.Migrator().DropColumn(prev, col)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: tx}
+	err := stmt.Parse(prev)
+	if err != nil {
+		return err
+	}
+	table := stmt.Schema.Table
+	old := table + "__old"
+
+	// 索引名在 SQLite 中是全局的，重建前先删除旧表的索引
+	var indexes []string
+	err = tx.Raw("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).
+		Scan(&indexes).Error
+	if err != nil {
+		return err
+	}
+	for _, name := range indexes {
+		err = tx.Exec("DROP INDEX ?", clause.Column{Name: name}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Migrator().RenameTable(table, old)
+	if err != nil {
+		return err
+	}
+	err = tx.AutoMigrate(prev)
+	if err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(stmt.Schema.DBNames))
+	for i, name := range stmt.Schema.DBNames {
+		quoted[i] = tx.Statement.Quote(name)
+	}
+	cols := strings.Join(quoted, ", ")
+	err = tx.Exec("INSERT INTO " + tx.Statement.Quote(table) + " (" + cols + ") SELECT " + cols + " FROM " + tx.Statement.Quote(old)).Error
+	if err != nil {
+		return err
+	}
+	return tx.Migrator().DropTable(old)
+}
@@ -3,23 +3,24 @@
  * @Email: thepoy@163.com
  * @File Name: postgresql.go
  * @Created: 2021-07-24 22:23:09
- * @Modified: 2021-07-30 22:44:35
+ * @Modified: 2026-10-18 23:32:49
  */
 
 package cache
 
 import (
-	"errors"
-
 	"github.com/thep0y/predator/dao"
-	"github.com/thep0y/predator/tools"
-	"gorm.io/gorm"
 )
 
 type PostgreSQLCache struct {
 	Host, Port, Database, Username, Password, SSLMode, TimeZone string
 	db                                                          *dao.PostgreSQL
-	compressed                                                  bool
+	Compression
+	// 按内容去重保存响应体，相同的响应体只保存一次，见 JoinBody
+	Dedup bool
+	// 连接池和日志设置，没有设置 Logger 时使用爬虫的 logger
+	Config dao.Config
+	sqlCache
 }
 
 func (pc *PostgreSQLCache) Init() error {
@@ -31,67 +32,20 @@ func (pc *PostgreSQLCache) Init() error {
 		Password: pc.Password,
 		SSLMode:  pc.SSLMode,
 		TimeZone: pc.TimeZone,
+		Config:   pc.Config,
 	}
 	err := pc.db.Init()
 	if err != nil {
 		return err
 	}
 
-	err = pc.db.AutoMigrate(&CacheModel{})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (pc *PostgreSQLCache) IsCached(key string) ([]byte, bool) {
-	var cache CacheModel
-	err := pc.db.SelectOneWithWhere(&cache, `"key" = ?`, key)
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, false
-		}
-		panic(err)
-	}
-
-	if cache.Value != nil {
-		if pc.compressed {
-			dec, err := tools.Decompress(cache.Value)
-			if err != nil {
-				panic(err)
-			}
-			return dec, true
-		}
-		return cache.Value, true
-	}
-	return nil, false
-}
-
-func (pc *PostgreSQLCache) Cache(key string, val []byte) error {
-	// 这里不能用 CheckCache，因为 value 值很长，获取 Value 和解压过程耗时较长
-	var count int
-	err := pc.db.DB.Model(&CacheModel{}).Select("COUNT(*)").Where(`"key" = ?`, key).Scan(&count).Error
+	err = migrateSchema(pc.db)
 	if err != nil {
 		return err
 	}
-
-	if count == 0 {
-		if pc.compressed {
-			val = tools.Compress(val)
-		}
-		return pc.db.Insert(&CacheModel{
-			Key:   key,
-			Value: val,
-		})
-	}
-
+	pc.sqlCache.db = pc.db.DB
+	pc.sqlCache.compression = &pc.Compression
+	pc.sqlCache.dedup = pc.Dedup
+	pc.sqlCache.hasLogger = pc.Config.Logger != nil
 	return nil
 }
-
-func (pc *PostgreSQLCache) Clear() error {
-	return pc.db.Truncate(&CacheModel{})
-}
-
-func (pc *PostgreSQLCache) Compressed(yes bool) {
-	pc.compressed = yes
-}
@@ -3,7 +3,7 @@
  * @Email: thepoy@163.com
  * @File Name: redis.go
  * @Created: 2021-07-24 22:21:17
- * @Modified: 2021-07-31 08:56:29
+ * @Modified: 2026-10-18 23:54:12
  */
 
 package cache
@@ -12,124 +12,376 @@ import (
 	"context"
 	"encoding/base64"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/thep0y/predator/tools"
 )
 
 const (
+	// 默认的键前缀
 	namespace = "predator-cache"
+	// 遍历时每次 SCAN 的键数
+	redisScanCount = 100
 )
 
+// RedisCache 使用 redis 保存缓存，过期时间由 redis 管理。
+//
+// 默认连接 Addr 指定的单个 redis，也可以用 Options 连接集群或哨兵：
+//
+//	&cache.RedisCache{
+//		Options: &redis.UniversalOptions{
+//			Addrs:      []string{"127.0.0.1:26379"},
+//			MasterName: "mymaster",
+//		},
+//	}
 type RedisCache struct {
 	Addr, Password string
 	DB             int
-	client         *redis.Client
-	ctx            context.Context
-	compressed     bool
+	// 键的前缀，键为 Prefix:key，默认为 predator-cache
+	Prefix string
+	// 设置 MasterName 时使用哨兵模式，Addrs 有多个地址时使用集群模式，
+	// 设置后 Addr、Password 和 DB 不再起作用
+	Options *redis.UniversalOptions
+	// 使用已有的客户端，如 redis.NewClusterClient 创建的客户端，设置后忽略所有连接选项
+	Client redis.UniversalClient
+	Compression
+	client redis.UniversalClient
+	ctx    context.Context
 }
 
-func cacheKey(key string) string {
+func (rc *RedisCache) key(key string) string {
 	var s strings.Builder
-	s.WriteString(namespace)
+	s.WriteString(rc.Prefix)
 	s.WriteString(":")
 	s.WriteString(key)
 	return s.String()
 }
 
-func (rc *RedisCache) Compressed(yes bool) {
-	rc.compressed = yes
-}
-
 func (rc *RedisCache) Init() error {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     rc.Addr,
-		Password: rc.Password,
-		DB:       rc.DB,
-	})
+	if rc.Prefix == "" {
+		rc.Prefix = namespace
+	}
+
+	switch {
+	case rc.Client != nil:
+		rc.client = rc.Client
+	case rc.Options != nil:
+		rc.client = redis.NewUniversalClient(rc.Options)
+	default:
+		rc.client = redis.NewClient(&redis.Options{
+			Addr:     rc.Addr,
+			Password: rc.Password,
+			DB:       rc.DB,
+		})
+	}
 
-	rc.client = rdb
 	rc.ctx = context.Background()
 	return nil
 }
 
+// IsCached 出错时视为未缓存，需要处理错误时使用 Get
 func (rc *RedisCache) IsCached(key string) ([]byte, bool) {
-	val, err := rc.client.Get(rc.ctx, cacheKey(key)).Result()
+	val, ok, _ := rc.Get(rc.ctx, key)
+	return val, ok
+}
+
+func (rc *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	val, err := rc.client.Get(ctx, rc.key(key)).Bytes()
 	if err != nil {
 		if err == redis.Nil {
-			return nil, false
+			return nil, false, nil
 		}
-		panic(err)
+		return nil, false, err
 	}
-	if val != "" {
-		// 将 base64 字符串转换为 []byte
-		valBytes, err := base64ToBytes(val)
-		if err != nil {
-			panic(err)
+	return redisValue(val)
+}
+
+// GetEntry 与 Get 相同，但同时返回缓存的过期时间，redis 不记录缓存的时间，Entry.CreatedAt 为零值
+func (rc *RedisCache) GetEntry(ctx context.Context, key string) (*Entry, bool, error) {
+	var getCmd *redis.StringCmd
+	var ttlCmd *redis.DurationCmd
+	_, err := rc.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		getCmd = pipe.Get(ctx, rc.key(key))
+		ttlCmd = pipe.PTTL(ctx, rc.key(key))
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, false, err
+	}
+
+	val, err := getCmd.Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, false, nil
 		}
-		if rc.compressed {
-			dec, err := tools.Decompress(valBytes)
-			if err != nil {
-				panic(err)
+		return nil, false, err
+	}
+
+	val, ok, err := redisValue(val)
+	if !ok {
+		return nil, false, err
+	}
+
+	e := &Entry{Key: key, Value: val}
+	if ttl := ttlCmd.Val(); ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		e.ExpiresAt = &expiresAt
+	}
+	return e, true, nil
+}
+
+// GetMany 用一次 pipeline 读取多条缓存，返回值中只有已缓存的键
+func (rc *RedisCache) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := rc.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, rc.key(key))
+		}
+		return nil
+	})
+	// 有键不存在时 err 为 redis.Nil，需要检查每条命令的结果
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	vals := make(map[string][]byte, len(keys))
+	for i, cmd := range cmds {
+		val, err := cmd.Bytes()
+		if err != nil {
+			if err == redis.Nil {
+				continue
 			}
-			return dec, true
+			return nil, err
+		}
+
+		dec, ok, err := redisValue(val)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			vals[keys[i]] = dec
 		}
-		return valBytes, true
 	}
-	return nil, false
+	return vals, nil
 }
 
-func (rc *RedisCache) Cache(key string, val []byte) error {
-	// 不存在则缓存
-	if !rc.exists(key) {
-		if rc.compressed {
-			val = tools.Compress(val)
+// redisValue 解码 redis 中的值。旧版本将值转换为 base64 字符串保存，
+// base64 字符串中的字符都大于压缩算法的标记
+func redisValue(val []byte) ([]byte, bool, error) {
+	if len(val) == 0 {
+		return nil, false, nil
+	}
+
+	if val[0] > byte(tools.CodecSnappy) {
+		legacy, err := base64.StdEncoding.DecodeString(string(val))
+		if err != nil {
+			return nil, false, err
 		}
-		// 将 []byte 转化为 base64 存储
-		valBase64 := bytesToBase64(val)
-		return rc.client.Set(rc.ctx, cacheKey(key), valBase64, 0).Err()
+		val = legacy
 	}
-	return nil
+
+	dec, err := decode(val)
+	if err != nil {
+		return nil, false, err
+	}
+	return dec, true, nil
 }
 
-func (rc *RedisCache) exists(key string) bool {
-	res, err := rc.client.Exists(rc.ctx, key).Result()
+func (rc *RedisCache) Cache(key string, val []byte) error {
+	return rc.CacheWithTTL(key, val, 0)
+}
+
+// CacheWithTTL 用 SET NX EX 只在键不存在时写入，ttl 为 0 时永不过期
+func (rc *RedisCache) CacheWithTTL(key string, val []byte, ttl time.Duration) error {
+	val, err := rc.encode(val)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	return res == 1
+	return rc.client.SetNX(rc.ctx, rc.key(key), val, ttl).Err()
 }
 
-func (rc *RedisCache) scanKeys(cursor uint64) ([]string, uint64, error) {
-	return rc.client.Scan(rc.ctx, cursor, cacheKey("*"), 20).Result()
+func (rc *RedisCache) Update(key string, val []byte, ttl time.Duration) error {
+	return rc.Set(rc.ctx, key, val, ttl)
 }
 
-func (rc *RedisCache) Clear() error {
-	var err error
-	var cursor uint64
-	for {
-		var keys []string
-		keys, cursor, err = rc.scanKeys(cursor)
+func (rc *RedisCache) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	val, err := rc.encode(val)
+	if err != nil {
+		return err
+	}
+	return rc.client.Set(ctx, rc.key(key), val, ttl).Err()
+}
+
+// SetMany 用一次 pipeline 写入多条缓存
+func (rc *RedisCache) SetMany(ctx context.Context, entries []*Entry) error {
+	_, err := rc.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, e := range entries {
+			ttl := e.TTL()
+			if e.ExpiresAt != nil && ttl <= 0 {
+				continue
+			}
+
+			val, err := rc.encode(e.Value)
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, rc.key(e.Key), val, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// PurgeExpired redis 会自动删除过期的键，不需要手动清理
+func (rc *RedisCache) PurgeExpired() error {
+	return nil
+}
+
+// Range 遍历所有缓存，redis 不记录缓存的时间，Entry.CreatedAt 为零值
+func (rc *RedisCache) Range(fn func(e *Entry) bool) error {
+	err := rc.scan(func(keys []string) error {
+		getCmds := make([]*redis.StringCmd, len(keys))
+		ttlCmds := make([]*redis.DurationCmd, len(keys))
+		_, err := rc.client.Pipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+			for i, k := range keys {
+				getCmds[i] = pipe.Get(rc.ctx, k)
+				ttlCmds[i] = pipe.PTTL(rc.ctx, k)
+			}
+			return nil
+		})
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		for i, k := range keys {
+			val, err := getCmds[i].Bytes()
+			if err != nil {
+				// 遍历过程中过期或被删除
+				if err == redis.Nil {
+					continue
+				}
+				return err
+			}
+
+			val, ok, err := redisValue(val)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+
+			e := &Entry{
+				Key:   strings.TrimPrefix(k, rc.Prefix+":"),
+				Value: val,
+			}
+
+			ttl := ttlCmds[i].Val()
+			if ttl > 0 {
+				expiresAt := time.Now().Add(ttl)
+				e.ExpiresAt = &expiresAt
+			}
+
+			if !fn(e) {
+				return errStopRange
+			}
+		}
+		return nil
+	})
+	if err == errStopRange {
+		return nil
+	}
+	return err
+}
+
+func (rc *RedisCache) Delete(key string) error {
+	return rc.client.Del(rc.ctx, rc.key(key)).Err()
+}
+
+// scan 分批遍历带有前缀的键。
+//
+// 集群模式下 SCAN 只能遍历一个节点，所以依次遍历每个主节点
+func (rc *RedisCache) scan(fn func(keys []string) error) error {
+	match := escapePattern(rc.Prefix) + ":*"
+
+	cc, ok := rc.client.(*redis.ClusterClient)
+	if !ok {
+		return scanNode(rc.ctx, rc.client, match, fn)
+	}
+
+	// ForEachMaster 并发执行，先取得所有主节点再依次遍历
+	var lock sync.Mutex
+	var masters []*redis.Client
+	err := cc.ForEachMaster(rc.ctx, func(ctx context.Context, client *redis.Client) error {
+		lock.Lock()
+		masters = append(masters, client)
+		lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, client := range masters {
+		err = scanNode(rc.ctx, client, match, fn)
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		err = rc.client.Del(rc.ctx, keys...).Err()
+func scanNode(ctx context.Context, client redis.Cmdable, match string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, match, redisScanCount).Result()
 		if err != nil {
 			return err
 		}
 
+		if len(keys) > 0 {
+			err = fn(keys)
+			if err != nil {
+				return err
+			}
+		}
+
+		cursor = next
 		if cursor == 0 {
-			break
+			return nil
 		}
 	}
-	return nil
 }
 
-func base64ToBytes(src string) ([]byte, error) {
-	return base64.StdEncoding.DecodeString(src)
+// escapePattern 转义 SCAN MATCH 中的特殊字符
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Close 关闭 redis 连接
+func (rc *RedisCache) Close() error {
+	return rc.client.Close()
 }
 
-func bytesToBase64(src []byte) string {
-	return base64.StdEncoding.EncodeToString(src)
+// Clear 删除所有带有前缀的键。集群模式下多个键可能不在同一个槽中，所以逐个删除
+func (rc *RedisCache) Clear() error {
+	return rc.scan(func(keys []string) error {
+		_, err := rc.client.Pipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+			for _, k := range keys {
+				pipe.Del(rc.ctx, k)
+			}
+			return nil
+		})
+		return err
+	})
 }
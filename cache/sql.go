@@ -0,0 +1,297 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: sql.go
+ * @Created: 2026-10-18 14:20:33
+ * @Modified: 2026-10-19 00:19:33
+ */
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thep0y/predator/dao"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sqlBatchSize 批量写入时每条 INSERT 语句的最大行数
+const sqlBatchSize = 100
+
+// sqlCache 是 SQLite、MySQL 和 PostgreSQL 缓存的公共实现
+type sqlCache struct {
+	db *gorm.DB
+	// 指向外层缓存的压缩设置
+	compression *Compression
+	// 是否按内容去重保存响应体
+	dedup bool
+	// 是否已在 Config 中设置了 Logger
+	hasLogger bool
+}
+
+// notExpired 只查询未过期的缓存
+func notExpired(db *gorm.DB) *gorm.DB {
+	return db.Where("expires_at IS NULL OR expires_at > ?", time.Now())
+}
+
+// IsCached 出错时视为未缓存，需要处理错误时使用 Get
+func (sc *sqlCache) IsCached(key string) ([]byte, bool) {
+	val, ok, _ := sc.Get(context.Background(), key)
+	return val, ok
+}
+
+func (sc *sqlCache) IsCachedStale(key string) ([]byte, bool) {
+	val, ok, _ := sc.GetStale(context.Background(), key)
+	return val, ok
+}
+
+func (sc *sqlCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return sc.get(ctx, key, notExpired)
+}
+
+func (sc *sqlCache) GetStale(ctx context.Context, key string) ([]byte, bool, error) {
+	return sc.get(ctx, key)
+}
+
+// GetEntry 与 Get 相同，但同时返回缓存的时间和过期时间
+func (sc *sqlCache) GetEntry(ctx context.Context, key string) (*Entry, bool, error) {
+	return sc.getEntry(ctx, key, notExpired)
+}
+
+func (sc *sqlCache) get(ctx context.Context, key string, scopes ...func(*gorm.DB) *gorm.DB) ([]byte, bool, error) {
+	e, ok, err := sc.getEntry(ctx, key, scopes...)
+	if !ok {
+		return nil, false, err
+	}
+	return e.Value, true, nil
+}
+
+func (sc *sqlCache) getEntry(ctx context.Context, key string, scopes ...func(*gorm.DB) *gorm.DB) (*Entry, bool, error) {
+	var cache CacheModel
+	err := sc.db.WithContext(ctx).Where(&CacheModel{Key: key}).Scopes(scopes...).First(&cache).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	if cache.Value == nil {
+		return nil, false, nil
+	}
+
+	dec, err := decode(cache.Value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	e := &Entry{
+		Key:       cache.Key,
+		Value:     dec,
+		CreatedAt: cache.CreatedAt,
+		ExpiresAt: cache.ExpiresAt,
+	}
+	if cache.Hash == "" {
+		return e, true, nil
+	}
+
+	body, err := sc.getBody(ctx, cache.Hash)
+	if err != nil {
+		if errors.Is(err, errBodyNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	e.Value = join(dec, body)
+	return e, true, nil
+}
+
+func (sc *sqlCache) Cache(key string, val []byte) error {
+	return sc.CacheWithTTL(key, val, 0)
+}
+
+// CacheWithTTL 只在缓存不存在或已过期时写入，不需要先查询是否存在
+func (sc *sqlCache) CacheWithTTL(key string, val []byte, ttl time.Duration) error {
+	if sc.dedup {
+		return sc.cacheDedup(newCacheModel(key, val, ttl))
+	}
+
+	val, err := sc.compression.encode(val)
+	if err != nil {
+		return err
+	}
+	m := newCacheModel(key, val, ttl)
+
+	res := sc.db.Clauses(clause.OnConflict{DoNothing: true}).Create(m)
+	if res.Error != nil || res.RowsAffected > 0 {
+		return res.Error
+	}
+
+	// 已存在同名的缓存，只覆盖已过期的
+	return sc.db.Model(&CacheModel{}).
+		Where(&CacheModel{Key: key}).
+		Where("expires_at IS NOT NULL AND expires_at <= ?", m.CreatedAt).
+		Updates(map[string]interface{}{
+			"value":      m.Value,
+			"created_at": m.CreatedAt,
+			"expires_at": m.ExpiresAt,
+			"hash":       "",
+		}).Error
+}
+
+func (sc *sqlCache) Update(key string, val []byte, ttl time.Duration) error {
+	return sc.Set(context.Background(), key, val, ttl)
+}
+
+func (sc *sqlCache) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	return sc.save(ctx, []*CacheModel{newCacheModel(key, val, ttl)})
+}
+
+// SetMany 用一条 INSERT ... ON CONFLICT DO UPDATE 语句写入多条缓存，超过 sqlBatchSize 条时分批写入
+func (sc *sqlCache) SetMany(ctx context.Context, entries []*Entry) error {
+	models := make([]*CacheModel, 0, len(entries))
+	for _, e := range entries {
+		models = append(models, &CacheModel{
+			Key:       e.Key,
+			Value:     e.Value,
+			CreatedAt: e.CreatedAt,
+			ExpiresAt: e.ExpiresAt,
+		})
+	}
+	return sc.save(ctx, models)
+}
+
+// save 写入未压缩的缓存，已存在时覆盖
+func (sc *sqlCache) save(ctx context.Context, models []*CacheModel) error {
+	if sc.dedup {
+		return sc.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return sc.saveDedup(tx, models)
+		})
+	}
+
+	for _, m := range models {
+		val, err := sc.compression.encode(m.Value)
+		if err != nil {
+			return err
+		}
+		m.Value = val
+	}
+	return upsert(sc.db.WithContext(ctx), models)
+}
+
+// upsert 插入缓存，已存在时覆盖
+func upsert(db *gorm.DB, models []*CacheModel) error {
+	if len(models) == 0 {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		// UpdateAll 不会更新 created_at
+		DoUpdates: clause.AssignmentColumns([]string{"value", "created_at", "expires_at", "hash"}),
+	}).CreateInBatches(models, sqlBatchSize).Error
+}
+
+func (sc *sqlCache) Range(fn func(e *Entry) bool) error {
+	var models []CacheModel
+	err := sc.db.FindInBatches(&models, sqlBatchSize, func(tx *gorm.DB, batch int) error {
+		var hashes []string
+		for i := range models {
+			if models[i].Hash != "" {
+				hashes = append(hashes, models[i].Hash)
+			}
+		}
+		bodies, err := loadBodies(sc.db, hashes)
+		if err != nil {
+			return err
+		}
+
+		for i := range models {
+			m := &models[i]
+			val, err := decode(m.Value)
+			if err != nil {
+				return err
+			}
+
+			if m.Hash != "" {
+				body, ok := bodies[m.Hash]
+				if !ok {
+					// 引用的响应体已被删除
+					continue
+				}
+				val = join(val, body)
+			}
+
+			e := &Entry{
+				Key:       m.Key,
+				Value:     val,
+				CreatedAt: m.CreatedAt,
+				ExpiresAt: m.ExpiresAt,
+			}
+			if !fn(e) {
+				return errStopRange
+			}
+		}
+		return nil
+	}).Error
+	if errors.Is(err, errStopRange) {
+		return nil
+	}
+	return err
+}
+
+// Delete 同时删除不再被引用的响应体
+func (sc *sqlCache) Delete(key string) error {
+	return sc.db.Transaction(func(tx *gorm.DB) error {
+		var hashes []string
+		err := tx.Model(&CacheModel{}).Where(&CacheModel{Key: key}).Where("hash <> ''").Pluck("hash", &hashes).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where(&CacheModel{Key: key}).Delete(&CacheModel{}).Error
+		if err != nil {
+			return err
+		}
+		return recountBodies(tx, hashes)
+	})
+}
+
+// PurgeExpired 同时删除不再被引用的响应体
+func (sc *sqlCache) PurgeExpired() error {
+	err := sc.db.Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).Delete(&CacheModel{}).Error
+	if err != nil {
+		return err
+	}
+	return sc.CollectGarbage()
+}
+
+// SetLogger 将 gorm 的日志输出到 l，Config 中已设置 Logger 时不起作用。
+// 设置了缓存的爬虫在创建时会用自己的 logger 调用此方法
+func (sc *sqlCache) SetLogger(l zerolog.Logger) {
+	if sc.hasLogger || sc.db == nil {
+		return
+	}
+	sc.db.Logger = dao.NewLogger(&l, 0, 0)
+}
+
+// Close 关闭数据库连接
+func (sc *sqlCache) Close() error {
+	db, err := sc.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func (sc *sqlCache) Clear() error {
+	db := sc.db.Session(&gorm.Session{AllowGlobalUpdate: true})
+	err := db.Delete(&CacheModel{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Delete(&BodyModel{}).Error
+}
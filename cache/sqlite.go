@@ -3,23 +3,24 @@
  * @Email: thepoy@163.com
  * @File Name: sqlite.go
  * @Created: 2021-07-24 22:20:47
- * @Modified: 2021-07-31 11:36:14
+ * @Modified: 2026-10-18 23:32:49
  */
 
 package cache
 
 import (
-	"errors"
-
 	"github.com/thep0y/predator/dao"
-	"github.com/thep0y/predator/tools"
-	"gorm.io/gorm"
 )
 
 type SQLiteCache struct {
-	URI        string
-	db         *dao.Sqlite
-	compressed bool
+	URI string
+	db  *dao.Sqlite
+	Compression
+	// 按内容去重保存响应体，相同的响应体只保存一次，见 JoinBody
+	Dedup bool
+	// 连接池和日志设置，没有设置 Logger 时使用爬虫的 logger
+	Config dao.Config
+	sqlCache
 }
 
 func (sc *SQLiteCache) Init() error {
@@ -27,68 +28,21 @@ func (sc *SQLiteCache) Init() error {
 		sc.URI = "predator-cache.sqlite"
 	}
 	sc.db = &dao.Sqlite{
-		URI: sc.URI,
+		URI:    sc.URI,
+		Config: sc.Config,
 	}
 	err := sc.db.Init()
 	if err != nil {
 		return err
 	}
 
-	err = sc.db.AutoMigrate(&CacheModel{})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (sc *SQLiteCache) IsCached(key string) ([]byte, bool) {
-	var cache CacheModel
-	err := sc.db.SelectOneWithWhere(&cache, "`key` = ?", key)
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, false
-		}
-		panic(err)
-	}
-
-	if cache.Value != nil {
-		if sc.compressed {
-			dec, err := tools.Decompress(cache.Value)
-			if err != nil {
-				panic(err)
-			}
-			return dec, true
-		}
-		return cache.Value, true
-	}
-	return nil, false
-}
-
-func (sc *SQLiteCache) Cache(key string, val []byte) error {
-	// 这里不能用 CheckCache，因为 value 值很长，获取 Value 和解压过程耗时较长
-	var count int
-	err := sc.db.DB.Model(&CacheModel{}).Select("COUNT(*)").Where("`key` = ?", key).Scan(&count).Error
+	err = migrateSchema(sc.db)
 	if err != nil {
 		return err
 	}
-
-	if count == 0 {
-		if sc.compressed {
-			val = tools.Compress(val)
-		}
-		return sc.db.Insert(&CacheModel{
-			Key:   key,
-			Value: val,
-		})
-	}
-
+	sc.sqlCache.db = sc.db.DB
+	sc.sqlCache.compression = &sc.Compression
+	sc.sqlCache.dedup = sc.Dedup
+	sc.sqlCache.hasLogger = sc.Config.Logger != nil
 	return nil
 }
-
-func (sc *SQLiteCache) Clear() error {
-	return sc.db.Truncate(&CacheModel{})
-}
-
-func (sc *SQLiteCache) Compressed(yes bool) {
-	sc.compressed = yes
-}
@@ -0,0 +1,287 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: store.go
+ * @Created: 2026-10-18 22:59:16
+ * @Modified: 2026-10-18 23:54:12
+ */
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNotSupported = errors.New("the operation is not supported by the cache")
+
+// Store 是第二版的缓存接口，所有操作都接收 context 并返回错误，
+// 缓存出错时由调用方决定如何处理，而不是 panic。
+//
+// 实现了 Cache 的缓存可以用 NewStore 转换为 Store。
+type Store interface {
+	// 读取未过期的缓存，不存在时返回 (nil, false, nil)
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// 保存缓存，已存在时覆盖，ttl 为 0 时永不过期
+	Set(ctx context.Context, key string, val []byte, ttl time.Duration) error
+	// 删除缓存，缓存不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// 释放连接等资源，关闭后不能再使用
+	Close() error
+	// 返回统计信息
+	Stats() Stats
+}
+
+// StaleStore 可以读取已过期但还未被删除的缓存的 Store
+type StaleStore interface {
+	Store
+	// 与 Get 相同，但不检查缓存是否过期
+	GetStale(ctx context.Context, key string) ([]byte, bool, error)
+}
+
+// Stats 缓存的统计信息，缓存无法统计的字段为 0
+type Stats struct {
+	// 命中次数
+	Hits uint64
+	// 未命中次数，包括已过期的缓存
+	Misses uint64
+	// 读写出错的次数
+	Errors uint64
+	// 因超出限制被淘汰的缓存数
+	Evictions uint64
+	// 当前缓存条数
+	Entries int
+	// 当前缓存值的总字节数
+	Bytes int64
+}
+
+// 已实现的缓存除 Cache 外还实现了以下方法，NewStore 会优先使用它们
+type (
+	getter interface {
+		Get(ctx context.Context, key string) ([]byte, bool, error)
+	}
+
+	// entryGetter 读取未过期的缓存及其过期时间，多层缓存回填时用来保留剩余的过期时间
+	entryGetter interface {
+		GetEntry(ctx context.Context, key string) (*Entry, bool, error)
+	}
+
+	staleGetter interface {
+		GetStale(ctx context.Context, key string) ([]byte, bool, error)
+	}
+
+	setter interface {
+		Set(ctx context.Context, key string, val []byte, ttl time.Duration) error
+	}
+
+	statsProvider interface {
+		Stats() Stats
+	}
+)
+
+// NewStore 将 Cache 转换为 Store，c 需要已经初始化。
+//
+// 只实现了 Cache 的缓存在读写时发生的 panic 会被转换为错误，
+// 不支持过期时间的缓存会忽略 ttl。
+func NewStore(c Cache) Store {
+	if s, ok := c.(Store); ok {
+		return s
+	}
+	return &storeAdapter{cache: c}
+}
+
+// Unwrap 返回 NewStore 转换前的 Cache。s 不是由 NewStore 转换的时，
+// 如果 s 本身实现了 Cache 则返回 s，否则返回 nil
+func Unwrap(s Store) Cache {
+	switch v := s.(type) {
+	case *storeAdapter:
+		return v.cache
+	case *BatchStore:
+		return Unwrap(v.store)
+	case Cache:
+		return v
+	}
+	return nil
+}
+
+type storeAdapter struct {
+	cache Cache
+
+	hits   uint64
+	misses uint64
+	errors uint64
+}
+
+func (sa *storeAdapter) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return sa.get(ctx, key, false)
+}
+
+// GetStale 缓存没有实现 StaleCache 时总是未命中
+func (sa *storeAdapter) GetStale(ctx context.Context, key string) ([]byte, bool, error) {
+	return sa.get(ctx, key, true)
+}
+
+func (sa *storeAdapter) get(ctx context.Context, key string, stale bool) (val []byte, ok bool, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			val, ok, err = nil, false, recoveredError(r)
+		}
+		sa.count(ok, err)
+	}()
+
+	return get(ctx, sa.cache, key, stale)
+}
+
+// get 优先使用缓存实现的 Get / GetStale，stale 为 true 且缓存不支持读取过期缓存时总是未命中
+func get(ctx context.Context, c Cache, key string, stale bool) ([]byte, bool, error) {
+	if stale {
+		switch sc := c.(type) {
+		case staleGetter:
+			return sc.GetStale(ctx, key)
+		case StaleCache:
+			val, ok := sc.IsCachedStale(key)
+			return val, ok, nil
+		}
+		return nil, false, nil
+	}
+
+	if g, ok := c.(getter); ok {
+		return g.Get(ctx, key)
+	}
+	val, ok := c.IsCached(key)
+	return val, ok, nil
+}
+
+// getEntry 缓存没有实现 GetEntry 时，返回的 Entry 中只有 Key 和 Value
+func getEntry(ctx context.Context, c Cache, key string) (*Entry, bool, error) {
+	if eg, ok := c.(entryGetter); ok {
+		return eg.GetEntry(ctx, key)
+	}
+
+	val, ok, err := get(ctx, c, key, false)
+	if !ok {
+		return nil, false, err
+	}
+	return &Entry{Key: key, Value: val}, true, nil
+}
+
+func (sa *storeAdapter) count(hit bool, err error) {
+	switch {
+	case err != nil:
+		atomic.AddUint64(&sa.errors, 1)
+	case hit:
+		atomic.AddUint64(&sa.hits, 1)
+	default:
+		atomic.AddUint64(&sa.misses, 1)
+	}
+}
+
+func (sa *storeAdapter) Set(ctx context.Context, key string, val []byte, ttl time.Duration) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+		}
+		if err != nil {
+			atomic.AddUint64(&sa.errors, 1)
+		}
+	}()
+
+	return set(ctx, sa.cache, key, val, ttl)
+}
+
+// set 优先使用缓存实现的 Set，其次是 Update，都没有实现时不会覆盖未过期的缓存
+func set(ctx context.Context, c Cache, key string, val []byte, ttl time.Duration) error {
+	switch sc := c.(type) {
+	case setter:
+		return sc.Set(ctx, key, val, ttl)
+	case UpdatableCache:
+		return sc.Update(key, val, ttl)
+	}
+	return cacheWithTTL(c, key, val, ttl)
+}
+
+// SetMany 缓存没有实现 BatchSetter 时逐条写入
+func (sa *storeAdapter) SetMany(ctx context.Context, entries []*Entry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+		}
+		if err != nil {
+			atomic.AddUint64(&sa.errors, 1)
+		}
+	}()
+
+	if bs, ok := sa.cache.(BatchSetter); ok {
+		return bs.SetMany(ctx, entries)
+	}
+
+	for _, e := range entries {
+		if e.Expired() {
+			continue
+		}
+		if err = set(ctx, sa.cache, e.Key, e.Value, e.TTL()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush 缓存实现了 Flusher 时等待缓存写入完成
+func (sa *storeAdapter) Flush() error {
+	if f, ok := sa.cache.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Delete 缓存需要实现 IterableCache，否则返回 ErrNotSupported
+func (sa *storeAdapter) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ic, ok := sa.cache.(IterableCache)
+	if !ok {
+		return ErrNotSupported
+	}
+	return ic.Delete(key)
+}
+
+// Close 缓存实现了 io.Closer 时关闭缓存
+func (sa *storeAdapter) Close() error {
+	if c, ok := sa.cache.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Stats 命中、未命中和出错次数由 Store 统计，其余字段由支持统计的缓存提供
+func (sa *storeAdapter) Stats() Stats {
+	var stats Stats
+	if sp, ok := sa.cache.(statsProvider); ok {
+		stats = sp.Stats()
+	}
+	stats.Hits = atomic.LoadUint64(&sa.hits)
+	stats.Misses = atomic.LoadUint64(&sa.misses)
+	stats.Errors = atomic.LoadUint64(&sa.errors)
+	return stats
+}
+
+func recoveredError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("cache panic: %v", r)
+}
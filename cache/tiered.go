@@ -0,0 +1,319 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: tiered.go
+ * @Created: 2026-10-18 22:38:26
+ * @Modified: 2026-10-19 00:16:23
+ */
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var ErrNoCacheLayer = errors.New("the tiered cache has no layer")
+
+// TieredCache 将多个缓存按从快到慢的顺序组合在一起，如内存 -> Redis -> PostgreSQL。
+//
+// 读取时按顺序查找，在较慢的层中命中时，会回填到所有较快的层中。
+// 写入时写入所有层，开启 WriteBehind 后只同步写入第一层，其余层在后台按顺序写入。
+type TieredCache struct {
+	// 开启后只有第一层同步写入，其他层在后台写入，需要在 Init 前设置。
+	// 程序退出前需要调用 Flush 等待后台写入完成。
+	WriteBehind bool
+	// 回填到较快层的缓存的最长过期时间，0 为不限制。
+	//
+	// 回填的缓存使用命中层中剩余的过期时间，命中层无法读取过期时间时使用 BackfillTTL。
+	BackfillTTL time.Duration
+
+	layers []Cache
+
+	// 保护 writes，避免 Close 关闭通道时还有协程在写入
+	writesLock sync.RWMutex
+	writes     chan func() error
+	pending    sync.WaitGroup
+	errLock    sync.Mutex
+	err        error
+}
+
+// Tiered 创建多层缓存，layers 按从快到慢的顺序传入
+func Tiered(layers ...Cache) *TieredCache {
+	return &TieredCache{layers: layers}
+}
+
+// Layers 返回所有层
+func (tc *TieredCache) Layers() []Cache {
+	return tc.layers
+}
+
+// Compressed 对所有层设置是否压缩
+func (tc *TieredCache) Compressed(yes bool) {
+	for _, l := range tc.layers {
+		l.Compressed(yes)
+	}
+}
+
+func (tc *TieredCache) Init() error {
+	if len(tc.layers) == 0 {
+		return ErrNoCacheLayer
+	}
+
+	for _, l := range tc.layers {
+		if err := l.Init(); err != nil {
+			return err
+		}
+	}
+
+	tc.writesLock.Lock()
+	if tc.WriteBehind && tc.writes == nil {
+		tc.writes = make(chan func() error, 1024)
+		go tc.writeLoop(tc.writes)
+	}
+	tc.writesLock.Unlock()
+
+	return nil
+}
+
+func (tc *TieredCache) writeLoop(writes <-chan func() error) {
+	for write := range writes {
+		if err := write(); err != nil {
+			tc.errLock.Lock()
+			if tc.err == nil {
+				tc.err = err
+			}
+			tc.errLock.Unlock()
+		}
+		tc.pending.Done()
+	}
+}
+
+// IsCached 出错的层视为未缓存，需要处理错误时使用 Get
+func (tc *TieredCache) IsCached(key string) ([]byte, bool) {
+	val, ok, _ := tc.Get(context.Background(), key)
+	return val, ok
+}
+
+// Get 按顺序查找，某一层出错时继续查找下一层，所有层都未命中时返回第一个错误
+func (tc *TieredCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	e, ok, err := tc.GetEntry(ctx, key)
+	if !ok {
+		return nil, false, err
+	}
+	return e.Value, true, nil
+}
+
+// GetEntry 与 Get 相同，但同时返回命中层中缓存的过期时间
+func (tc *TieredCache) GetEntry(ctx context.Context, key string) (*Entry, bool, error) {
+	var firstErr error
+	for i, l := range tc.layers {
+		e, ok, err := getEntry(ctx, l, key)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		// 回填失败不影响本次读取
+		if ttl, ok := tc.backfillTTL(e); ok {
+			for _, faster := range tc.layers[:i] {
+				_ = cacheWithTTL(faster, key, e.Value, ttl)
+			}
+		}
+		return e, true, nil
+	}
+	return nil, false, firstErr
+}
+
+// backfillTTL 返回回填时使用的过期时间，缓存已经过期时不回填
+func (tc *TieredCache) backfillTTL(e *Entry) (time.Duration, bool) {
+	if e.ExpiresAt == nil {
+		return tc.BackfillTTL, true
+	}
+
+	ttl := e.TTL()
+	if ttl <= 0 {
+		return 0, false
+	}
+	if tc.BackfillTTL > 0 && ttl > tc.BackfillTTL {
+		ttl = tc.BackfillTTL
+	}
+	return ttl, true
+}
+
+// IsCachedStale 按顺序在支持 StaleCache 的层中查找，不会回填
+func (tc *TieredCache) IsCachedStale(key string) ([]byte, bool) {
+	val, ok, _ := tc.GetStale(context.Background(), key)
+	return val, ok
+}
+
+func (tc *TieredCache) GetStale(ctx context.Context, key string) ([]byte, bool, error) {
+	var firstErr error
+	for _, l := range tc.layers {
+		val, ok, err := get(ctx, l, key, true)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if ok {
+			return val, true, nil
+		}
+	}
+	return nil, false, firstErr
+}
+
+func (tc *TieredCache) Cache(key string, val []byte) error {
+	return tc.CacheWithTTL(key, val, 0)
+}
+
+func (tc *TieredCache) CacheWithTTL(key string, val []byte, ttl time.Duration) error {
+	return tc.write(context.Background(), func(_ context.Context, l Cache) error {
+		return cacheWithTTL(l, key, val, ttl)
+	})
+}
+
+func (tc *TieredCache) Update(key string, val []byte, ttl time.Duration) error {
+	return tc.Set(context.Background(), key, val, ttl)
+}
+
+// Set 开启 WriteBehind 时，只有第一层使用 ctx，后台写入不受 ctx 影响
+func (tc *TieredCache) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	return tc.write(ctx, func(ctx context.Context, l Cache) error {
+		return set(ctx, l, key, val, ttl)
+	})
+}
+
+// write 后台写入时使用 context.Background()，不受调用方 ctx 取消的影响
+func (tc *TieredCache) write(ctx context.Context, fn func(ctx context.Context, l Cache) error) error {
+	if len(tc.layers) == 0 {
+		return ErrNoCacheLayer
+	}
+
+	tc.writesLock.RLock()
+	defer tc.writesLock.RUnlock()
+
+	if tc.writes == nil {
+		for _, l := range tc.layers {
+			if err := fn(ctx, l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := fn(ctx, tc.layers[0]); err != nil {
+		return err
+	}
+
+	for _, l := range tc.layers[1:] {
+		l := l
+		tc.pending.Add(1)
+		tc.writes <- func() error {
+			return fn(context.Background(), l)
+		}
+	}
+	return nil
+}
+
+// Flush 等待后台写入全部完成，返回后台写入中出现的第一个错误
+func (tc *TieredCache) Flush() error {
+	tc.pending.Wait()
+
+	tc.errLock.Lock()
+	defer tc.errLock.Unlock()
+
+	err := tc.err
+	tc.err = nil
+	return err
+}
+
+// Range 遍历最后一个支持遍历的层，通常是最完整的持久化缓存
+func (tc *TieredCache) Range(fn func(e *Entry) bool) error {
+	for i := len(tc.layers) - 1; i >= 0; i-- {
+		if ic, ok := tc.layers[i].(IterableCache); ok {
+			return ic.Range(fn)
+		}
+	}
+	return ErrNotIterable
+}
+
+// Delete 从所有支持删除的层中删除
+func (tc *TieredCache) Delete(key string) error {
+	if err := tc.Flush(); err != nil {
+		return err
+	}
+
+	for _, l := range tc.layers {
+		if ic, ok := l.(IterableCache); ok {
+			if err := ic.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close 等待后台写入完成后关闭所有层，返回遇到的第一个错误
+func (tc *TieredCache) Close() error {
+	// 先停止接收新的后台写入，已提交的写入仍会完成
+	tc.writesLock.Lock()
+	if tc.writes != nil {
+		close(tc.writes)
+		tc.writes = nil
+	}
+	tc.writesLock.Unlock()
+
+	err := tc.Flush()
+
+	for _, l := range tc.layers {
+		if c, ok := l.(io.Closer); ok {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func (tc *TieredCache) PurgeExpired() error {
+	for _, l := range tc.layers {
+		if ec, ok := l.(ExpirableCache); ok {
+			if err := ec.PurgeExpired(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (tc *TieredCache) Clear() error {
+	// 先等待后台写入完成，避免清除后又被写入
+	if err := tc.Flush(); err != nil {
+		return err
+	}
+
+	for _, l := range tc.layers {
+		if err := l.Clear(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheWithTTL 缓存不支持过期时间时忽略 ttl
+func cacheWithTTL(c Cache, key string, val []byte, ttl time.Duration) error {
+	if ec, ok := c.(ExpirableCache); ok {
+		return ec.CacheWithTTL(key, val, ttl)
+	}
+	return c.Cache(key, val)
+}
@@ -0,0 +1,86 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: cacheinspect.go
+ * @Created: 2026-10-18 22:45:19
+ * @Modified: 2026-10-18 22:53:31
+ */
+
+package predator
+
+import (
+	"strings"
+
+	"github.com/thep0y/predator/cache"
+)
+
+// CachedRequest 是随响应一起缓存的请求信息，缓存的键是哈希值，
+// 需要通过这些信息才能知道缓存对应的是哪个请求
+type CachedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// 只有 POST 请求才保存请求体
+	Body []byte `json:"body,omitempty"`
+}
+
+// CacheEntry 是缓存中的一条记录
+type CacheEntry struct {
+	*cache.Entry
+	// 缓存的请求信息，旧版本缓存的响应中没有请求信息，此时各字段为零值
+	Request    CachedRequest
+	StatusCode int
+	// 重定向后最终的链接，旧版本缓存的响应中没有此信息
+	FinalURL string
+}
+
+// Response 解码缓存的响应
+func (e *CacheEntry) Response() (*Response, error) {
+	resp, _, err := unmarshalCacheRecord(e.Value)
+	return resp, err
+}
+
+func newCacheEntry(e *cache.Entry) (*CacheEntry, error) {
+	resp, req, err := unmarshalCacheRecord(e.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	ce := &CacheEntry{
+		Entry:      e,
+		StatusCode: resp.StatusCode,
+		FinalURL:   resp.FinalURL,
+	}
+	if req != nil {
+		ce.Request = *req
+	}
+	return ce, nil
+}
+
+// InspectCache 遍历缓存中的所有记录，包括已过期但还未被删除的记录，fn 返回 false 时停止遍历。
+//
+// 缓存需要实现 cache.IterableCache，否则返回 cache.ErrNotIterable。
+func InspectCache(cc cache.Cache, fn func(e *CacheEntry) bool) error {
+	var err error
+	rangeErr := cache.Range(cc, func(e *cache.Entry) bool {
+		var ce *CacheEntry
+		ce, err = newCacheEntry(e)
+		if err != nil {
+			return false
+		}
+		return fn(ce)
+	})
+	if rangeErr != nil {
+		return rangeErr
+	}
+	return err
+}
+
+// SearchCache 遍历请求链接以 urlPrefix 开头的缓存记录
+func SearchCache(cc cache.Cache, urlPrefix string, fn func(e *CacheEntry) bool) error {
+	return InspectCache(cc, func(e *CacheEntry) bool {
+		if !strings.HasPrefix(e.Request.URL, urlPrefix) {
+			return true
+		}
+		return fn(e)
+	})
+}
@@ -0,0 +1,168 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: cachekey.go
+ * @Created: 2026-10-18 22:39:37
+ * @Modified: 2026-10-18 22:39:37
+ */
+
+package predator
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/thep0y/predator/json"
+)
+
+// CacheKeyFunc 根据请求生成缓存的键，相同的键视为同一个请求
+type CacheKeyFunc func(r *Request) (string, error)
+
+// CacheKeyOption 是 NewCacheKeyFunc 的可选项
+type CacheKeyOption func(*cacheKeyBuilder)
+
+type cacheKeyBuilder struct {
+	normalize bool
+	allow     []string
+	deny      []string
+	headers   []string
+}
+
+// CacheKeyNormalizeURL 规范化链接：协议和主机名转为小写，去掉默认端口和锚点，
+// 空路径改为 "/"，查询参数按名称排序
+func CacheKeyNormalizeURL() CacheKeyOption {
+	return func(b *cacheKeyBuilder) {
+		b.normalize = true
+	}
+}
+
+// CacheKeyAllowQuery 只有这些查询参数参与生成缓存的键，
+// 参数名以 "*" 结尾时按前缀匹配，如 "page_*"
+func CacheKeyAllowQuery(params ...string) CacheKeyOption {
+	return func(b *cacheKeyBuilder) {
+		b.allow = append(b.allow, params...)
+	}
+}
+
+// CacheKeyDenyQuery 忽略这些查询参数，如时间戳、追踪参数等，
+// 参数名以 "*" 结尾时按前缀匹配，如 "utm_*"
+func CacheKeyDenyQuery(params ...string) CacheKeyOption {
+	return func(b *cacheKeyBuilder) {
+		b.deny = append(b.deny, params...)
+	}
+}
+
+// CacheKeyHeaders 将这些请求头的值加入缓存的键，
+// 用于响应会随请求头变化的情况，如 Accept-Language
+func CacheKeyHeaders(names ...string) CacheKeyOption {
+	return func(b *cacheKeyBuilder) {
+		for _, name := range names {
+			b.headers = append(b.headers, http.CanonicalHeaderKey(name))
+		}
+	}
+}
+
+// NewCacheKeyFunc 创建一个 CacheKeyFunc。
+//
+// 请求体的处理与默认的 Request.Hash 相同：POST 请求设置了缓存字段时只使用缓存字段，
+// 否则使用整个请求体。
+func NewCacheKeyFunc(opts ...CacheKeyOption) CacheKeyFunc {
+	b := &cacheKeyBuilder{}
+	for _, op := range opts {
+		op(b)
+	}
+	sort.Strings(b.headers)
+
+	return b.key
+}
+
+type cacheKeyRequest struct {
+	cacheRequest
+	Headers [][2]string `json:",omitempty"`
+}
+
+func (b *cacheKeyBuilder) key(r *Request) (string, error) {
+	URL, err := b.url(r.URL)
+	if err != nil {
+		return "", err
+	}
+
+	cr := &cacheKeyRequest{
+		cacheRequest: cacheRequest{
+			URL:    URL,
+			Method: r.Method,
+			Body:   r.cacheBody(),
+		},
+	}
+
+	for _, name := range b.headers {
+		var val []byte
+		if r.Headers != nil {
+			val = r.Headers.Peek(name)
+		}
+		cr.Headers = append(cr.Headers, [2]string{name, string(val)})
+	}
+
+	data, err := json.Marshal(cr)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha1.Sum(data)), nil
+}
+
+func (b *cacheKeyBuilder) url(rawURL string) (string, error) {
+	if !b.normalize && len(b.allow) == 0 && len(b.deny) == 0 {
+		return rawURL, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	if b.normalize {
+		u.Scheme = strings.ToLower(u.Scheme)
+		u.Host = strings.ToLower(u.Host)
+		if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+			u.Host = u.Hostname()
+		}
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	query := u.Query()
+	for name := range query {
+		if len(b.allow) > 0 && !matchParam(b.allow, name) {
+			query.Del(name)
+			continue
+		}
+		if matchParam(b.deny, name) {
+			query.Del(name)
+		}
+	}
+	// Encode 会按参数名排序
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+func matchParam(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(name, p[:len(p)-1]) {
+				return true
+			}
+		} else if p == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: cachepolicy.go
+ * @Created: 2026-10-18 22:40:20
+ * @Modified: 2026-10-18 22:40:20
+ */
+
+package predator
+
+import "github.com/valyala/fasthttp"
+
+// CachePolicy 根据响应的状态码、响应头和响应体判断响应是否可以缓存
+type CachePolicy func(statusCode int, headers *fasthttp.ResponseHeader, body []byte) bool
+
+// DefaultCachePolicy 只缓存状态码为 200 或 201 的响应
+func DefaultCachePolicy(statusCode int, headers *fasthttp.ResponseHeader, body []byte) bool {
+	return statusCode == fasthttp.StatusOK || statusCode == fasthttp.StatusCreated
+}
+
+// CacheStatus 只缓存状态码为 codes 之一的响应
+func CacheStatus(codes ...int) CachePolicy {
+	return func(statusCode int, headers *fasthttp.ResponseHeader, body []byte) bool {
+		for _, code := range codes {
+			if statusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: cacherecord.go
+ * @Created: 2026-10-18 22:53:31
+ * @Modified: 2026-10-19 00:16:52
+ */
+
+package predator
+
+import (
+	"errors"
+	"time"
+
+	"github.com/thep0y/predator/cache"
+	"github.com/thep0y/predator/json"
+	"github.com/tidwall/gjson"
+	"github.com/valyala/fasthttp"
+)
+
+// cacheRecordVersion 缓存记录格式的版本，格式发生不兼容的变化时递增，
+// 并在 unmarshalCacheRecord 中保留读取旧版本的逻辑
+const cacheRecordVersion = 1
+
+var ErrUnsupportedCacheRecord = errors.New("the cache record was written by a newer version")
+
+// cacheRecord 是保存到缓存中的响应。
+//
+// 字段名是稳定的，只能增加字段，修改或删除字段需要递增 cacheRecordVersion。
+//
+// 响应体不保存在 json 中，而是用 cache.JoinBody 拼接在 json 之后，
+// 开启去重的缓存可以只对响应体去重。
+type cacheRecord struct {
+	Version    int `json:"v"`
+	StatusCode int `json:"status"`
+	// 按原顺序保存的响应头，同名的响应头可能出现多次
+	Headers [][2]string `json:"headers,omitempty"`
+	// 重定向后最终的链接
+	URL       string    `json:"url,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+	// 请求耗时，单位为纳秒
+	Latency   time.Duration   `json:"latency"`
+	Body      []byte          `json:"-"`
+	Request   *CachedRequest  `json:"request,omitempty"`
+	Validator *CacheValidator `json:"validator,omitempty"`
+}
+
+// legacyCacheRecord 是版本 0 的缓存记录，即直接序列化的 Response，其中没有响应头
+type legacyCacheRecord struct {
+	StatusCode int
+	Body       []byte
+	Validator  *CacheValidator
+	Request    *CachedRequest
+}
+
+func newCacheRecord(r *Response) *cacheRecord {
+	rec := &cacheRecord{
+		Version:    cacheRecordVersion,
+		StatusCode: r.StatusCode,
+		URL:        r.FinalURL,
+		FetchedAt:  r.FetchedAt,
+		Latency:    r.Latency,
+		Body:       r.Body,
+		Validator:  r.Validator,
+	}
+
+	r.Headers.VisitAll(func(key, value []byte) {
+		rec.Headers = append(rec.Headers, [2]string{string(key), string(value)})
+	})
+
+	if r.Request != nil {
+		rec.Request = &CachedRequest{
+			Method: r.Request.Method,
+			URL:    r.Request.URL,
+		}
+		if r.Request.Method == fasthttp.MethodPost {
+			rec.Request.Body = r.Request.Body
+		}
+	}
+
+	return rec
+}
+
+// marshalCacheRecord 将响应序列化为当前版本的缓存记录
+func marshalCacheRecord(r *Response) ([]byte, error) {
+	rec := newCacheRecord(r)
+	meta, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return cache.JoinBody(meta, rec.Body), nil
+}
+
+// unmarshalCacheRecord 解码任意版本的缓存记录
+func unmarshalCacheRecord(data []byte) (*Response, *CachedRequest, error) {
+	// 版本 0 的缓存记录是完整的 json
+	var body []byte
+	if meta, b, ok := cache.SplitBody(data); ok {
+		data, body = meta, b
+	}
+
+	version := gjson.GetBytes(data, "v").Int()
+
+	if version == 0 {
+		var legacy legacyCacheRecord
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return nil, nil, err
+		}
+
+		resp := &Response{
+			StatusCode: legacy.StatusCode,
+			Body:       legacy.Body,
+			Validator:  legacy.Validator,
+			FromCache:  true,
+		}
+		return resp, legacy.Request, nil
+	}
+
+	if version > cacheRecordVersion {
+		return nil, nil, ErrUnsupportedCacheRecord
+	}
+
+	var rec cacheRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, nil, err
+	}
+	rec.Body = body
+
+	resp := &Response{
+		StatusCode: rec.StatusCode,
+		Body:       rec.Body,
+		FinalURL:   rec.URL,
+		FetchedAt:  rec.FetchedAt,
+		Latency:    rec.Latency,
+		Validator:  rec.Validator,
+		FromCache:  true,
+	}
+	resp.Headers.SetStatusCode(rec.StatusCode)
+	for _, h := range rec.Headers {
+		resp.Headers.Add(h[0], h[1])
+	}
+
+	return resp, rec.Request, nil
+}
@@ -3,7 +3,7 @@
  * @Email: thepoy@163.com
  * @File Name: craw.go
  * @Created: 2021-07-23 08:52:17
- * @Modified: 2021-11-05 14:31:48
+ * @Modified: 2026-10-18 23:51:52
  */
 
 package predator
@@ -16,6 +16,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/rs/zerolog"
@@ -23,12 +24,37 @@ import (
 	pctx "github.com/thep0y/predator/context"
 	"github.com/thep0y/predator/html"
 	"github.com/thep0y/predator/json"
+	"github.com/thep0y/predator/pipeline"
 	"github.com/thep0y/predator/proxy"
 	"github.com/tidwall/gjson"
 	"github.com/valyala/fasthttp"
 )
 
-var ErrNoCacheSet = errors.New("no cache set")
+var (
+	ErrNoCacheSet = errors.New("no cache set")
+	// ErrCacheMiss 离线模式下请求的响应不在缓存中，可以用 errors.Is 判断 CacheMissError
+	ErrCacheMiss = errors.New("the response is not in the cache")
+	// ErrNoItemPipeline 没有用 WithItemPipeline 设置 ItemPipeline 时调用 Emit
+	ErrNoItemPipeline = errors.New("no item pipeline set")
+)
+
+// CacheMissError 离线模式下缓存未命中时返回的错误
+type CacheMissError struct {
+	Method string
+	URL    string
+	Key    string
+}
+
+func (e *CacheMissError) Error() string {
+	return fmt.Sprintf("%s: %s %s, cache key: %s", ErrCacheMiss, e.Method, e.URL, e.Key)
+}
+
+func (e *CacheMissError) Is(target error) bool {
+	return target == ErrCacheMiss
+}
+
+// 清理过期缓存的间隔
+const cachePurgeInterval = 10 * time.Minute
 
 // HandleRequest is used to patch the request
 type HandleRequest func(r *Request)
@@ -61,6 +87,16 @@ type Crawler struct {
 	cookies         map[string]string
 	goPool          *Pool
 	proxyURLPool    []string
+	// 从环境变量中读取的代理，代理池为空时使用
+	proxyEnv *proxy.Environment
+	// 匹配这些规则的地址不使用代理
+	proxyBypass *proxy.Bypass
+	// 根据 pac 脚本选择代理
+	proxyPAC *proxy.PAC
+	// pac 脚本选择的每组代理对应的客户端
+	pacClients *sync.Map
+	// 限制每个代理的请求速率和并发连接数
+	proxyLimiter *proxy.Limiter
 	// TODO: 动态获取代理
 	// dynamicProxyFunc AcquireProxies
 	// timeout          uint
@@ -70,12 +106,35 @@ type Crawler struct {
 	Context context.Context
 
 	// Cache successful response
-	cache cache.Cache
+	cache cache.Store
+	// 每次读写缓存的超时时间，为 0 时不限制
+	cacheTimeout time.Duration
+	// 在后台批量写入缓存
+	cacheWriteBehind   bool
+	cacheFlushSize     int
+	cacheFlushInterval time.Duration
 	// List of fields to be cached in the request body, and
 	// the combination of these fields can represent the unique
 	// request body.
 	// The fewer fields the better.
 	cacheFields []string
+	// 缓存的默认过期时间，为 0 时永不过期
+	cacheTTL time.Duration
+	// 上次清理过期缓存的时间
+	cachePurgedAt int64
+	// 是否遵循 HTTP 缓存语义，过期的缓存需要向服务器重新验证
+	httpCache bool
+	// 生成缓存的键，为 nil 时使用 Request.Hash
+	cacheKeyFunc CacheKeyFunc
+	// 判断响应是否可以缓存，为 nil 时使用 DefaultCachePolicy
+	cachePolicy CachePolicy
+	// 离线模式，只从缓存中读取响应，不发出请求
+	offline bool
+	// 请求失败时使用过期的缓存
+	staleIfError bool
+
+	// 保存处理函数中产生的数据
+	itemPipeline *pipeline.ItemPipeline
 
 	requestHandler []HandleRequest
 
@@ -87,6 +146,8 @@ type Crawler struct {
 	wg *sync.WaitGroup
 
 	log zerolog.Logger
+	// 是否用 WithLogger 设置了 logger
+	hasLogger bool
 }
 
 // NewCrawler creates a new Crawler instance with some CrawlerOptions
@@ -105,6 +166,31 @@ func NewCrawler(opts ...CrawlerOption) *Crawler {
 
 	c.Context = context.Background()
 
+	if c.cacheWriteBehind && c.cache != nil {
+		c.cache = cache.NewBatchStore(c.cache, c.cacheFlushSize, c.cacheFlushInterval)
+	}
+
+	// SQL 缓存的日志也输出到爬虫的 logger
+	if ls, ok := cache.Unwrap(c.cache).(interface{ SetLogger(zerolog.Logger) }); ok && c.hasLogger {
+		ls.SetLogger(c.log)
+	}
+
+	if cc := cache.Unwrap(c.cache); cc != nil && c.cacheTTL > 0 {
+		if _, ok := cc.(cache.ExpirableCache); !ok {
+			c.log.Warn().
+				Dur("ttl", c.cacheTTL).
+				Msg("the cache does not support expiration, cache ttl is ignored")
+		}
+	}
+
+	if c.httpCache && c.cache == nil {
+		c.log.Warn().Msg("no cache set, http cache is ignored")
+	}
+
+	if c.offline && c.cache == nil {
+		c.log.Warn().Msg("no cache set, all requests will fail in offline mode")
+	}
+
 	capacityState := c.goPool != nil
 
 	if capacityState {
@@ -132,14 +218,28 @@ func (c *Crawler) Clone() *Crawler {
 		cookies:         c.cookies,
 		goPool:          c.goPool,
 		proxyURLPool:    c.proxyURLPool,
+		proxyEnv:        c.proxyEnv,
+		proxyBypass:     c.proxyBypass,
+		proxyPAC:        c.proxyPAC,
+		pacClients:      c.pacClients,
+		proxyLimiter:    c.proxyLimiter,
 		Context:         c.Context,
 		cache:           c.cache,
 		cacheFields:     c.cacheFields,
+		cacheTTL:        c.cacheTTL,
+		cacheTimeout:    c.cacheTimeout,
+		httpCache:       c.httpCache,
+		cacheKeyFunc:    c.cacheKeyFunc,
+		cachePolicy:     c.cachePolicy,
+		offline:         c.offline,
+		staleIfError:    c.staleIfError,
+		itemPipeline:    c.itemPipeline,
 		requestHandler:  make([]HandleRequest, 0, 5),
 		responseHandler: make([]HandleResponse, 0, 5),
 		htmlHandler:     make([]*HTMLParser, 0, 5),
 		wg:              &sync.WaitGroup{},
 		log:             c.log,
+		hasLogger:       c.hasLogger,
 	}
 }
 
@@ -245,7 +345,7 @@ func (c *Crawler) prepare(request *Request) (err error) {
 	var key string
 
 	if c.cache != nil {
-		key, err = request.Hash()
+		key, err = c.cacheKey(request)
 		if err != nil {
 			c.log.Error().Caller().Err(err).Send()
 			return
@@ -270,30 +370,59 @@ func (c *Crawler) prepare(request *Request) (err error) {
 	}
 
 	var rawResp *fasthttp.Response
-	// A new request is issued when there
-	// is no response from the cache
-	if response == nil {
-		response, rawResp, err = c.do(request)
-		if err != nil {
+	if c.offline {
+		// 离线模式下过期的缓存也可以使用
+		if response == nil && c.cache != nil {
+			response, err = c.checkStaleCache(key)
+			if err != nil {
+				return
+			}
+		}
+
+		if response == nil {
+			err = &CacheMissError{
+				Method: request.Method,
+				URL:    request.URL,
+				Key:    key,
+			}
+			c.log.Error().
+				Uint32("request_id", atomic.LoadUint32(&request.ID)).
+				Err(err).
+				Send()
 			return
 		}
 
-		// Save the response from the request to the cache
-		if c.cache != nil {
-			cacheVal, err := response.Marshal()
+		if c.stale(response) {
+			response.Stale = true
+		}
+		response.Request = request
+		response.Ctx = request.Ctx
+	} else if response != nil && c.stale(response) {
+		response, rawResp, err = c.revalidate(request, key, response)
+		if err != nil {
+			return
+		}
+	} else if response == nil {
+		// A new request is issued when there
+		// is no response from the cache
+		response, rawResp, err = c.do(request)
+		if stale := c.staleOnError(request, key, response, err); stale != nil {
+			if rawResp != nil {
+				fasthttp.ReleaseResponse(rawResp)
+			}
+			response, rawResp, err = stale, nil, nil
+		} else {
 			if err != nil {
 				c.log.Error().Caller().Err(err).Send()
-				return err
+				return
 			}
 
-			if cacheVal != nil {
-				c.lock.Lock()
-				err = c.cache.Cache(key, cacheVal)
+			// Save the response from the request to the cache
+			if c.cache != nil {
+				err = c.storeResponse(request, key, response)
 				if err != nil {
-					c.log.Error().Caller().Err(err).Send()
-					return err
+					return
 				}
-				c.lock.Unlock()
 			}
 		}
 	} else {
@@ -325,20 +454,293 @@ func (c *Crawler) prepare(request *Request) (err error) {
 	return
 }
 
+// cacheContext 返回读写缓存时使用的 context
+func (c *Crawler) cacheContext() (context.Context, context.CancelFunc) {
+	ctx := c.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if c.cacheTimeout > 0 {
+		return context.WithTimeout(ctx, c.cacheTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// checkCache 读取缓存，读取或解码出错时视为未命中，不会中断请求
 func (c *Crawler) checkCache(key string) (*Response, error) {
-	var err error
-	cachedBody, ok := c.cache.IsCached(key)
+	ctx, cancel := c.cacheContext()
+	defer cancel()
+
+	cachedBody, ok, err := c.cache.Get(ctx, key)
+	if err != nil {
+		c.log.Warn().
+			Str("cache_key", key).
+			Err(err).
+			Msg("failed to read the cache, treated as a cache miss")
+		return nil, nil
+	}
 	if !ok {
 		return nil, nil
 	}
-	var resp Response
-	err = json.Unmarshal(cachedBody, &resp)
+	return c.unmarshalCache(key, cachedBody), nil
+}
+
+// checkStaleCache 读取已过期但还未被删除的缓存，缓存需要实现 cache.StaleStore
+func (c *Crawler) checkStaleCache(key string) (*Response, error) {
+	sc, ok := c.cache.(cache.StaleStore)
+	if !ok {
+		return nil, nil
+	}
+
+	ctx, cancel := c.cacheContext()
+	defer cancel()
+
+	cachedBody, ok, err := sc.GetStale(ctx, key)
+	if err != nil {
+		c.log.Warn().
+			Str("cache_key", key).
+			Err(err).
+			Msg("failed to read the stale cache, treated as a cache miss")
+		return nil, nil
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	resp := c.unmarshalCache(key, cachedBody)
+	if resp != nil {
+		resp.Stale = true
+	}
+	return resp, nil
+}
+
+// unmarshalCache 解码出错时返回 nil，视为未命中
+func (c *Crawler) unmarshalCache(key string, cachedBody []byte) *Response {
+	resp, _, err := unmarshalCacheRecord(cachedBody)
+	if err != nil {
+		c.log.Warn().
+			Str("cache_key", key).
+			Err(err).
+			Msg("failed to decode the cache, treated as a cache miss")
+		return nil
+	}
+	return resp
+}
+
+// requestFailed 请求出错或服务器返回 500、502、503、504 时认为请求失败，与 RFC 5861 相同
+func requestFailed(response *Response, err error) bool {
+	if err != nil || response == nil {
+		return true
+	}
+
+	switch response.StatusCode {
+	case fasthttp.StatusInternalServerError,
+		fasthttp.StatusBadGateway,
+		fasthttp.StatusServiceUnavailable,
+		fasthttp.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// staleOnError 开启 stale-if-error 且请求失败时，返回过期的缓存，没有过期的缓存时返回 nil
+func (c *Crawler) staleOnError(request *Request, key string, response *Response, err error) *Response {
+	if !c.staleIfError || c.cache == nil || !requestFailed(response, err) {
+		return nil
+	}
+
+	stale, e := c.checkStaleCache(key)
+	if e != nil || stale == nil {
+		return nil
+	}
+
+	c.serveStale(request, key, response, err)
+
+	stale.Request = request
+	stale.Ctx = request.Ctx
+	return stale
+}
+
+func (c *Crawler) serveStale(request *Request, key string, response *Response, err error) {
+	event := c.log.Warn().
+		Uint32("request_id", atomic.LoadUint32(&request.ID)).
+		Str("cache_key", key)
+	if err != nil {
+		event = event.Err(err)
+	} else {
+		event = event.Int("status_code", response.StatusCode)
+	}
+	event.Msg("the request failed, serving the stale cache")
+}
+
+// storeResponse 将响应保存到缓存中，已有的缓存会被覆盖
+func (c *Crawler) storeResponse(request *Request, key string, response *Response) error {
+	policy := c.cachePolicy
+	if policy == nil {
+		policy = DefaultCachePolicy
+	}
+	if !policy(response.StatusCode, &response.Headers, response.Body) {
+		c.log.Debug().
+			Uint32("request_id", atomic.LoadUint32(&request.ID)).
+			Int("status_code", response.StatusCode).
+			Msg("the response is rejected by the cache policy")
+		return nil
+	}
+
+	if c.httpCache {
+		if !storable(&response.Headers) {
+			c.log.Debug().
+				Uint32("request_id", atomic.LoadUint32(&request.ID)).
+				Msg("the response is not allowed to be cached")
+			return nil
+		}
+		response.Validator = newCacheValidator(&response.Headers, nil)
+	}
+
+	cacheVal, err := response.Marshal()
+	if err != nil {
+		c.log.Error().Caller().Err(err).Send()
+		return err
+	}
+
+	if cacheVal == nil {
+		return nil
+	}
+
+	c.saveCache(key, cacheVal, c.cacheTTLOf(request))
+
+	c.purgeExpiredCache()
+
+	return nil
+}
+
+// stale 开启 HTTP 缓存语义时，判断缓存的响应是否已经不新鲜，需要重新验证
+func (c *Crawler) stale(cached *Response) bool {
+	return c.httpCache && cached.Validator != nil && !cached.Validator.Fresh()
+}
+
+// revalidate 发送带有 If-None-Match / If-Modified-Since 的条件请求验证不新鲜的缓存，
+// 服务器返回 304 时使用缓存的响应，否则使用并缓存新的响应
+func (c *Crawler) revalidate(request *Request, key string, cached *Response) (*Response, *fasthttp.Response, error) {
+	c.log.Debug().
+		Uint32("request_id", atomic.LoadUint32(&request.ID)).
+		Str("cache_key", key).
+		Str("etag", cached.Validator.ETag).
+		Str("last_modified", cached.Validator.LastModified).
+		Msg("revalidating the stale cache")
+
+	cached.Validator.setConditionalHeaders(request.Headers)
+	response, rawResp, err := c.do(request)
+	// 条件请求头只用于本次验证，不应出现在响应处理器中
+	request.Headers.Del(fasthttp.HeaderIfNoneMatch)
+	request.Headers.Del(fasthttp.HeaderIfModifiedSince)
+
+	if c.staleIfError && requestFailed(response, err) {
+		c.serveStale(request, key, response, err)
+		if rawResp != nil {
+			fasthttp.ReleaseResponse(rawResp)
+		}
+		cached.Request = request
+		cached.Ctx = request.Ctx
+		cached.Stale = true
+		return cached, nil, nil
+	}
+
+	if err != nil {
+		c.log.Error().Caller().Err(err).Send()
+		return nil, nil, err
+	}
+
+	if response.StatusCode != fasthttp.StatusNotModified {
+		err = c.storeResponse(request, key, response)
+		if err != nil {
+			return nil, nil, err
+		}
+		return response, rawResp, nil
+	}
+
+	c.log.Debug().
+		Uint32("request_id", atomic.LoadUint32(&request.ID)).
+		Str("cache_key", key).
+		Msg("the cache is not modified")
+
+	cached.Request = request
+	cached.Ctx = request.Ctx
+	// 304 响应中的 Cache-Control、Expires、Date 等头部是最新的，需要更新到缓存中
+	updateHeaders(&cached.Headers, &response.Headers)
+	cached.Validator = newCacheValidator(&cached.Headers, cached.Validator)
+
+	cacheVal, err := cached.Marshal()
 	if err != nil {
 		c.log.Error().Caller().Err(err).Send()
-		return nil, err
+		return nil, nil, err
+	}
+
+	c.saveCache(key, cacheVal, c.cacheTTLOf(request))
+
+	return cached, rawResp, nil
+}
+
+func (c *Crawler) cacheKey(request *Request) (string, error) {
+	if c.cacheKeyFunc != nil {
+		return c.cacheKeyFunc(request)
 	}
-	resp.FromCache = true
-	return &resp, nil
+	return request.Hash()
+}
+
+// cacheTTLOf 返回请求的缓存过期时间，请求中没有设置时使用 crawler 的设置
+func (c *Crawler) cacheTTLOf(request *Request) time.Duration {
+	if request.cacheTTLSet {
+		return request.cacheTTL
+	}
+	return c.cacheTTL
+}
+
+// saveCache 保存缓存，出错时只记录日志，不影响本次请求
+func (c *Crawler) saveCache(key string, val []byte, ttl time.Duration) {
+	ctx, cancel := c.cacheContext()
+	defer cancel()
+
+	err := c.cache.Set(ctx, key, val, ttl)
+	if err != nil {
+		c.log.Warn().
+			Str("cache_key", key).
+			Err(err).
+			Msg("failed to save the cache")
+	}
+}
+
+// purgeExpiredCache 距离上次清理超过 cachePurgeInterval 时，在后台删除过期的缓存
+func (c *Crawler) purgeExpiredCache() {
+	// stale-if-error 需要保留过期的缓存
+	if c.staleIfError {
+		return
+	}
+
+	ec, ok := cache.Unwrap(c.cache).(cache.ExpirableCache)
+	if !ok {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&c.cachePurgedAt)
+	if now-last < int64(cachePurgeInterval) {
+		return
+	}
+
+	// 只允许一个协程清理
+	if !atomic.CompareAndSwapInt64(&c.cachePurgedAt, last, now) {
+		return
+	}
+
+	go func() {
+		err := ec.PurgeExpired()
+		if err != nil {
+			c.log.Error().Caller().Err(err).Msg("failed to purge expired cache")
+			return
+		}
+		c.log.Debug().Msg("expired cache has been purged")
+	}()
 }
 
 func (c *Crawler) do(request *Request) (*Response, *fasthttp.Response, error) {
@@ -355,7 +757,7 @@ func (c *Crawler) do(request *Request) (*Response, *fasthttp.Response, error) {
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
 
-	if c.ProxyPoolAmount() > 0 {
+	if c.useProxy() {
 		c.client.Dial = c.DialWithProxy()
 	}
 
@@ -365,28 +767,48 @@ func (c *Crawler) do(request *Request) (*Response, *fasthttp.Response, error) {
 
 	resp := fasthttp.AcquireResponse()
 
-	var err error
+	// pac 脚本执行失败时与请求失败的处理相同
+	client, proxies, err := c.requestClient(req)
 
-	if request.maxRedirectsCount == 0 {
-		err = c.client.Do(req, resp)
-	} else {
-		err = c.client.DoRedirects(req, resp, int(request.maxRedirectsCount))
+	// 经过限制了请求速率的代理时，每个请求都要重新选择代理，不能复用长连接
+	if err == nil && c.proxyLimiter.RateLimited(proxies...) {
+		req.SetConnectionClose()
+	}
+
+	start := time.Now()
+	if err == nil {
+		if request.maxRedirectsCount == 0 {
+			err = client.Do(req, resp)
+		} else {
+			err = client.DoRedirects(req, resp, int(request.maxRedirectsCount))
+		}
 	}
 
 	if err != nil {
-		if p, ok := proxy.IsProxyInvalid(err); ok {
+		// 只有代理池中的代理才能被删除，环境变量中的代理失效时直接报错
+		if p, ok := proxy.IsProxyInvalid(err); ok && c.ProxyPoolAmount() > 0 {
 			err = c.removeInvalidProxy(p)
 			if err != nil {
 				c.log.Fatal().Caller().Err(err).Send()
 			}
 			return c.do(request)
+		} else if c.staleIfError && c.cache != nil {
+			// 由 prepare 决定是否使用过期的缓存
+			fasthttp.ReleaseRequest(req)
+			fasthttp.ReleaseResponse(resp)
+			return nil, nil, err
 		} else {
 			c.log.Fatal().Caller().Err(err).Send()
 		}
 	}
 
+	latency := time.Since(start)
+
 	// Only count successful responses
 	atomic.AddUint32(&c.responseCount, 1)
+
+	// 重定向后 req 中的链接是最终的链接
+	finalURL := req.URI().String()
 	// release req
 	fasthttp.ReleaseRequest(req)
 
@@ -396,6 +818,9 @@ func (c *Crawler) do(request *Request) (*Response, *fasthttp.Response, error) {
 		Ctx:        request.Ctx,
 		Request:    request,
 		Headers:    resp.Header,
+		FinalURL:   finalURL,
+		FetchedAt:  time.Now(),
+		Latency:    latency,
 	}
 
 	if c.retryCount > 0 && request.retryCounter < c.retryCount {
@@ -492,6 +917,11 @@ func (c *Crawler) PostMultipart(URL string, form *MultipartForm, ctx pctx.Contex
 					Send()
 			}
 		}
+	} else {
+		// 每次请求的 boundary 都是随机的，不能用整个请求体作为缓存标识
+		for k, v := range form.bodyMap {
+			cachedMap[k] = v
+		}
 	}
 
 	headers := make(map[string]string)
@@ -515,8 +945,47 @@ func (c *Crawler) ClearCache() error {
 	if c.cache == nil {
 		return ErrNoCacheSet
 	}
+	cc := cache.Unwrap(c.cache)
+	if cc == nil {
+		return cache.ErrNotSupported
+	}
+
+	// 先写入待写入的缓存，避免清除后又被写入
+	if err := c.FlushCache(); err != nil {
+		c.log.Error().Caller().Err(err).Msg("failed to flush the cache")
+	}
+
 	c.log.Warn().Msg("clear all cache")
-	return c.cache.Clear()
+	return cc.Clear()
+}
+
+// FlushCache 等待后台写入的缓存全部写入，返回写入中出现的第一个错误。
+// 只有开启 WithCacheWriteBehind 或使用开启了 WriteBehind 的 cache.TieredCache 时才需要调用
+func (c *Crawler) FlushCache() error {
+	if c.cache == nil {
+		return ErrNoCacheSet
+	}
+	if f, ok := c.cache.(cache.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// CacheStats 返回缓存的统计信息
+func (c *Crawler) CacheStats() (cache.Stats, error) {
+	if c.cache == nil {
+		return cache.Stats{}, ErrNoCacheSet
+	}
+	return c.cache.Stats(), nil
+}
+
+// CloseCache 写入后台待写入的缓存后关闭缓存，释放数据库或 redis 连接，关闭后不能再发出使用缓存的请求。
+// 共用同一个缓存的 crawler（如 Clone 得到的）只需要关闭一次
+func (c *Crawler) CloseCache() error {
+	if c.cache == nil {
+		return ErrNoCacheSet
+	}
+	return c.cache.Close()
 }
 
 /************************* 公共注册方法 ****************************/
@@ -560,6 +1029,14 @@ func (c *Crawler) AfterResponse(f HandleResponse) {
 	c.lock.Unlock()
 }
 
+// Emit 将数据交给 WithItemPipeline 设置的 ItemPipeline 处理和保存
+func (c *Crawler) Emit(item interface{}) error {
+	if c.itemPipeline == nil {
+		return ErrNoItemPipeline
+	}
+	return c.itemPipeline.Emit(item)
+}
+
 // ProxyPoolAmount returns the number of proxies in
 // the proxy pool
 func (c Crawler) ProxyPoolAmount() int {
@@ -622,8 +1099,7 @@ func (c *Crawler) removeInvalidProxy(proxyAddr string) error {
 
 	targetIndex := -1
 	for i, p := range c.proxyURLPool {
-		addr := strings.Split(p, "//")[1]
-		if addr == proxyAddr {
+		if p == proxyAddr {
 			targetIndex = i
 			break
 		}
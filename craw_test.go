@@ -3,7 +3,7 @@
  * @Email: thepoy@163.com
  * @File Name: craw_test.go
  * @Created: 2021-07-23 09:22:36
- * @Modified: 2021-11-05 14:20:50
+ * @Modified: 2026-10-19 00:16:52
  */
 
 package predator
@@ -11,19 +11,26 @@ package predator
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/thep0y/predator/cache"
+	"github.com/thep0y/predator/dao"
 	"github.com/thep0y/predator/html"
 	"github.com/thep0y/predator/log"
+	"github.com/thep0y/predator/pipeline"
 	"github.com/thep0y/predator/proxy"
 	"github.com/tidwall/gjson"
 	"github.com/valyala/fasthttp"
@@ -779,6 +786,610 @@ func TestCache(t *testing.T) {
 	})
 }
 
+func TestHTTPCache(t *testing.T) {
+	var requests, notModified int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("etag body"))
+	})
+	mux.HandleFunc("/updated", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			// 验证后的缓存可以使用一个小时
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.Header().Set("X-Version", "2")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Version", "1")
+		w.Write([]byte("updated body"))
+	})
+	mux.HandleFunc("/fresh", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("fresh body"))
+	})
+	mux.HandleFunc("/no-store", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte("no-store body"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	Convey("测试 HTTP 缓存语义", t, func() {
+		c := NewCrawler(
+			WithCache(&cache.MemoryCache{}, false),
+			WithHTTPCache(),
+		)
+
+		var fromCache []bool
+		var bodies []string
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+			bodies = append(bodies, r.String())
+			So(r.Request.Headers.Peek("If-None-Match"), ShouldBeNil)
+		})
+
+		Convey("过期后发送条件请求，304 时使用缓存", func() {
+			for i := 0; i < 3; i++ {
+				So(c.Get(ts.URL+"/etag"), ShouldBeNil)
+			}
+			So(atomic.LoadInt32(&requests), ShouldEqual, 3)
+			So(atomic.LoadInt32(&notModified), ShouldEqual, 2)
+			So(fromCache, ShouldResemble, []bool{false, true, true})
+			So(bodies, ShouldResemble, []string{"etag body", "etag body", "etag body"})
+		})
+
+		Convey("304 响应中的头部更新到缓存中", func() {
+			atomic.StoreInt32(&requests, 0)
+			var versions []string
+			c.AfterResponse(func(r *Response) {
+				versions = append(versions, string(r.Headers.Peek("X-Version")))
+			})
+
+			for i := 0; i < 3; i++ {
+				So(c.Get(ts.URL+"/updated"), ShouldBeNil)
+			}
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+			So(fromCache, ShouldResemble, []bool{false, true, true})
+			So(versions, ShouldResemble, []string{"1", "2", "2"})
+			So(bodies, ShouldResemble, []string{"updated body", "updated body", "updated body"})
+		})
+
+		Convey("新鲜的缓存直接使用", func() {
+			atomic.StoreInt32(&requests, 0)
+			for i := 0; i < 2; i++ {
+				So(c.Get(ts.URL+"/fresh"), ShouldBeNil)
+			}
+			So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+			So(fromCache, ShouldResemble, []bool{false, true})
+		})
+
+		Convey("no-store 的响应不缓存", func() {
+			atomic.StoreInt32(&requests, 0)
+			for i := 0; i < 2; i++ {
+				So(c.Get(ts.URL+"/no-store"), ShouldBeNil)
+			}
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+			So(fromCache, ShouldResemble, []bool{false, false})
+		})
+	})
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	Convey("测试新鲜时长", t, func() {
+		// http 日期精确到秒
+		now := time.Now().Truncate(time.Second)
+		date := fasthttp.AppendHTTPDate(nil, now)
+
+		header := func(kv ...string) *fasthttp.ResponseHeader {
+			h := &fasthttp.ResponseHeader{}
+			h.SetBytesV("Date", date)
+			for i := 0; i < len(kv); i += 2 {
+				h.Set(kv[i], kv[i+1])
+			}
+			return h
+		}
+
+		So(freshnessLifetime(header("Cache-Control", "max-age=60"), now), ShouldEqual, time.Minute)
+		So(freshnessLifetime(header("Cache-Control", "max-age=60", "Age", "10"), now), ShouldEqual, 50*time.Second)
+		So(freshnessLifetime(header("Cache-Control", "no-cache, max-age=60"), now), ShouldEqual, 0)
+		So(freshnessLifetime(header("Expires", "0"), now), ShouldEqual, 0)
+		So(freshnessLifetime(header(
+			"Expires", string(fasthttp.AppendHTTPDate(nil, now.Add(time.Hour))),
+		), now), ShouldEqual, time.Hour)
+		So(freshnessLifetime(header(
+			"Last-Modified", string(fasthttp.AppendHTTPDate(nil, now.Add(-10*time.Hour))),
+		), now), ShouldEqual, time.Hour)
+		So(freshnessLifetime(header(), now), ShouldEqual, 0)
+		So(storable(header("Cache-Control", "private, no-store")), ShouldBeFalse)
+	})
+}
+
+func TestCacheKeyFunc(t *testing.T) {
+	Convey("测试自定义缓存键", t, func() {
+		newRequest := func(URL string, headers map[string]string) *Request {
+			r := &Request{URL: URL, Method: fasthttp.MethodGet, Headers: &fasthttp.RequestHeader{}}
+			r.SetHeaders(headers)
+			return r
+		}
+
+		key := func(fn CacheKeyFunc, r *Request) string {
+			k, err := fn(r)
+			So(err, ShouldBeNil)
+			return k
+		}
+
+		Convey("规范化链接", func() {
+			fn := NewCacheKeyFunc(CacheKeyNormalizeURL())
+			So(
+				key(fn, newRequest("HTTP://Example.com:80?b=2&a=1#top", nil)),
+				ShouldEqual,
+				key(fn, newRequest("http://example.com/?a=1&b=2", nil)),
+			)
+		})
+
+		Convey("过滤查询参数", func() {
+			fn := NewCacheKeyFunc(CacheKeyDenyQuery("_", "utm_*"))
+			So(
+				key(fn, newRequest("http://example.com/?id=1&_=1634567890&utm_source=a", nil)),
+				ShouldEqual,
+				key(fn, newRequest("http://example.com/?id=1", nil)),
+			)
+			So(
+				key(fn, newRequest("http://example.com/?id=1", nil)),
+				ShouldNotEqual,
+				key(fn, newRequest("http://example.com/?id=2", nil)),
+			)
+
+			fn = NewCacheKeyFunc(CacheKeyAllowQuery("id"))
+			So(
+				key(fn, newRequest("http://example.com/?id=1&session=abc", nil)),
+				ShouldEqual,
+				key(fn, newRequest("http://example.com/?session=def&id=1", nil)),
+			)
+		})
+
+		Convey("包含请求头", func() {
+			fn := NewCacheKeyFunc(CacheKeyHeaders("accept-language"))
+			So(
+				key(fn, newRequest("http://example.com/", map[string]string{"Accept-Language": "zh-CN"})),
+				ShouldNotEqual,
+				key(fn, newRequest("http://example.com/", map[string]string{"Accept-Language": "en-US"})),
+			)
+			So(
+				key(fn, newRequest("http://example.com/", map[string]string{"Accept-Language": "zh-CN", "X-Trace": "1"})),
+				ShouldEqual,
+				key(fn, newRequest("http://example.com/", map[string]string{"Accept-Language": "zh-CN", "X-Trace": "2"})),
+			)
+		})
+
+		Convey("在爬虫中使用", func() {
+			ts := server()
+			defer ts.Close()
+
+			c := NewCrawler(
+				WithCache(&cache.MemoryCache{}, false),
+				WithCacheKeyFunc(NewCacheKeyFunc(CacheKeyDenyQuery("t"))),
+			)
+
+			var fromCache []bool
+			c.AfterResponse(func(r *Response) {
+				fromCache = append(fromCache, r.FromCache)
+			})
+
+			So(c.Get(ts.URL+"/?t=1"), ShouldBeNil)
+			So(c.Get(ts.URL+"/?t=2"), ShouldBeNil)
+			So(fromCache, ShouldResemble, []bool{false, true})
+		})
+	})
+}
+
+func TestCachePolicy(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/captcha", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("please solve the captcha"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	Convey("测试缓存策略", t, func() {
+		c := NewCrawler(
+			WithCache(&cache.MemoryCache{}, false),
+			WithCachePolicy(func(statusCode int, headers *fasthttp.ResponseHeader, body []byte) bool {
+				if statusCode == fasthttp.StatusOK {
+					return !bytes.Contains(body, []byte("captcha"))
+				}
+				return statusCode == fasthttp.StatusNotFound
+			}),
+		)
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		for i := 0; i < 2; i++ {
+			So(c.Get(ts.URL+"/missing"), ShouldBeNil)
+		}
+		So(fromCache, ShouldResemble, []bool{false, true})
+
+		fromCache = fromCache[:0]
+		for i := 0; i < 2; i++ {
+			So(c.Get(ts.URL+"/captcha"), ShouldBeNil)
+		}
+		So(fromCache, ShouldResemble, []bool{false, false})
+	})
+
+	Convey("测试默认缓存策略", t, func() {
+		So(DefaultCachePolicy(fasthttp.StatusOK, nil, nil), ShouldBeTrue)
+		So(DefaultCachePolicy(fasthttp.StatusNotFound, nil, nil), ShouldBeFalse)
+		So(CacheStatus(301, 404)(fasthttp.StatusMovedPermanently, nil, nil), ShouldBeTrue)
+	})
+}
+
+func TestOfflineMode(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试离线模式", t, func() {
+		mc := &cache.MemoryCache{}
+		c := NewCrawler(WithCache(mc, false), WithCacheTTL(time.Millisecond))
+		So(c.Get(ts.URL), ShouldBeNil)
+		time.Sleep(5 * time.Millisecond)
+
+		offline := NewCrawler(WithCache(mc, false), WithOfflineMode())
+
+		var stale []bool
+		offline.AfterResponse(func(r *Response) {
+			So(r.FromCache, ShouldBeTrue)
+			So(r.Body, ShouldResemble, serverIndexResponse)
+			stale = append(stale, r.Stale)
+		})
+
+		// 已过期的缓存也可以使用
+		So(offline.Get(ts.URL), ShouldBeNil)
+		So(stale, ShouldResemble, []bool{true})
+
+		err := offline.Get(ts.URL + "/not-cached")
+		So(errors.Is(err, ErrCacheMiss), ShouldBeTrue)
+		var missErr *CacheMissError
+		So(errors.As(err, &missErr), ShouldBeTrue)
+		So(missErr.URL, ShouldEqual, ts.URL+"/not-cached")
+	})
+}
+
+func TestStaleIfError(t *testing.T) {
+	var down int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("live body"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	Convey("测试 stale-if-error", t, func() {
+		c := NewCrawler(
+			WithCache(&cache.MemoryCache{}, false),
+			WithCacheTTL(time.Millisecond),
+			WithStaleIfError(),
+		)
+
+		var bodies []string
+		var stale []bool
+		c.AfterResponse(func(r *Response) {
+			bodies = append(bodies, r.String())
+			stale = append(stale, r.Stale)
+		})
+
+		So(c.Get(ts.URL), ShouldBeNil)
+		time.Sleep(5 * time.Millisecond)
+
+		// 服务器返回 503 时使用过期的缓存
+		atomic.StoreInt32(&down, 1)
+		So(c.Get(ts.URL), ShouldBeNil)
+
+		// 无法连接服务器时使用过期的缓存
+		ts.Close()
+		So(c.Get(ts.URL), ShouldBeNil)
+
+		So(bodies, ShouldResemble, []string{"live body", "live body", "live body"})
+		So(stale, ShouldResemble, []bool{false, true, true})
+
+		// 没有过期的缓存时返回错误
+		So(c.Get(ts.URL+"/other"), ShouldNotBeNil)
+	})
+}
+
+func TestInspectCache(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试查看缓存", t, func() {
+		mc := &cache.MemoryCache{}
+		c := NewCrawler(WithCache(mc, false))
+		So(c.Get(ts.URL), ShouldBeNil)
+		So(c.Get(ts.URL+"/html"), ShouldBeNil)
+		So(c.Post(ts.URL+"/post", map[string]string{"id": "1"}, nil), ShouldBeNil)
+
+		var urls []string
+		So(InspectCache(mc, func(e *CacheEntry) bool {
+			urls = append(urls, e.Request.Method+" "+e.Request.URL)
+			So(e.StatusCode, ShouldEqual, 200)
+			return true
+		}), ShouldBeNil)
+		So(len(urls), ShouldEqual, 3)
+
+		var entries []*CacheEntry
+		So(SearchCache(mc, ts.URL+"/post", func(e *CacheEntry) bool {
+			entries = append(entries, e)
+			return true
+		}), ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+		So(string(entries[0].Request.Body), ShouldEqual, "id=1")
+
+		resp, err := entries[0].Response()
+		So(err, ShouldBeNil)
+		So(resp.String(), ShouldEqual, "1")
+		So(resp.FromCache, ShouldBeTrue)
+	})
+}
+
+func TestCacheRecord(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试缓存记录", t, func() {
+		Convey("缓存的响应保留响应头和最终链接", func() {
+			mc := &cache.MemoryCache{}
+			c := NewCrawler(WithCache(mc, false))
+			c.BeforeRequest(func(r *Request) {
+				r.AllowRedirect(1)
+			})
+
+			var titles []string
+			c.ParseHTML("title", func(he *html.HTMLElement, r *Response) {
+				titles = append(titles, he.Text())
+			})
+
+			// 响应会被放回对象池，所以在回调中检查
+			var fromCache []bool
+			var fetchedAt []time.Time
+			c.AfterResponse(func(r *Response) {
+				fromCache = append(fromCache, r.FromCache)
+				fetchedAt = append(fetchedAt, r.FetchedAt)
+				So(r.ContentType(), ShouldEqual, "text/html")
+				So(string(r.Headers.Peek("Content-Length")), ShouldNotBeEmpty)
+				So(r.FinalURL, ShouldEqual, ts.URL+"/html")
+				So(r.FetchedAt.IsZero(), ShouldBeFalse)
+				So(r.Latency, ShouldBeGreaterThan, 0)
+			})
+
+			So(c.Get(ts.URL+"/redirect"), ShouldBeNil)
+			So(c.Get(ts.URL+"/redirect"), ShouldBeNil)
+
+			So(fromCache, ShouldResemble, []bool{false, true})
+			So(fetchedAt[1].Equal(fetchedAt[0]), ShouldBeTrue)
+			So(titles, ShouldResemble, []string{"Test Page", "Test Page"})
+
+			So(InspectCache(mc, func(e *CacheEntry) bool {
+				So(e.FinalURL, ShouldEqual, ts.URL+"/html")
+				So(e.Request.URL, ShouldEqual, ts.URL+"/redirect")
+				return true
+			}), ShouldBeNil)
+		})
+
+		Convey("相同的响应体只保存一次", func() {
+			dir, err := os.MkdirTemp("", "predator-")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			sc := &cache.SQLiteCache{URI: filepath.Join(dir, "cache.sqlite"), Dedup: true}
+			c := NewCrawler(WithCache(sc, false))
+
+			var fromCache []bool
+			c.AfterResponse(func(r *Response) {
+				fromCache = append(fromCache, r.FromCache)
+				So(r.ContentType(), ShouldEqual, "text/html")
+				So(r.String(), ShouldContainSubstring, "Test Page")
+			})
+
+			for i := 0; i < 2; i++ {
+				So(c.Get(ts.URL+"/html?id=1"), ShouldBeNil)
+				So(c.Get(ts.URL+"/html?id=2"), ShouldBeNil)
+			}
+			So(fromCache, ShouldResemble, []bool{false, false, true, true})
+
+			stats, err := sc.DedupStats()
+			So(err, ShouldBeNil)
+			So(stats.Entries, ShouldEqual, 2)
+			So(stats.Bodies, ShouldEqual, 1)
+			So(c.CloseCache(), ShouldBeNil)
+		})
+
+		Convey("读取旧版本的缓存记录", func() {
+			resp, req, err := unmarshalCacheRecord([]byte(`{"StatusCode":200,"Body":"aGVsbG8=","Request":{"Method":"GET","URL":"http://localhost/"}}`))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, 200)
+			So(resp.String(), ShouldEqual, "hello")
+			So(resp.FromCache, ShouldBeTrue)
+			So(req.URL, ShouldEqual, "http://localhost/")
+		})
+
+		Convey("读取当前版本的缓存记录", func() {
+			data := cache.JoinBody([]byte(`{"v":1,"status":200,"headers":[["Content-Type","text/plain"]],"request":{"method":"GET","url":"http://localhost/"}}`), []byte("hello"))
+			resp, req, err := unmarshalCacheRecord(data)
+			So(err, ShouldBeNil)
+			So(resp.String(), ShouldEqual, "hello")
+			So(resp.ContentType(), ShouldEqual, "text/plain")
+			So(req.URL, ShouldEqual, "http://localhost/")
+		})
+
+		Convey("不支持更新版本的缓存记录", func() {
+			_, _, err := unmarshalCacheRecord([]byte(`{"v":99,"status":200}`))
+			So(err, ShouldEqual, ErrUnsupportedCacheRecord)
+		})
+	})
+}
+
+// flakyStore 模拟连接断开的缓存服务
+type flakyStore struct {
+	cache.Store
+	down int32
+}
+
+var errCacheDown = errors.New("cache is down")
+
+func (fs *flakyStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if atomic.LoadInt32(&fs.down) == 1 {
+		return nil, false, errCacheDown
+	}
+	return fs.Store.Get(ctx, key)
+}
+
+func (fs *flakyStore) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	if atomic.LoadInt32(&fs.down) == 1 {
+		return errCacheDown
+	}
+	return fs.Store.Set(ctx, key, val, ttl)
+}
+
+func TestCacheStore(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试缓存出错时降级为未命中", t, func() {
+		mc := &cache.MemoryCache{}
+		So(mc.Init(), ShouldBeNil)
+		store := &flakyStore{Store: cache.NewStore(mc), down: 1}
+
+		c := NewCrawler(WithCacheStore(store), WithCacheTimeout(time.Second))
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		So(c.Get(ts.URL+"/html"), ShouldBeNil)
+		So(c.Get(ts.URL+"/html"), ShouldBeNil)
+
+		atomic.StoreInt32(&store.down, 0)
+		So(c.Get(ts.URL+"/html"), ShouldBeNil)
+		So(c.Get(ts.URL+"/html"), ShouldBeNil)
+
+		So(fromCache, ShouldResemble, []bool{false, false, false, true})
+
+		stats, err := c.CacheStats()
+		So(err, ShouldBeNil)
+		So(stats.Hits, ShouldEqual, 1)
+		So(stats.Entries, ShouldEqual, 1)
+
+		// 只实现了 Store 的缓存不能清除
+		So(c.ClearCache(), ShouldEqual, cache.ErrNotSupported)
+		So(c.CloseCache(), ShouldBeNil)
+	})
+}
+
+func TestCacheWriteBehind(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试后台批量写入缓存", t, func() {
+		mc := &cache.MemoryCache{}
+		c := NewCrawler(
+			WithCache(mc, false),
+			WithCacheWriteBehind(5, time.Hour),
+			WithConcurrency(10),
+		)
+
+		for i := 0; i < 20; i++ {
+			So(c.Get(fmt.Sprintf("%s/html?id=%d", ts.URL, i)), ShouldBeNil)
+		}
+		c.Wait()
+
+		So(c.FlushCache(), ShouldBeNil)
+		So(mc.Stats().Entries, ShouldEqual, 20)
+
+		c = NewCrawler(
+			WithCache(mc, false),
+			WithCacheWriteBehind(5, time.Hour),
+		)
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+		So(c.Get(ts.URL+"/html?id=0"), ShouldBeNil)
+		So(c.Get(ts.URL+"/html?id=20"), ShouldBeNil)
+		// 还未写入的缓存也可以命中
+		So(c.Get(ts.URL+"/html?id=20"), ShouldBeNil)
+		So(fromCache, ShouldResemble, []bool{true, false, true})
+		So(mc.Stats().Entries, ShouldEqual, 20)
+
+		So(c.CloseCache(), ShouldBeNil)
+		So(mc.Stats().Entries, ShouldEqual, 21)
+	})
+}
+
+func TestItemPipeline(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试保存数据", t, func() {
+		dir, err := os.MkdirTemp("", "predator-")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		db := &dao.Sqlite{URI: filepath.Join(dir, "items.sqlite")}
+		So(db.Init(), ShouldBeNil)
+
+		p, err := pipeline.NewItemPipeline(db, pipeline.WithTable("pages"), pipeline.WithUniqueKey("url"))
+		So(err, ShouldBeNil)
+
+		c := NewCrawler(WithItemPipeline(p), WithConcurrency(5))
+		c.ParseHTML("title", func(he *html.HTMLElement, r *Response) {
+			err := r.Emit(map[string]interface{}{
+				"url":   r.Request.URL,
+				"title": he.Text(),
+			})
+			if err != nil {
+				c.Error(err)
+			}
+		})
+
+		for i := 0; i < 10; i++ {
+			So(c.Get(fmt.Sprintf("%s/html?id=%d", ts.URL, i%5)), ShouldBeNil)
+		}
+		c.Wait()
+		So(p.Close(), ShouldBeNil)
+
+		var count int64
+		So(db.DB.Table("pages").Where("title = ?", "Test Page").Count(&count).Error, ShouldBeNil)
+		So(count, ShouldEqual, 5)
+
+		So(NewCrawler().Emit(map[string]string{}), ShouldEqual, ErrNoItemPipeline)
+	})
+}
+
 func TestLog(t *testing.T) {
 	ts := server()
 	defer ts.Close()
@@ -832,6 +1443,27 @@ func TestLog(t *testing.T) {
 
 		c.Get(ts.URL)
 	})
+
+	Convey("SQL 缓存的日志输出到爬虫的 logger", t, func() {
+		dir, err := os.MkdirTemp("", "predator-log-")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		var buf bytes.Buffer
+		l := new(LogOp)
+		l.SetLevel(log.DEBUG)
+		l.Out = &buf
+
+		sc := &cache.SQLiteCache{URI: filepath.Join(dir, "cache.sqlite")}
+		c := NewCrawler(
+			WithLogger(l),
+			WithCache(sc, false),
+		)
+		defer c.CloseCache()
+
+		c.Get(ts.URL)
+		So(buf.String(), ShouldContainSubstring, `"message":"gorm"`)
+	})
 }
 
 func TestRedirect(t *testing.T) {
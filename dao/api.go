@@ -3,23 +3,33 @@
  * @Email: thepoy@163.com
  * @File Name: api.go
  * @Created: 2021-07-24 22:20:09
- * @Modified: 2021-07-31 11:24:02
+ * @Modified: 2026-10-18 23:29:01
  */
 
 package dao
 
-import "errors"
+import (
+	"errors"
 
-// 如查需要使用事务，需要用户自己用事务实现 Database 接口
+	"gorm.io/gorm"
+)
 
 type Database interface {
 	Init() error
+	// 在事务中执行 fn，fn 返回错误或 panic 时回滚，否则提交。
+	// fn 中需要用 tx 操作数据库，在 tx 中再次调用 Transaction 时用保存点实现嵌套事务
+	Transaction(fn func(tx Database) error) error
 	// 数据库自动迁移，用于创建新表
 	AutoMigrate(models ...interface{}) error
 	// 插入一条记录
 	Insert(model interface{}) error
-	// 插入多条记录
-	InsertMany(models []interface{}) error
+	// 分批插入多条记录，models 可以是结构体切片或元素类型相同的 []interface{}，
+	// batchSize <= 0 时使用 DefaultBatchSize
+	InsertMany(models interface{}, batchSize int) error
+	// 插入一条或多条记录，与 conflictColumns 冲突时更新 updateColumns。
+	// conflictColumns 为空时使用主键，updateColumns 为空时更新除主键和创建时间外的所有列。
+	// MySQL 会忽略 conflictColumns，任何唯一索引冲突时都会更新
+	Upsert(model interface{}, conflictColumns, updateColumns []string) error
 	// 根据主键查询一条记录
 	Select(model interface{}, pk interface{}) error
 	// 根据主键查询多条记录
@@ -42,6 +52,14 @@ type Database interface {
 	DeleteWithWhere(model interface{}, where string, args ...interface{}) error
 	// 清空表
 	Truncate(model interface{}) error
+	// 关闭连接池，关闭后不能再使用
+	Close() error
+}
+
+// Gormer 可以取得底层 gorm 连接的 Database，用于 Database 接口之外的操作，
+// 如按表名写入。dao 中的实现都满足此接口
+type Gormer interface {
+	Gorm() *gorm.DB
 }
 
 var (
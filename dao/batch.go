@@ -0,0 +1,87 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: batch.go
+ * @Created: 2026-10-18 23:23:51
+ * @Modified: 2026-10-18 23:23:51
+ */
+
+package dao
+
+import (
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// DefaultBatchSize 批量写入时每批的默认条数
+const DefaultBatchSize = 100
+
+// schemas 缓存 Upsert 中解析的表结构
+var schemas sync.Map
+
+// typed 将元素类型相同的 []interface{} 转换为具体类型的切片，
+// gorm 不能从 []interface{} 中取得表结构
+func typed(models interface{}) interface{} {
+	items, ok := models.([]interface{})
+	if !ok || len(items) == 0 {
+		return models
+	}
+
+	t := reflect.TypeOf(items[0])
+	slice := reflect.MakeSlice(reflect.SliceOf(t), 0, len(items))
+	for _, item := range items {
+		v := reflect.ValueOf(item)
+		if v.Type() != t {
+			// 类型不同时交给 gorm 返回错误
+			return models
+		}
+		slice = reflect.Append(slice, v)
+	}
+	return slice.Interface()
+}
+
+func isEmpty(models interface{}) bool {
+	v := reflect.ValueOf(models)
+	return v.Kind() == reflect.Slice && v.Len() == 0
+}
+
+func insertMany(db *gorm.DB, models interface{}, batchSize int) error {
+	if isEmpty(models) {
+		return nil
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return db.CreateInBatches(typed(models), batchSize).Error
+}
+
+func upsert(db *gorm.DB, models interface{}, conflictColumns, updateColumns []string) error {
+	if isEmpty(models) {
+		return nil
+	}
+	models = typed(models)
+
+	onConflict := clause.OnConflict{}
+	if len(conflictColumns) == 0 {
+		s, err := schema.Parse(models, &schemas, db.NamingStrategy)
+		if err != nil {
+			return err
+		}
+		conflictColumns = s.PrimaryFieldDBNames
+	}
+	for _, col := range conflictColumns {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: col})
+	}
+
+	if len(updateColumns) == 0 {
+		onConflict.UpdateAll = true
+	} else {
+		onConflict.DoUpdates = clause.AssignmentColumns(updateColumns)
+	}
+
+	return db.Clauses(onConflict).CreateInBatches(models, DefaultBatchSize).Error
+}
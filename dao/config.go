@@ -0,0 +1,80 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: config.go
+ * @Created: 2026-10-18 23:26:31
+ * @Modified: 2026-10-18 23:26:31
+ */
+
+package dao
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Config 是各数据库通用的连接池和日志设置，零值时使用默认值
+type Config struct {
+	// 最多打开的连接数，0 表示不限制
+	MaxOpenConns int
+	// 最多保留的空闲连接数，0 时使用 database/sql 的默认值 2
+	MaxIdleConns int
+	// 连接可以复用的最长时间，0 表示不限制
+	ConnMaxLifetime time.Duration
+	// 连接可以空闲的最长时间，0 表示不限制
+	ConnMaxIdleTime time.Duration
+	// 设置后将 gorm 的日志输出到此 logger，默认不输出
+	Logger *zerolog.Logger
+	// gorm 的日志等级，0 时按 Logger 的日志等级确定，见 NewLogger
+	LogLevel logger.LogLevel
+	// 执行时间超过此值的 SQL 以警告输出，默认为 200 毫秒
+	SlowThreshold time.Duration
+}
+
+func (c *Config) open(dialector gorm.Dialector) (*gorm.DB, error) {
+	l := logger.Default.LogMode(logger.Silent)
+	if c.Logger != nil {
+		l = NewLogger(c.Logger, c.LogLevel, c.SlowThreshold)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		PrepareStmt: true,
+		Logger:      l,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	}
+	return db, nil
+}
+
+// closeDB 关闭连接池，未初始化时什么也不做
+func closeDB(db *gorm.DB) error {
+	if db == nil {
+		return nil
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
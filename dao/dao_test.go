@@ -0,0 +1,335 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: dao_test.go
+ * @Created: 2026-10-18 23:24:21
+ * @Modified: 2026-10-19 00:01:43
+ */
+
+package dao
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+type Book struct {
+	ID    uint
+	URL   string `gorm:"size:255;uniqueIndex"`
+	Title string
+	Price float64
+}
+
+func TestSqlite(t *testing.T) {
+	Convey("测试 Sqlite", t, func() {
+		dir, err := os.MkdirTemp("", "predator-dao-")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		db := &Sqlite{URI: filepath.Join(dir, "dao.sqlite")}
+		So(db.Init(), ShouldBeNil)
+		defer db.Close()
+		So(db.AutoMigrate(&Book{}), ShouldBeNil)
+
+		count := func() int64 {
+			var n int64
+			So(db.DB.Model(&Book{}).Count(&n).Error, ShouldBeNil)
+			return n
+		}
+
+		Convey("分批插入", func() {
+			books := make([]Book, 25)
+			for i := range books {
+				books[i].URL = fmt.Sprintf("/%d", i)
+			}
+			So(db.InsertMany(books, 10), ShouldBeNil)
+			So(count(), ShouldEqual, 25)
+
+			// 元素类型相同的 []interface{}
+			So(db.InsertMany([]interface{}{&Book{URL: "/a"}, &Book{URL: "/b"}}, 0), ShouldBeNil)
+			So(count(), ShouldEqual, 27)
+
+			So(db.InsertMany([]interface{}{}, 0), ShouldBeNil)
+		})
+
+		Convey("插入或更新", func() {
+			So(db.Insert(&Book{URL: "/1", Title: "old", Price: 1}), ShouldBeNil)
+
+			books := []Book{{URL: "/1", Title: "new", Price: 2}, {URL: "/2", Title: "other"}}
+			So(db.Upsert(books, []string{"url"}, []string{"title"}), ShouldBeNil)
+			So(count(), ShouldEqual, 2)
+
+			var b Book
+			So(db.SelectOneWithWhere(&b, "url = ?", "/1"), ShouldBeNil)
+			So(b.Title, ShouldEqual, "new")
+			// 只更新 updateColumns
+			So(b.Price, ShouldEqual, 1)
+
+			// 没有指定列时按主键冲突，更新所有列
+			So(db.Upsert(&Book{ID: b.ID, URL: "/1", Title: "newer", Price: 3}, nil, nil), ShouldBeNil)
+			So(db.Select(&b, b.ID), ShouldBeNil)
+			So(b.Title, ShouldEqual, "newer")
+			So(b.Price, ShouldEqual, 3)
+		})
+
+		Convey("事务", func() {
+			errRollback := errors.New("rollback")
+			err := db.Transaction(func(tx Database) error {
+				So(tx.Insert(&Book{URL: "/1"}), ShouldBeNil)
+				return errRollback
+			})
+			So(err, ShouldEqual, errRollback)
+			So(count(), ShouldEqual, 0)
+
+			err = db.Transaction(func(tx Database) error {
+				err := tx.InsertMany([]Book{{URL: "/1"}, {URL: "/2"}}, 0)
+				if err != nil {
+					return err
+				}
+
+				// 嵌套事务回滚时只回滚到保存点
+				err = tx.Transaction(func(tx Database) error {
+					So(tx.Insert(&Book{URL: "/3"}), ShouldBeNil)
+					return errRollback
+				})
+				So(err, ShouldEqual, errRollback)
+
+				return tx.Upsert(&Book{URL: "/2", Title: "2"}, []string{"url"}, nil)
+			})
+			So(err, ShouldBeNil)
+			So(count(), ShouldEqual, 2)
+		})
+
+		Convey("并发写入", func() {
+			var wg sync.WaitGroup
+			errs := make(chan error, 20)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- db.Transaction(func(tx Database) error {
+						var n int64
+						// 事务中先读后写
+						if err := tx.(Gormer).Gorm().Model(&Book{}).Count(&n).Error; err != nil {
+							return err
+						}
+						return tx.Insert(&Book{URL: fmt.Sprintf("/%d", i)})
+					})
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				So(err, ShouldBeNil)
+			}
+			So(count(), ShouldEqual, 20)
+
+			var mode string
+			So(db.DB.Raw("PRAGMA journal_mode").Scan(&mode).Error, ShouldBeNil)
+			So(strings.ToLower(mode), ShouldEqual, "wal")
+		})
+	})
+}
+
+func TestSqliteDSN(t *testing.T) {
+	Convey("测试 sqlite 连接参数", t, func() {
+		s := &Sqlite{URI: "a.sqlite"}
+		So(s.dsn(), ShouldEqual, "a.sqlite?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+
+		// URI 中已有的参数优先
+		s = &Sqlite{URI: "file:a.sqlite?_journal=DELETE", BusyTimeout: time.Second, Synchronous: "NORMAL"}
+		So(s.dsn(), ShouldEqual, "file:a.sqlite?_journal=DELETE&_busy_timeout=1000&_synchronous=NORMAL&_txlock=immediate")
+	})
+}
+
+func TestConfig(t *testing.T) {
+	Convey("测试连接设置", t, func() {
+		var buf bytes.Buffer
+		l := zerolog.New(&buf).Level(zerolog.DebugLevel)
+
+		db := &Sqlite{
+			URI: "file::memory:",
+			Config: Config{
+				MaxOpenConns: 1,
+				Logger:       &l,
+			},
+		}
+		So(db.Init(), ShouldBeNil)
+
+		sqlDB, err := db.DB.DB()
+		So(err, ShouldBeNil)
+		So(sqlDB.Stats().MaxOpenConnections, ShouldEqual, 1)
+
+		So(db.AutoMigrate(&Book{}), ShouldBeNil)
+		So(db.Insert(&Book{URL: "/1"}), ShouldBeNil)
+		So(buf.String(), ShouldContainSubstring, `"level":"debug"`)
+		So(buf.String(), ShouldContainSubstring, "INSERT INTO")
+
+		// 查询不到记录不是错误
+		buf.Reset()
+		So(db.Select(&Book{}, 100), ShouldNotBeNil)
+		So(buf.String(), ShouldNotContainSubstring, `"level":"error"`)
+
+		So(db.Insert(&Book{URL: "/1"}), ShouldNotBeNil)
+		So(buf.String(), ShouldContainSubstring, `"level":"error"`)
+
+		// Info 等级只输出慢查询和错误
+		buf.Reset()
+		l = l.Level(zerolog.InfoLevel)
+		db.DB.Logger = NewLogger(&l, 0, 0)
+		So(db.Insert(&Book{URL: "/2"}), ShouldBeNil)
+		So(buf.Len(), ShouldEqual, 0)
+
+		So(db.Close(), ShouldBeNil)
+		So(db.Insert(&Book{URL: "/3"}), ShouldNotBeNil)
+		So((&Sqlite{}).Close(), ShouldBeNil)
+	})
+}
+
+type itemV1 struct {
+	ID    uint
+	Title string
+}
+
+func (itemV1) TableName() string {
+	return "items"
+}
+
+type itemV2 struct {
+	ID   uint
+	Name string
+	Slug string
+}
+
+func (itemV2) TableName() string {
+	return "items"
+}
+
+func TestMigrator(t *testing.T) {
+	Convey("测试版本化迁移", t, func() {
+		// 内存数据库的每个连接都是独立的数据库
+		db := &Sqlite{URI: "file::memory:", Config: Config{MaxOpenConns: 1}}
+		So(db.Init(), ShouldBeNil)
+		defer db.Close()
+
+		migrations := []Migration{
+			{
+				Version: 2,
+				Name:    "rename title and backfill slug",
+				Up: func(tx *gorm.DB) error {
+					err := tx.Migrator().RenameColumn(&itemV1{}, "title", "name")
+					if err != nil {
+						return err
+					}
+					err = tx.Migrator().AddColumn(&itemV2{}, "Slug")
+					if err != nil {
+						return err
+					}
+					return tx.Exec("UPDATE items SET slug = lower(name)").Error
+				},
+				Down: func(tx *gorm.DB) error {
+					err := tx.Migrator().DropColumn(&itemV2{}, "Slug")
+					if err != nil {
+						return err
+					}
+					return tx.Migrator().RenameColumn(&itemV2{}, "name", "title")
+				},
+			},
+			{
+				Version: 1,
+				Name:    "create items",
+				Up: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&itemV1{})
+				},
+				Down: func(tx *gorm.DB) error {
+					return tx.Migrator().DropTable(&itemV1{})
+				},
+			},
+		}
+
+		m, err := NewMigrator(db, "", migrations...)
+		So(err, ShouldBeNil)
+		So(db.DB.Migrator().HasTable(DefaultMigrationTable), ShouldBeTrue)
+
+		// 按版本号从小到大执行
+		So(m.To(1), ShouldBeNil)
+		So(db.Insert(&itemV1{Title: "Go"}), ShouldBeNil)
+		So(m.Up(), ShouldBeNil)
+
+		applied, err := m.Applied()
+		So(err, ShouldBeNil)
+		So(applied, ShouldResemble, []uint{1, 2})
+
+		var item itemV2
+		So(db.DB.First(&item).Error, ShouldBeNil)
+		So(item.Name, ShouldEqual, "Go")
+		So(item.Slug, ShouldEqual, "go")
+
+		// 已执行的迁移不会重复执行
+		So(m.Up(), ShouldBeNil)
+
+		So(m.Down(), ShouldBeNil)
+		version, err := m.Version()
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, 1)
+		So(db.DB.Migrator().HasColumn(&itemV1{}, "title"), ShouldBeTrue)
+		So(db.DB.Migrator().HasColumn(&itemV2{}, "slug"), ShouldBeFalse)
+
+		So(m.To(0), ShouldBeNil)
+		So(db.DB.Migrator().HasTable(&itemV1{}), ShouldBeFalse)
+		So(m.Down(), ShouldBeNil)
+
+		Convey("出错时回滚事务", func() {
+			errFailed := errors.New("failed")
+			m, err := NewMigrator(db, "", migrations[1], Migration{
+				Version: 2,
+				Up: func(tx *gorm.DB) error {
+					So(tx.Migrator().AddColumn(&itemV2{}, "Slug"), ShouldBeNil)
+					return errFailed
+				},
+			})
+			So(err, ShouldBeNil)
+
+			err = m.Up()
+			So(errors.Is(err, errFailed), ShouldBeTrue)
+			So(db.DB.Migrator().HasColumn(&itemV2{}, "slug"), ShouldBeFalse)
+
+			version, err := m.Version()
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 1)
+		})
+
+		Convey("不能回滚的迁移", func() {
+			m, err := NewMigrator(db, "item_migrations", migrations[1], Migration{Version: 3})
+			So(err, ShouldBeNil)
+			So(m.Up(), ShouldBeNil)
+			So(errors.Is(m.Down(), ErrIrreversibleMigration), ShouldBeTrue)
+
+			// 数据库中有更新的迁移时 Up 不受影响，回滚时返回错误
+			old, err := NewMigrator(db, "item_migrations", migrations[1])
+			So(err, ShouldBeNil)
+			So(old.Up(), ShouldBeNil)
+			So(errors.Is(old.Down(), ErrUnknownMigration), ShouldBeTrue)
+		})
+
+		Convey("版本号重复", func() {
+			_, err := NewMigrator(db, "", migrations[0], migrations[0])
+			So(errors.Is(err, ErrDuplicateMigration), ShouldBeTrue)
+
+			_, err = NewMigrator(&Sqlite{}, "")
+			So(err, ShouldEqual, ErrNotInitialized)
+		})
+	})
+}
@@ -0,0 +1,109 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: logger.go
+ * @Created: 2026-10-18 23:26:31
+ * @Modified: 2026-10-18 23:26:31
+ */
+
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DefaultSlowThreshold 默认的慢查询阈值
+const DefaultSlowThreshold = 200 * time.Millisecond
+
+type gormLogger struct {
+	log           *zerolog.Logger
+	level         logger.LogLevel
+	slowThreshold time.Duration
+}
+
+// NewLogger 返回将 gorm 的日志输出到 l 的 logger.Interface。
+//
+// 执行的 SQL 以 Debug 输出，慢查询以 Warn 输出，出错的 SQL 以 Error 输出，
+// 查询不到记录不视为错误。level 为 0 时按 l 的日志等级确定：
+// Debug 及以下输出所有 SQL，Info 和 Warn 输出慢查询和错误，Error 只输出错误
+func NewLogger(l *zerolog.Logger, level logger.LogLevel, slowThreshold time.Duration) logger.Interface {
+	if level == 0 {
+		switch lvl := l.GetLevel(); {
+		case lvl <= zerolog.DebugLevel:
+			level = logger.Info
+		case lvl <= zerolog.WarnLevel:
+			level = logger.Warn
+		case lvl == zerolog.ErrorLevel:
+			level = logger.Error
+		default:
+			level = logger.Silent
+		}
+	}
+
+	if slowThreshold <= 0 {
+		slowThreshold = DefaultSlowThreshold
+	}
+
+	return &gormLogger{
+		log:           l,
+		level:         level,
+		slowThreshold: slowThreshold,
+	}
+}
+
+func (gl *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	l := *gl
+	l.level = level
+	return &l
+}
+
+func (gl *gormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if gl.level >= logger.Info {
+		gl.log.Info().Msg(fmt.Sprintf(msg, data...))
+	}
+}
+
+func (gl *gormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if gl.level >= logger.Warn {
+		gl.log.Warn().Msg(fmt.Sprintf(msg, data...))
+	}
+}
+
+func (gl *gormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if gl.level >= logger.Error {
+		gl.log.Error().Msg(fmt.Sprintf(msg, data...))
+	}
+}
+
+func (gl *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if gl.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	var event *zerolog.Event
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && gl.level >= logger.Error:
+		event = gl.log.Error().Err(err)
+	case elapsed > gl.slowThreshold && gl.level >= logger.Warn:
+		event = gl.log.Warn().Dur("threshold", gl.slowThreshold)
+	case gl.level >= logger.Info:
+		event = gl.log.Debug()
+	default:
+		return
+	}
+
+	sql, rows := fc()
+	event.
+		Str("sql", sql).
+		Int64("rows", rows).
+		Dur("elapsed", elapsed).
+		Msg("gorm")
+}
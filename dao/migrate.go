@@ -0,0 +1,228 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: migrate.go
+ * @Created: 2026-10-18 23:30:21
+ * @Modified: 2026-10-19 00:01:43
+ */
+
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultMigrationTable 默认记录已执行迁移的表
+const DefaultMigrationTable = "schema_migrations"
+
+var (
+	// ErrNotInitialized 数据库没有实现 Gormer 或还未初始化
+	ErrNotInitialized = errors.New("database is not initialized")
+	// ErrDuplicateMigration 有两个迁移的版本号相同
+	ErrDuplicateMigration = errors.New("duplicate migration version")
+	// ErrIrreversibleMigration 回滚的迁移没有 Down
+	ErrIrreversibleMigration = errors.New("migration is irreversible")
+	// ErrUnknownMigration 数据库中已执行的迁移不在迁移列表中，通常是数据库被更新版本的程序迁移过
+	ErrUnknownMigration = errors.New("unknown migration")
+)
+
+// Migration 是一次带版本号的表结构变更，可以重命名、回填或删除列等 AutoMigrate 做不到的操作。
+//
+// 迁移在事务中执行，tx.Migrator() 提供了与数据库无关的表结构操作。
+// MySQL 的 DDL 语句会隐式提交事务，迁移中途出错时不能完全回滚。
+//
+// Migrator 不会给迁移加锁，多个程序同时迁移同一个数据库时可能重复执行迁移，
+// 应该只在一个程序中执行迁移
+type Migration struct {
+	// 版本号，按从小到大的顺序执行，发布后不能再修改
+	Version uint
+	// 说明，会记录在迁移表中
+	Name string
+	Up   func(tx *gorm.DB) error
+	// 回滚 Up 的修改，为 nil 时不能回滚
+	Down func(tx *gorm.DB) error
+}
+
+// migrationRecord 是迁移表中的一条记录
+type migrationRecord struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// Migrator 按版本号执行迁移，并将已执行的迁移记录在迁移表中
+type Migrator struct {
+	db         *gorm.DB
+	table      string
+	migrations []Migration
+}
+
+// NewMigrator 创建 Migrator，table 为记录迁移的表，为空时使用 DefaultMigrationTable。
+// 同一个数据库中不同用途的表应该使用不同的迁移表，各自的版本号互不影响
+func NewMigrator(db Database, table string, migrations ...Migration) (*Migrator, error) {
+	g, ok := db.(Gormer)
+	if !ok || g.Gorm() == nil {
+		return nil, ErrNotInitialized
+	}
+
+	if table == "" {
+		table = DefaultMigrationTable
+	}
+
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("%w: %d", ErrDuplicateMigration, sorted[i].Version)
+		}
+	}
+
+	m := &Migrator{
+		db:         g.Gorm(),
+		table:      table,
+		migrations: sorted,
+	}
+	return m, m.records().AutoMigrate(&migrationRecord{})
+}
+
+func (m *Migrator) records() *gorm.DB {
+	return m.db.Table(m.table)
+}
+
+// Applied 返回已执行的迁移的版本号，从小到大排列
+func (m *Migrator) Applied() ([]uint, error) {
+	var versions []uint
+	err := m.records().Order("version").Pluck("version", &versions).Error
+	return versions, err
+}
+
+// Version 返回已执行的最大版本号，没有执行过迁移时返回 0
+func (m *Migrator) Version() (uint, error) {
+	versions, err := m.Applied()
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+// Up 按顺序执行所有未执行的迁移。数据库中有不在列表中的更新的迁移时不会回滚
+func (m *Migrator) Up() error {
+	for _, mg := range m.migrations {
+		err := m.up(mg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down 回滚最近执行的一次迁移，没有执行过迁移时什么也不做
+func (m *Migrator) Down() error {
+	version, err := m.Version()
+	if err != nil || version == 0 {
+		return err
+	}
+
+	mg, ok := m.find(version)
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownMigration, version)
+	}
+	return m.down(mg)
+}
+
+// To 迁移到指定的版本：执行不大于 version 的未执行的迁移，
+// 再从大到小回滚大于 version 的已执行的迁移。version 为 0 时回滚全部迁移
+func (m *Migrator) To(version uint) error {
+	for _, mg := range m.migrations {
+		if mg.Version > version {
+			break
+		}
+		err := m.up(mg)
+		if err != nil {
+			return err
+		}
+	}
+
+	applied, err := m.Applied()
+	if err != nil {
+		return err
+	}
+	for i := len(applied) - 1; i >= 0 && applied[i] > version; i-- {
+		mg, ok := m.find(applied[i])
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrUnknownMigration, applied[i])
+		}
+		err = m.down(mg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) find(version uint) (Migration, bool) {
+	i := sort.Search(len(m.migrations), func(i int) bool {
+		return m.migrations[i].Version >= version
+	})
+	if i < len(m.migrations) && m.migrations[i].Version == version {
+		return m.migrations[i], true
+	}
+	return Migration{}, false
+}
+
+// applied 在事务中检查迁移是否已执行
+func (m *Migrator) applied(tx *gorm.DB, version uint) (bool, error) {
+	var count int64
+	err := tx.Table(m.table).Where("version = ?", version).Count(&count).Error
+	return count > 0, err
+}
+
+func (m *Migrator) up(mg Migration) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		done, err := m.applied(tx, mg.Version)
+		if err != nil || done {
+			return err
+		}
+
+		if mg.Up != nil {
+			err = mg.Up(tx)
+			if err != nil {
+				return fmt.Errorf("migrate up %d %s: %w", mg.Version, mg.Name, err)
+			}
+		}
+
+		return tx.Table(m.table).Create(&migrationRecord{
+			Version:   mg.Version,
+			Name:      mg.Name,
+			AppliedAt: time.Now(),
+		}).Error
+	})
+}
+
+func (m *Migrator) down(mg Migration) error {
+	if mg.Down == nil {
+		return fmt.Errorf("%w: %d %s", ErrIrreversibleMigration, mg.Version, mg.Name)
+	}
+
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		done, err := m.applied(tx, mg.Version)
+		if err != nil || !done {
+			return err
+		}
+
+		err = mg.Down(tx)
+		if err != nil {
+			return fmt.Errorf("migrate down %d %s: %w", mg.Version, mg.Name, err)
+		}
+
+		return tx.Table(m.table).Where("version = ?", mg.Version).Delete(&migrationRecord{}).Error
+	})
+}
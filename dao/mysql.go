@@ -3,7 +3,7 @@
  * @Email: thepoy@163.com
  * @File Name: mysql.go
  * @Created: 2021-07-24 22:24:29
- * @Modified: 2021-07-30 22:04:45
+ * @Modified: 2026-10-18 23:29:01
  */
 
 package dao
@@ -13,12 +13,12 @@ import (
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
 type MySQL struct {
     Host,Port,Database,Username,Password string
     DB  *gorm.DB
+    Config
 }
 
 func (m *MySQL) uri() string {
@@ -42,10 +42,7 @@ func (m *MySQL) Init() error {
     if uri == "" {
         return URIRequiredError
     }
-    db, err := gorm.Open(mysql.Open(uri), &gorm.Config{
-        PrepareStmt: true,
-        Logger:      logger.Default.LogMode(logger.Silent),
-    })
+    db, err := m.Config.open(mysql.Open(uri))
     if err != nil {
         return err
     }
@@ -53,6 +50,25 @@ func (m *MySQL) Init() error {
     return nil
 }
 
+// Close 关闭连接池
+func (m *MySQL) Close() error {
+    return closeDB(m.DB)
+}
+
+// Gorm 返回底层的 gorm 连接
+func (m *MySQL) Gorm() *gorm.DB {
+    return m.DB
+}
+
+// Transaction 在事务中执行 fn，tx 是使用同一事务的 MySQL
+func (m *MySQL) Transaction(fn func(tx Database) error) error {
+    return m.DB.Transaction(func(db *gorm.DB) error {
+        tx := *m
+        tx.DB = db
+        return fn(&tx)
+    })
+}
+
 func (m *MySQL) AutoMigrate(models ...interface{}) error {
     return m.DB.AutoMigrate(models...)
 }
@@ -61,8 +77,14 @@ func (m *MySQL) Insert(model interface{}) error {
     return m.DB.Create(model).Error
 }
 
-func (m *MySQL) InsertMany(models []interface{}) error {
-    return m.DB.Create(models).Error
+// InsertMany 分批插入多条记录，batchSize <= 0 时使用 DefaultBatchSize
+func (m *MySQL) InsertMany(models interface{}, batchSize int) error {
+    return insertMany(m.DB, models, batchSize)
+}
+
+// Upsert 插入记录，冲突时更新 updateColumns
+func (m *MySQL) Upsert(model interface{}, conflictColumns, updateColumns []string) error {
+    return upsert(m.DB, model, conflictColumns, updateColumns)
 }
 
 /*****  查询方法太多了，有必要全列出来吗？  *****/
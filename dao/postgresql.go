@@ -3,7 +3,7 @@
  * @Email: thepoy@163.com
  * @File Name: postgresql.go
  * @Created: 2021-07-24 22:25:04
- * @Modified: 2021-07-30 22:45:20
+ * @Modified: 2026-10-18 23:29:01
  */
 
 package dao
@@ -13,12 +13,12 @@ import (
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
 type PostgreSQL struct {
 	Host, Port, Database, Username, Password, SSLMode, TimeZone string
 	DB                                                          *gorm.DB
+	Config
 }
 
 func (p *PostgreSQL) uri() string {
@@ -53,10 +53,7 @@ func (p *PostgreSQL) Init() error {
 	if uri == "" {
 		return URIRequiredError
 	}
-	db, err := gorm.Open(postgres.Open(uri), &gorm.Config{
-		PrepareStmt: true,
-		Logger:      logger.Default.LogMode(logger.Silent),
-	})
+	db, err := p.Config.open(postgres.Open(uri))
 	if err != nil {
 		return err
 	}
@@ -64,6 +61,25 @@ func (p *PostgreSQL) Init() error {
 	return nil
 }
 
+// Close 关闭连接池
+func (p *PostgreSQL) Close() error {
+	return closeDB(p.DB)
+}
+
+// Gorm 返回底层的 gorm 连接
+func (p *PostgreSQL) Gorm() *gorm.DB {
+	return p.DB
+}
+
+// Transaction 在事务中执行 fn，tx 是使用同一事务的 PostgreSQL
+func (p *PostgreSQL) Transaction(fn func(tx Database) error) error {
+	return p.DB.Transaction(func(db *gorm.DB) error {
+		tx := *p
+		tx.DB = db
+		return fn(&tx)
+	})
+}
+
 func (p *PostgreSQL) AutoMigrate(models ...interface{}) error {
 	return p.DB.AutoMigrate(models...)
 }
@@ -72,8 +88,14 @@ func (p *PostgreSQL) Insert(model interface{}) error {
 	return p.DB.Create(model).Error
 }
 
-func (p *PostgreSQL) InsertMany(models []interface{}) error {
-	return p.DB.Create(models).Error
+// InsertMany 分批插入多条记录，batchSize <= 0 时使用 DefaultBatchSize
+func (p *PostgreSQL) InsertMany(models interface{}, batchSize int) error {
+	return insertMany(p.DB, models, batchSize)
+}
+
+// Upsert 插入记录，冲突时更新 updateColumns
+func (p *PostgreSQL) Upsert(model interface{}, conflictColumns, updateColumns []string) error {
+	return upsert(p.DB, model, conflictColumns, updateColumns)
 }
 
 /*****  查询方法太多了，有必要全列出来吗？  *****/
@@ -3,27 +3,80 @@
  * @Email: thepoy@163.com
  * @File Name: sqlite.go
  * @Created: 2021-07-24 22:24:15
- * @Modified: 2021-07-31 15:14:55
+ * @Modified: 2026-10-18 23:29:01
  */
 
 package dao
 
 import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+)
+
+const (
+	// DefaultJournalMode 默认的日志模式，WAL 模式下读写可以同时进行
+	DefaultJournalMode = "WAL"
+	// DefaultBusyTimeout 默认的数据库被锁定时的等待时间
+	DefaultBusyTimeout = 5 * time.Second
 )
 
 type Sqlite struct {
 	URI string
 	DB  *gorm.DB
+	// 日志模式，即 PRAGMA journal_mode，默认为 WAL
+	JournalMode string
+	// 数据库被其他连接锁定时等待的时间，即 PRAGMA busy_timeout，默认为 5 秒
+	BusyTimeout time.Duration
+	// 同步模式，即 PRAGMA synchronous，默认不设置。WAL 模式下设置为 NORMAL 可以提高写入速度
+	Synchronous string
+	Config
+}
+
+// dsn 在 URI 后添加每个连接都需要设置的 pragma，URI 中已有的参数优先。
+//
+// 事务以 BEGIN IMMEDIATE 开始，在开始时就获取写锁并按 BusyTimeout 等待，
+// 避免多个协程同时写入时事务中途返回 database is locked
+func (s *Sqlite) dsn() string {
+	if s.JournalMode == "" {
+		s.JournalMode = DefaultJournalMode
+	}
+	if s.BusyTimeout == 0 {
+		s.BusyTimeout = DefaultBusyTimeout
+	}
+
+	params := url.Values{}
+	set := func(val string, keys ...string) {
+		if val == "" {
+			return
+		}
+		for _, k := range keys {
+			if strings.Contains(s.URI, k+"=") {
+				return
+			}
+		}
+		params.Set(keys[0], val)
+	}
+	set(s.JournalMode, "_journal_mode", "_journal")
+	set(strconv.FormatInt(s.BusyTimeout.Milliseconds(), 10), "_busy_timeout", "_timeout")
+	set(s.Synchronous, "_synchronous", "_sync")
+	set("immediate", "_txlock")
+
+	if len(params) == 0 {
+		return s.URI
+	}
+	if strings.Contains(s.URI, "?") {
+		return s.URI + "&" + params.Encode()
+	}
+	return s.URI + "?" + params.Encode()
 }
 
 func (s *Sqlite) Init() error {
-	db, err := gorm.Open(sqlite.Open(s.URI), &gorm.Config{
-		PrepareStmt: true,
-		Logger:      logger.Default.LogMode(logger.Silent),
-	})
+	db, err := s.Config.open(sqlite.Open(s.dsn()))
 	if err != nil {
 		return err
 	}
@@ -31,6 +84,25 @@ func (s *Sqlite) Init() error {
 	return nil
 }
 
+// Close 关闭连接池
+func (s *Sqlite) Close() error {
+	return closeDB(s.DB)
+}
+
+// Gorm 返回底层的 gorm 连接
+func (s *Sqlite) Gorm() *gorm.DB {
+	return s.DB
+}
+
+// Transaction 在事务中执行 fn，tx 是使用同一事务的 Sqlite
+func (s *Sqlite) Transaction(fn func(tx Database) error) error {
+	return s.DB.Transaction(func(db *gorm.DB) error {
+		tx := *s
+		tx.DB = db
+		return fn(&tx)
+	})
+}
+
 func (s *Sqlite) AutoMigrate(models ...interface{}) error {
 	return s.DB.AutoMigrate(models...)
 }
@@ -39,8 +111,14 @@ func (s *Sqlite) Insert(model interface{}) error {
 	return s.DB.Create(model).Error
 }
 
-func (s *Sqlite) InsertMany(models []interface{}) error {
-	return s.DB.Create(models).Error
+// InsertMany 分批插入多条记录，batchSize <= 0 时使用 DefaultBatchSize
+func (s *Sqlite) InsertMany(models interface{}, batchSize int) error {
+	return insertMany(s.DB, models, batchSize)
+}
+
+// Upsert 插入记录，冲突时更新 updateColumns
+func (s *Sqlite) Upsert(model interface{}, conflictColumns, updateColumns []string) error {
+	return upsert(s.DB, model, conflictColumns, updateColumns)
 }
 
 /*****  查询方法太多了，有必要全列出来吗？  *****/
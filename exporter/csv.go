@@ -0,0 +1,285 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: csv.go
+ * @Created: 2026-10-18 23:34:32
+ * @Modified: 2026-10-19 00:20:06
+ */
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thep0y/predator/internal/reflectutil"
+	"github.com/thep0y/predator/json"
+)
+
+// CSV 将数据写入 CSV 文件，每个新文件的第一行是表头。
+//
+// 没有用 WithHeader 设置列时，从第一条数据推断：结构体按 csv 标签、json 标签、
+// 字段名的顺序确定列名，没有标签的嵌入结构体会展开，标签为 "-" 的字段和未导出的
+// 字段会被忽略；map 按键排序。之后的数据只导出表头中有的列，缺少的列为空
+type CSV struct {
+	lock   sync.Mutex
+	file   *rotateFile
+	header []string
+	closed bool
+}
+
+// NewCSV 将数据写入 path，path 已存在时追加到文件末尾，不会再写入表头
+func NewCSV(path string, opts ...Option) (*CSV, error) {
+	o := newOptions(opts)
+
+	c := &CSV{header: o.header}
+	rf, err := newRotateFile(path, o, c.writeHeader)
+	if err != nil {
+		return nil, err
+	}
+	c.file = rf
+	return c, nil
+}
+
+func (c *CSV) writeHeader(w io.Writer) (int, error) {
+	line, err := csvLine(c.header)
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(line)
+}
+
+func (c *CSV) Export(item interface{}) error {
+	v := reflectutil.Indirect(reflect.ValueOf(item))
+	if !v.IsValid() || (v.Kind() != reflect.Struct && !isStringMap(v)) {
+		return ErrUnsupportedItem
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return ErrExporterClosed
+	}
+
+	if c.header == nil {
+		c.header = columns(v)
+	}
+
+	record := make([]string, len(c.header))
+	values := fields(v)
+	for i, col := range c.header {
+		f, ok := values[col]
+		if !ok {
+			continue
+		}
+		s, err := format(f)
+		if err != nil {
+			return err
+		}
+		record[i] = s
+	}
+
+	line, err := csvLine(record)
+	if err != nil {
+		return err
+	}
+	_, err = c.file.Write(line)
+	return err
+}
+
+func (c *CSV) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.file.Flush()
+}
+
+func (c *CSV) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.file.Close()
+}
+
+func csvLine(record []string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	err := w.Write(record)
+	if err != nil {
+		return nil, err
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func isStringMap(v reflect.Value) bool {
+	return v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String
+}
+
+// csvField 是结构体中导出到 CSV 的字段
+type csvField struct {
+	name  string
+	index []int
+}
+
+// structFields 缓存每种结构体的字段
+var structFields sync.Map
+
+func fieldsOf(t reflect.Type) []csvField {
+	if fs, ok := structFields.Load(t); ok {
+		return fs.([]csvField)
+	}
+
+	var fs []csvField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, ok := columnName(f)
+		if !ok {
+			continue
+		}
+
+		// 没有标签的嵌入结构体，展开其中的字段
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != reflectutil.TimeType {
+				for _, sub := range fieldsOf(ft) {
+					sub.index = append([]int{i}, sub.index...)
+					fs = append(fs, sub)
+				}
+				continue
+			}
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fs = append(fs, csvField{name: name, index: []int{i}})
+	}
+
+	structFields.Store(t, fs)
+	return fs
+}
+
+// columnName 返回标签中的列名，没有标签时返回空字符串，标签为 "-" 时返回 false
+func columnName(f reflect.StructField) (string, bool) {
+	for _, key := range []string{"csv", "json"} {
+		tag, ok := f.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			return "", false
+		}
+		if name != "" {
+			return name, true
+		}
+	}
+	return "", true
+}
+
+// columns 推断表头
+func columns(v reflect.Value) []string {
+	if v.Kind() == reflect.Struct {
+		fs := fieldsOf(v.Type())
+		header := make([]string, len(fs))
+		for i, f := range fs {
+			header[i] = f.name
+		}
+		return header
+	}
+
+	header := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		header = append(header, k.String())
+	}
+	sort.Strings(header)
+	return header
+}
+
+// fields 返回列名对应的值
+func fields(v reflect.Value) map[string]reflect.Value {
+	values := make(map[string]reflect.Value)
+	if v.Kind() == reflect.Struct {
+		for _, f := range fieldsOf(v.Type()) {
+			if fv, ok := fieldByIndex(v, f.index); ok {
+				values[f.name] = fv
+			}
+		}
+		return values
+	}
+
+	iter := v.MapRange()
+	for iter.Next() {
+		values[iter.Key().String()] = iter.Value()
+	}
+	return values
+}
+
+// fieldByIndex 与 reflect.Value.FieldByIndex 相同，嵌入的结构体指针为 nil 时返回 false
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 {
+			v = reflectutil.Indirect(v)
+			if !v.IsValid() {
+				return v, false
+			}
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// format 将值转换为字符串，nil 为空字符串，
+// 不是基本类型、time.Time 或 []byte 的值以 json 保存
+func format(v reflect.Value) (string, error) {
+	v = reflectutil.Indirect(v)
+	if !v.IsValid() {
+		return "", nil
+	}
+
+	switch v.Type() {
+	case reflectutil.TimeType:
+		return v.Interface().(time.Time).Format(time.RFC3339), nil
+	case reflectutil.BytesType:
+		return string(v.Bytes()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	}
+
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
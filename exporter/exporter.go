@@ -0,0 +1,90 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: exporter.go
+ * @Created: 2026-10-18 23:33:51
+ * @Modified: 2026-10-19 00:03:17
+ */
+
+// Package exporter 将处理函数中产生的数据导出到 JSON Lines、CSV、标准输出或数据库。
+//
+// 所有 Exporter 都可以在多个协程中同时使用，不需要自己加锁：
+//
+//	exp, err := exporter.NewCSV("books.csv", exporter.RotateBySize(64<<20))
+//	defer exp.Close()
+//
+//	c.ParseHTML("h1", func(he *html.HTMLElement, r *predator.Response) {
+//		exp.Export(&Book{URL: r.Request.URL, Title: he.Text()})
+//	})
+package exporter
+
+import (
+	"errors"
+	"time"
+
+	"github.com/thep0y/predator/pipeline"
+)
+
+var (
+	// ErrExporterClosed 已关闭的 Exporter 不能再导出数据
+	ErrExporterClosed = errors.New("exporter already closed")
+	// ErrUnsupportedItem 数据不是结构体或键为字符串的 map
+	ErrUnsupportedItem = pipeline.ErrUnsupportedItem
+)
+
+// Exporter 导出数据，所有方法都可以在多个协程中同时调用
+type Exporter interface {
+	// 导出一条数据
+	Export(item interface{}) error
+	// 将缓冲中的数据写入文件或数据库
+	Flush() error
+	// 写入缓冲中的数据并关闭，关闭后不能再导出
+	Close() error
+}
+
+// Option 是 Exporter 的设置
+type Option func(*options)
+
+type options struct {
+	maxSize  int64
+	interval time.Duration
+	header   []string
+	pipeline []pipeline.Option
+}
+
+// RotateBySize 文件超过 size 字节时轮转，SQL 中为每张表最多保存 size 条数据
+func RotateBySize(size int64) Option {
+	return func(o *options) {
+		o.maxSize = size
+	}
+}
+
+// RotateByTime 每隔 interval 轮转一次
+func RotateByTime(interval time.Duration) Option {
+	return func(o *options) {
+		o.interval = interval
+	}
+}
+
+// WithHeader 设置 CSV 的列，列名与结构体推断出的列名或 map 的键对应，
+// 默认使用第一条数据推断出的列
+func WithHeader(columns ...string) Option {
+	return func(o *options) {
+		o.header = columns
+	}
+}
+
+// WithPipelineOptions 设置 SQL 使用的 ItemPipeline，如批量写入的条数和唯一键
+func WithPipelineOptions(opts ...pipeline.Option) Option {
+	return func(o *options) {
+		o.pipeline = append(o.pipeline, opts...)
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := new(options)
+	for _, op := range opts {
+		op(o)
+	}
+	return o
+}
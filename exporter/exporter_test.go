@@ -0,0 +1,318 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: exporter_test.go
+ * @Created: 2026-10-18 23:35:28
+ * @Modified: 2026-10-19 00:03:17
+ */
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/thep0y/predator/dao"
+	"github.com/thep0y/predator/pipeline"
+)
+
+type Base struct {
+	ID int `json:"id"`
+}
+
+type Book struct {
+	Base
+	URL    string `csv:"url"`
+	Title  string `json:"title"`
+	Price  float64
+	Tags   []string
+	Secret string `csv:"-"`
+	hidden string
+}
+
+type Link struct {
+	ID  uint
+	URL string
+}
+
+// files 返回目录中的文件，按文件名排序
+func files(dir string) []string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	sort.Strings(matches)
+	return matches
+}
+
+func lines(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	var result []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		result = append(result, s.Text())
+	}
+	return result
+}
+
+// exportAll 在多个协程中同时导出 n 条数据，返回出现的第一个错误
+func exportAll(exp Exporter, n int, item func(i int) interface{}) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += 8 {
+				errs <- exp.Export(item(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestJSONLines(t *testing.T) {
+	Convey("测试 JSON Lines", t, func() {
+		dir, err := os.MkdirTemp("", "predator-exporter-")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "books.jsonl")
+
+		Convey("并发写入并按大小轮转", func() {
+			exp, err := NewJSONLines(path, RotateBySize(1024))
+			So(err, ShouldBeNil)
+
+			So(exportAll(exp, 200, func(i int) interface{} {
+				return &Book{URL: fmt.Sprintf("/%d", i)}
+			}), ShouldBeNil)
+			So(exp.Close(), ShouldBeNil)
+			So(exp.Export(&Book{}), ShouldEqual, ErrExporterClosed)
+
+			fs := files(dir)
+			So(len(fs), ShouldBeGreaterThan, 1)
+			// 当前文件的文件名不变
+			So(fs, ShouldContain, path)
+
+			seen := make(map[string]bool)
+			for _, f := range fs {
+				info, err := os.Stat(f)
+				So(err, ShouldBeNil)
+				So(info.Size(), ShouldBeLessThanOrEqualTo, 1024)
+
+				for _, line := range lines(f) {
+					var b Book
+					So(json.Unmarshal([]byte(line), &b), ShouldBeNil)
+					seen[b.URL] = true
+				}
+			}
+			So(len(seen), ShouldEqual, 200)
+		})
+
+		Convey("按时间轮转", func() {
+			exp, err := NewJSONLines(path, RotateByTime(50*time.Millisecond))
+			So(err, ShouldBeNil)
+
+			So(exp.Export(map[string]int{"a": 1}), ShouldBeNil)
+			time.Sleep(60 * time.Millisecond)
+			So(exp.Export(map[string]int{"a": 2}), ShouldBeNil)
+			So(exp.Close(), ShouldBeNil)
+
+			fs := files(dir)
+			So(len(fs), ShouldEqual, 2)
+			So(lines(path), ShouldResemble, []string{`{"a":2}`})
+		})
+
+		Convey("NDJSON", func() {
+			var buf bytes.Buffer
+			exp := NewNDJSON(&buf)
+			So(exp.Export(map[string]int{"a": 1}), ShouldBeNil)
+			// 直接写入，不需要 Flush
+			So(buf.String(), ShouldEqual, "{\"a\":1}\n")
+			So(exp.Close(), ShouldBeNil)
+		})
+	})
+}
+
+func TestCSV(t *testing.T) {
+	Convey("测试 CSV", t, func() {
+		dir, err := os.MkdirTemp("", "predator-exporter-")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "books.csv")
+
+		read := func(path string) [][]string {
+			f, err := os.Open(path)
+			So(err, ShouldBeNil)
+			defer f.Close()
+
+			records, err := csv.NewReader(f).ReadAll()
+			So(err, ShouldBeNil)
+			return records
+		}
+
+		Convey("从结构体标签推断表头", func() {
+			exp, err := NewCSV(path)
+			So(err, ShouldBeNil)
+
+			So(exp.Export(&Book{
+				Base:   Base{ID: 1},
+				URL:    "/1",
+				Title:  "a, \"b\"",
+				Price:  1.5,
+				Tags:   []string{"x"},
+				Secret: "secret",
+			}), ShouldBeNil)
+			So(exp.Export(Book{URL: "/2"}), ShouldBeNil)
+			So(exp.Export([]string{"unsupported"}), ShouldEqual, ErrUnsupportedItem)
+			So(exp.Close(), ShouldBeNil)
+
+			So(read(path), ShouldResemble, [][]string{
+				{"id", "url", "title", "Price", "Tags"},
+				{"1", "/1", "a, \"b\"", "1.5", `["x"]`},
+				{"0", "/2", "", "0", "null"},
+			})
+
+			// 追加到已有的文件时不再写入表头
+			exp, err = NewCSV(path)
+			So(err, ShouldBeNil)
+			So(exp.Export(&Book{URL: "/3"}), ShouldBeNil)
+			So(exp.Close(), ShouldBeNil)
+			So(len(read(path)), ShouldEqual, 4)
+		})
+
+		Convey("map 和指定的表头", func() {
+			exp, err := NewCSV(path, WithHeader("name", "price"))
+			So(err, ShouldBeNil)
+
+			So(exp.Export(map[string]interface{}{"name": "a", "price": 2, "other": true}), ShouldBeNil)
+			So(exp.Export(map[string]interface{}{"name": "b"}), ShouldBeNil)
+			So(exp.Close(), ShouldBeNil)
+
+			So(read(path), ShouldResemble, [][]string{
+				{"name", "price"},
+				{"a", "2"},
+				{"b", ""},
+			})
+		})
+
+		Convey("轮转后的每个文件都有表头", func() {
+			exp, err := NewCSV(path, RotateBySize(256))
+			So(err, ShouldBeNil)
+
+			So(exportAll(exp, 100, func(i int) interface{} {
+				return map[string]int{"n": i}
+			}), ShouldBeNil)
+			So(exp.Close(), ShouldBeNil)
+
+			fs := files(dir)
+			So(len(fs), ShouldBeGreaterThan, 1)
+
+			count := 0
+			for _, f := range fs {
+				records := read(f)
+				So(records[0], ShouldResemble, []string{"n"})
+				count += len(records) - 1
+			}
+			So(count, ShouldEqual, 100)
+		})
+	})
+}
+
+func TestSQL(t *testing.T) {
+	Convey("测试 SQL", t, func() {
+		dir, err := os.MkdirTemp("", "predator-exporter-")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		db := &dao.Sqlite{URI: filepath.Join(dir, "items.sqlite")}
+		So(db.Init(), ShouldBeNil)
+		defer db.Close()
+
+		count := func(table string) int64 {
+			var n int64
+			So(db.DB.Table(table).Count(&n).Error, ShouldBeNil)
+			return n
+		}
+
+		Convey("保存到指定的表", func() {
+			exp, err := NewSQL(db, "links")
+			So(err, ShouldBeNil)
+
+			So(exportAll(exp, 50, func(i int) interface{} {
+				return &Link{URL: fmt.Sprintf("/%d", i)}
+			}), ShouldBeNil)
+			So(exp.Export(map[string]interface{}{"url": "/map"}), ShouldBeNil)
+			So(exp.Close(), ShouldBeNil)
+			So(exp.Export(&Link{}), ShouldEqual, ErrExporterClosed)
+
+			So(count("links"), ShouldEqual, 51)
+		})
+
+		Convey("按条数轮转", func() {
+			exp, err := NewSQL(db, "", RotateBySize(20))
+			So(err, ShouldBeNil)
+
+			first := exp.Table()
+			So(exportAll(exp, 50, func(i int) interface{} {
+				return map[string]interface{}{"n": i}
+			}), ShouldBeNil)
+			So(exp.Close(), ShouldBeNil)
+
+			var tables []string
+			So(db.DB.Raw("SELECT name FROM sqlite_master WHERE type = 'table'").Scan(&tables).Error, ShouldBeNil)
+
+			var total int64
+			var rotated []string
+			for _, table := range tables {
+				if len(table) > len("items_") && table[:len("items_")] == "items_" {
+					rotated = append(rotated, table)
+					n := count(table)
+					So(n, ShouldBeLessThanOrEqualTo, 20)
+					total += n
+				}
+			}
+			So(len(rotated), ShouldEqual, 3)
+			So(rotated, ShouldContain, first)
+			So(total, ShouldEqual, 50)
+		})
+
+		Convey("被处理器丢弃的数据不计入条数", func() {
+			odd := pipeline.ProcessorFunc(func(item interface{}) (interface{}, error) {
+				if item.(*Link).URL[1]%2 == 0 {
+					return nil, nil
+				}
+				return item, nil
+			})
+			exp, err := NewSQL(db, "odd", RotateBySize(6), WithPipelineOptions(pipeline.WithProcessors(odd)))
+			So(err, ShouldBeNil)
+
+			first := exp.Table()
+			So(exportAll(exp, 10, func(i int) interface{} {
+				return &Link{URL: fmt.Sprintf("/%d", i)}
+			}), ShouldBeNil)
+			So(exp.Table(), ShouldEqual, first)
+			So(exp.Close(), ShouldBeNil)
+			So(count(first), ShouldEqual, 5)
+		})
+	})
+}
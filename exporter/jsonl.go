@@ -0,0 +1,103 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: jsonl.go
+ * @Created: 2026-10-18 23:34:15
+ * @Modified: 2026-10-19 00:03:17
+ */
+
+package exporter
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/thep0y/predator/json"
+)
+
+// sink 是 Exporter 写入的目标
+type sink interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// JSONLines 将每条数据编码为一行 json 写入文件或 io.Writer
+type JSONLines struct {
+	lock   sync.Mutex
+	w      sink
+	closed bool
+}
+
+// NewJSONLines 将数据写入 path，path 已存在时追加到文件末尾。
+// 数据先写入缓冲，Flush、Close 或缓冲满时才写入文件
+func NewJSONLines(path string, opts ...Option) (*JSONLines, error) {
+	rf, err := newRotateFile(path, newOptions(opts), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLines{w: rf}, nil
+}
+
+// NewNDJSON 将数据以 NDJSON 写入 w，每条数据直接写入 w，不支持轮转。
+// Close 不会关闭 w
+func NewNDJSON(w io.Writer) *JSONLines {
+	return &JSONLines{w: writerSink{w}}
+}
+
+// NewStdout 将数据以 NDJSON 输出到标准输出，可以通过管道交给其他程序处理
+func NewStdout() *JSONLines {
+	return NewNDJSON(os.Stdout)
+}
+
+func (jl *JSONLines) Export(item interface{}) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	jl.lock.Lock()
+	defer jl.lock.Unlock()
+
+	if jl.closed {
+		return ErrExporterClosed
+	}
+	_, err = jl.w.Write(data)
+	return err
+}
+
+func (jl *JSONLines) Flush() error {
+	jl.lock.Lock()
+	defer jl.lock.Unlock()
+
+	return jl.w.Flush()
+}
+
+func (jl *JSONLines) Close() error {
+	jl.lock.Lock()
+	defer jl.lock.Unlock()
+
+	if jl.closed {
+		return nil
+	}
+	jl.closed = true
+	return jl.w.Close()
+}
+
+// writerSink 将 io.Writer 转换为 sink，w 有 Flush 方法时 Flush 和 Close 会调用它
+type writerSink struct {
+	io.Writer
+}
+
+func (ws writerSink) Flush() error {
+	if f, ok := ws.Writer.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (ws writerSink) Close() error {
+	return ws.Flush()
+}
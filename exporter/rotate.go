@@ -0,0 +1,152 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: rotate.go
+ * @Created: 2026-10-18 23:33:51
+ * @Modified: 2026-10-18 23:33:51
+ */
+
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// rotateLayout 是轮转后的文件名中的时间格式
+const rotateLayout = "20060102-150405"
+
+// rotateFile 是可以按大小或时间轮转的文件，不是并发安全的。
+//
+// 数据总是写入 path，轮转时将 path 重命名为带有时间的文件名，如
+// items.jsonl 重命名为 items-20211018-150405.jsonl，再重新创建 path
+type rotateFile struct {
+	path     string
+	maxSize  int64
+	interval time.Duration
+	// 向空文件写入数据前调用，如写入 CSV 的表头
+	onCreate func(w io.Writer) (int, error)
+
+	file     *os.File
+	buf      *bufio.Writer
+	size     int64
+	openedAt time.Time
+}
+
+func newRotateFile(path string, o *options, onCreate func(w io.Writer) (int, error)) (*rotateFile, error) {
+	rf := &rotateFile{
+		path:     path,
+		maxSize:  o.maxSize,
+		interval: o.interval,
+		onCreate: onCreate,
+	}
+	return rf, rf.open()
+}
+
+// open 以追加的方式打开 path
+func (rf *rotateFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.buf = bufio.NewWriter(file)
+	rf.size = info.Size()
+	rf.openedAt = time.Now()
+	return nil
+}
+
+// full 写入 n 个字节前检查是否需要轮转，至少写入一条数据后才会轮转
+func (rf *rotateFile) full(n int) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.maxSize > 0 && rf.size+int64(n) > rf.maxSize {
+		return true
+	}
+	return rf.interval > 0 && time.Since(rf.openedAt) >= rf.interval
+}
+
+func (rf *rotateFile) Write(p []byte) (int, error) {
+	if rf.file == nil {
+		return 0, ErrExporterClosed
+	}
+
+	if rf.full(len(p)) {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// 第一次写入时才调用 onCreate，CSV 的表头可能要在第一条数据中推断
+	if rf.size == 0 && rf.onCreate != nil {
+		n, err := rf.onCreate(rf.buf)
+		rf.size += int64(n)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.buf.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotateFile) rotate() error {
+	err := rf.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(rf.path, rf.backupName(time.Now()))
+	if err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+// backupName 返回轮转后的文件名，同一秒内多次轮转时在后面加上序号
+func (rf *rotateFile) backupName(t time.Time) string {
+	ext := filepath.Ext(rf.path)
+	base := strings.TrimSuffix(rf.path, ext) + "-" + t.Format(rotateLayout)
+
+	name := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+func (rf *rotateFile) Flush() error {
+	if rf.file == nil {
+		return nil
+	}
+	return rf.buf.Flush()
+}
+
+func (rf *rotateFile) Close() error {
+	if rf.file == nil {
+		return nil
+	}
+
+	err := rf.buf.Flush()
+	if cerr := rf.file.Close(); err == nil {
+		err = cerr
+	}
+	rf.file = nil
+	return err
+}
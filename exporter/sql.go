@@ -0,0 +1,161 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: sql.go
+ * @Created: 2026-10-18 23:34:59
+ * @Modified: 2026-10-19 00:03:17
+ */
+
+package exporter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thep0y/predator/dao"
+	"github.com/thep0y/predator/pipeline"
+)
+
+// tableLayout 是轮转后的表名中的时间格式
+const tableLayout = "20060102_150405"
+
+// SQL 通过 pipeline.ItemPipeline 将数据批量写入 dao.Database，结构体和 map 都保存到同一张表中。
+//
+// 设置了轮转时，数据保存到以开始时间命名的表中，如 items_20211018_150405，
+// RotateBySize 限制每张表的条数
+type SQL struct {
+	lock  sync.Mutex
+	db    dao.Database
+	table string
+	opts  *options
+
+	p        *pipeline.ItemPipeline
+	name     string
+	openedAt time.Time
+	// 上一张表不带序号的表名和序号
+	base string
+	seq  int
+	// 轮转时关闭上一个 ItemPipeline 出现的错误
+	err    error
+	closed bool
+}
+
+// NewSQL 将数据保存到 db 的 table 表中，table 为空时使用 pipeline.DefaultTable
+func NewSQL(db dao.Database, table string, opts ...Option) (*SQL, error) {
+	if table == "" {
+		table = pipeline.DefaultTable
+	}
+
+	s := &SQL{
+		db:    db,
+		table: table,
+		opts:  newOptions(opts),
+	}
+	return s, s.open()
+}
+
+func (s *SQL) rotating() bool {
+	return s.opts.maxSize > 0 || s.opts.interval > 0
+}
+
+// tableName 返回下一张表的表名，同一秒内多次轮转时在后面加上序号
+func (s *SQL) tableName() string {
+	if !s.rotating() {
+		return s.table
+	}
+
+	base := s.table + "_" + s.openedAt.Format(tableLayout)
+	if base != s.base {
+		s.base = base
+		s.seq = 0
+		return base
+	}
+	s.seq++
+	return fmt.Sprintf("%s_%d", base, s.seq)
+}
+
+func (s *SQL) open() error {
+	s.openedAt = time.Now()
+	s.name = s.tableName()
+
+	opts := append(s.opts.pipeline[:len(s.opts.pipeline):len(s.opts.pipeline)], pipeline.WithFixedTable(s.name))
+	p, err := pipeline.NewItemPipeline(s.db, opts...)
+	if err != nil {
+		return err
+	}
+	s.p = p
+	return nil
+}
+
+// accepted 返回当前的表接收的条数，不包括被处理器丢弃或返回错误的数据
+func (s *SQL) accepted() int64 {
+	st := s.p.Stats()
+	return int64(st.Emitted - st.Dropped - st.Invalid)
+}
+
+func (s *SQL) full() bool {
+	n := s.accepted()
+	if n == 0 {
+		return false
+	}
+	if s.opts.maxSize > 0 && n >= s.opts.maxSize {
+		return true
+	}
+	return s.opts.interval > 0 && time.Since(s.openedAt) >= s.opts.interval
+}
+
+// Table 返回当前写入的表名
+func (s *SQL) Table() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.name
+}
+
+func (s *SQL) Export(item interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrExporterClosed
+	}
+
+	if s.full() {
+		if err := s.p.Close(); err != nil && s.err == nil {
+			s.err = err
+		}
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	return s.p.Emit(item)
+}
+
+// Flush 写入缓冲中的数据，返回写入时出现的第一个错误
+func (s *SQL) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.flushErr(s.p.Flush())
+}
+
+func (s *SQL) flushErr(err error) error {
+	if s.err != nil {
+		err, s.err = s.err, nil
+	}
+	return err
+}
+
+// Close 写入缓冲中的数据，不会关闭数据库连接
+func (s *SQL) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flushErr(s.p.Close())
+}
@@ -0,0 +1,188 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: httpcache.go
+ * @Created: 2026-10-18 22:32:33
+ * @Modified: 2026-10-19 00:14:16
+ */
+
+package predator
+
+import (
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// CacheValidator 是开启 HTTP 缓存语义时随响应一起缓存的验证信息
+type CacheValidator struct {
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+	// 响应保持新鲜的截止时间，超过后需要向服务器重新验证
+	FreshUntil time.Time
+}
+
+// Fresh 响应是否仍然新鲜，新鲜的响应可以直接使用，不需要重新验证
+func (cv *CacheValidator) Fresh() bool {
+	return time.Now().Before(cv.FreshUntil)
+}
+
+// Revalidatable 是否可以发送条件请求重新验证
+func (cv *CacheValidator) Revalidatable() bool {
+	return cv.ETag != "" || cv.LastModified != ""
+}
+
+// setConditionalHeaders 为重新验证的请求设置条件请求头
+func (cv *CacheValidator) setConditionalHeaders(headers *fasthttp.RequestHeader) {
+	if cv.ETag != "" {
+		headers.Set(fasthttp.HeaderIfNoneMatch, cv.ETag)
+	}
+	if cv.LastModified != "" {
+		headers.Set(fasthttp.HeaderIfModifiedSince, cv.LastModified)
+	}
+}
+
+// cacheControl 解析 Cache-Control 响应头，指令名统一为小写
+func cacheControl(headers *fasthttp.ResponseHeader) map[string]string {
+	directives := make(map[string]string)
+	for _, d := range strings.Split(string(headers.Peek(fasthttp.HeaderCacheControl)), ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		name, val := d, ""
+		if i := strings.IndexByte(d, '='); i >= 0 {
+			name, val = d[:i], strings.Trim(strings.TrimSpace(d[i+1:]), `"`)
+		}
+		directives[strings.ToLower(strings.TrimSpace(name))] = val
+	}
+	return directives
+}
+
+// storable 响应是否允许缓存
+func storable(headers *fasthttp.ResponseHeader) bool {
+	_, noStore := cacheControl(headers)["no-store"]
+	return !noStore
+}
+
+// freshnessLifetime 按 RFC 7234 计算响应的新鲜时长。
+//
+// 爬虫是私有缓存，所以忽略 s-maxage 和 private。没有明确的过期信息时，
+// 使用 Last-Modified 到现在时长的 10% 作为启发式的新鲜时长。
+func freshnessLifetime(headers *fasthttp.ResponseHeader, now time.Time) time.Duration {
+	cc := cacheControl(headers)
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+
+	date := now
+	if d, err := fasthttp.ParseHTTPDate(headers.Peek(fasthttp.HeaderDate)); err == nil {
+		date = d
+	}
+
+	var age time.Duration
+	if a, err := strconv.Atoi(string(headers.Peek(fasthttp.HeaderAge))); err == nil && a > 0 {
+		age = time.Duration(a) * time.Second
+	}
+
+	if v, ok := cc["max-age"]; ok {
+		maxAge, err := strconv.Atoi(v)
+		if err != nil {
+			return 0
+		}
+		return time.Duration(maxAge)*time.Second - age
+	}
+
+	if v := headers.Peek(fasthttp.HeaderExpires); v != nil {
+		// 无效的 Expires，如 "0"，表示已经过期
+		expires, err := fasthttp.ParseHTTPDate(v)
+		if err != nil {
+			return 0
+		}
+		// 用 Date 计算时长，避免本机和服务器时钟不一致
+		return expires.Sub(date) - age
+	}
+
+	if lm, err := fasthttp.ParseHTTPDate(headers.Peek(fasthttp.HeaderLastModified)); err == nil && lm.Before(date) {
+		return date.Sub(lm)/10 - age
+	}
+
+	return 0
+}
+
+// notUpdatedHeaders 304 响应中不能用来更新缓存的头部，参考 RFC 9111 §3.1 和 §3.2。
+//
+// 304 响应没有响应体，所以与响应体有关的头部也不更新。
+var notUpdatedHeaders = map[string]struct{}{
+	"Connection":         {},
+	"Keep-Alive":         {},
+	"Proxy-Connection":   {},
+	"Te":                 {},
+	"Transfer-Encoding":  {},
+	"Upgrade":            {},
+	"Trailer":            {},
+	"Proxy-Authenticate": {},
+	"Content-Length":     {},
+	"Content-Type":       {},
+	"Content-Encoding":   {},
+	"Content-Range":      {},
+}
+
+// updateHeaders 用 304 响应中的头部替换缓存的响应中的同名头部，参考 RFC 9111 §4.3.4
+func updateHeaders(stored, notModified *fasthttp.ResponseHeader) {
+	// Connection 中列出的头部只对当前连接有效
+	skip := make(map[string]struct{})
+	for _, name := range strings.Split(string(notModified.Peek(fasthttp.HeaderConnection)), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			skip[textproto.CanonicalMIMEHeaderKey(name)] = struct{}{}
+		}
+	}
+
+	values := make(map[string][]string)
+	names := make([]string, 0, 8)
+	notModified.VisitAll(func(key, value []byte) {
+		name := textproto.CanonicalMIMEHeaderKey(string(key))
+		if _, ok := notUpdatedHeaders[name]; ok {
+			return
+		}
+		if _, ok := skip[name]; ok {
+			return
+		}
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		values[name] = append(values[name], string(value))
+	})
+
+	for _, name := range names {
+		stored.Del(name)
+		for _, v := range values[name] {
+			stored.Add(name, v)
+		}
+	}
+}
+
+// newCacheValidator 根据响应头生成验证信息，notModified 用于在 304 响应中
+// 沿用旧的验证器，304 响应可能不包含 ETag 和 Last-Modified
+func newCacheValidator(headers *fasthttp.ResponseHeader, notModified *CacheValidator) *CacheValidator {
+	now := time.Now()
+	cv := &CacheValidator{
+		ETag:         string(headers.Peek(fasthttp.HeaderETag)),
+		LastModified: string(headers.Peek(fasthttp.HeaderLastModified)),
+		FreshUntil:   now.Add(freshnessLifetime(headers, now)),
+	}
+
+	if notModified != nil {
+		if cv.ETag == "" {
+			cv.ETag = notModified.ETag
+		}
+		if cv.LastModified == "" {
+			cv.LastModified = notModified.LastModified
+		}
+	}
+
+	return cv
+}
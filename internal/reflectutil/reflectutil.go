@@ -0,0 +1,32 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: reflectutil.go
+ * @Created: 2026-10-19 00:19:36
+ * @Modified: 2026-10-19 00:19:36
+ */
+
+// Package reflectutil 是 pipeline 和 exporter 共用的反射工具
+package reflectutil
+
+import (
+	"reflect"
+	"time"
+)
+
+var (
+	// TimeType 和 BytesType 是可以直接保存、不需要编码为 json 的非基本类型
+	TimeType  = reflect.TypeOf(time.Time{})
+	BytesType = reflect.TypeOf([]byte(nil))
+)
+
+// Indirect 返回指针或接口最终指向的值，中途遇到 nil 时返回无效的 reflect.Value
+func Indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
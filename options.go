@@ -3,7 +3,7 @@
  * @Email: thepoy@163.com
  * @File Name: options.go
  * @Created: 2021-07-23 08:58:31
- * @Modified: 2021-10-11 12:10:35
+ * @Modified: 2026-10-18 23:51:03
  */
 
 package predator
@@ -14,10 +14,13 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/thep0y/predator/cache"
 	"github.com/thep0y/predator/log"
+	"github.com/thep0y/predator/pipeline"
+	"github.com/thep0y/predator/proxy"
 )
 
 type CrawlerOption func(*Crawler)
@@ -77,6 +80,7 @@ func WithLogger(lop *LogOp) CrawlerOption {
 
 	return func(c *Crawler) {
 		c.log = log.NewLogger(lop.level, lop.Out)
+		c.hasLogger = true
 	}
 }
 
@@ -138,14 +142,86 @@ func WithProxyPool(proxyURLs []string) CrawlerOption {
 	}
 }
 
+// WithProxyBypass 访问匹配规则的地址时不使用代理，直接连接。
+// 规则的格式与 NO_PROXY 环境变量相同，如 "localhost"、".example.com"、"10.0.0.0/8"，
+// 也可以将多条规则用逗号分隔后传入。
+func WithProxyBypass(rules ...string) CrawlerOption {
+	return func(c *Crawler) {
+		if c.proxyBypass == nil {
+			c.proxyBypass = proxy.NewBypass()
+		}
+		for _, r := range rules {
+			c.proxyBypass.Add(r)
+		}
+	}
+}
+
+// WithProxyFromEnvironment 使用 HTTP_PROXY、HTTPS_PROXY、ALL_PROXY 环境变量中的代理，
+// 并将 NO_PROXY 中的地址加入不使用代理的规则中。
+//
+// 与 WithProxy 或 WithProxyPool 同时使用时，优先使用代理池。
+func WithProxyFromEnvironment() CrawlerOption {
+	return func(c *Crawler) {
+		c.proxyEnv = proxy.FromEnvironment()
+		if c.proxyBypass == nil {
+			c.proxyBypass = proxy.NewBypass()
+		}
+		c.proxyBypass.Merge(c.proxyEnv.NoProxy)
+	}
+}
+
+// WithProxyPAC 从本地文件或 http(s) 链接中加载 pac 脚本，每次请求时用请求的
+// 完整链接执行 FindProxyForURL 选择代理。
+//
+// 脚本返回的 PROXY、HTTPS、SOCKS 和 DIRECT 会转换为对应的代理，返回多个
+// 代理时，前一个代理失效才会使用后一个。
+func WithProxyPAC(src string) CrawlerOption {
+	return func(c *Crawler) {
+		pac, err := proxy.LoadPAC(src)
+		if err != nil {
+			panic(err)
+		}
+		c.proxyPAC = pac
+		c.pacClients = new(sync.Map)
+	}
+}
+
+// WithProxyLimit 限制每个代理每秒的请求数和最大并发连接数，所有代理
+// 都达到限制时，请求会等待，直到有代理可用。
+//
+// 经过限制了请求速率的代理时不再复用长连接，每个请求都会重新选择代理。
+func WithProxyLimit(limit proxy.Limit) CrawlerOption {
+	return func(c *Crawler) {
+		if c.proxyLimiter == nil {
+			c.proxyLimiter = proxy.NewLimiter(limit)
+		} else {
+			c.proxyLimiter.SetDefault(limit)
+		}
+	}
+}
+
+// WithProxyLimitFor 单独设置某个代理的限制，没有单独设置的代理使用
+// WithProxyLimit 设置的限制，没有设置时不限制
+func WithProxyLimitFor(proxyAddr string, limit proxy.Limit) CrawlerOption {
+	return func(c *Crawler) {
+		if c.proxyLimiter == nil {
+			c.proxyLimiter = proxy.NewLimiter(proxy.Limit{})
+		}
+		c.proxyLimiter.Set(proxyAddr, limit)
+	}
+}
+
 // WithCache 使用缓存，可以选择是否压缩缓存的响应。
 // 使用缓存时，如果发出的是 POST 请求，最好传入能
 // 代表请求体的唯一性的缓存字段，可以是零个、一个或多个。
 //
-// 注意：当不传入缓存字段时，将会默认采用整个请求体作为
-// 缓存标识，但由于 map 无序，同一个请求体生成的 key 很
-// 难保证相同，所以可能会有同一个请求缓存多次，或者无法
-// 从缓存中读取已请求过的请求的响应的情况出现。
+// 不传入缓存字段时，采用整个请求体作为缓存标识，表单和 json
+// 请求体的字段会按名称排序，multipart 请求体使用全部字段，
+// 同一个请求体总是生成相同的 key。
+//
+// 需要忽略部分查询参数或区分请求头时，使用 WithCacheKeyFunc。
+//
+// 读写缓存出错时（如 redis 连接断开）视为未命中，只记录日志，不会中断请求。
 func WithCache(cc cache.Cache, compressed bool, cacheFileds ...string) CrawlerOption {
 	return func(c *Crawler) {
 		if cc == nil {
@@ -156,9 +232,136 @@ func WithCache(cc cache.Cache, compressed bool, cacheFileds ...string) CrawlerOp
 		if err != nil {
 			panic(err)
 		}
-		c.cache = cc
+		c.cache = cache.NewStore(cc)
+		if len(cacheFileds) > 0 {
+			c.cacheFields = cacheFileds
+		}
+	}
+}
+
+// WithCacheStore 使用实现了 cache.Store 的缓存，store 需要已经初始化，
+// 缓存字段与 WithCache 相同。
+//
+// 读写缓存出错时视为未命中，不会中断请求。只实现了 cache.Store 的缓存
+// 不支持 ClearCache 和自动删除过期的缓存。
+func WithCacheStore(store cache.Store, cacheFileds ...string) CrawlerOption {
+	return func(c *Crawler) {
+		c.cache = store
 		if len(cacheFileds) > 0 {
 			c.cacheFields = cacheFileds
 		}
 	}
 }
+
+// WithCacheWriteBehind 在后台批量写入缓存，请求不需要等待缓存写入完成。
+//
+// 待写入的缓存达到 flushSize 条或距离上次写入超过 flushInterval 时写入一次，
+// 小于等于 0 时使用 cache.DefaultBatchFlushSize 和 cache.DefaultBatchFlushInterval。
+// SQL 缓存每批只需要一条 INSERT 语句，redis 缓存每批只需要一次 pipeline。
+//
+// 程序退出前需要调用 FlushCache 或 CloseCache，否则还未写入的缓存会丢失。
+func WithCacheWriteBehind(flushSize int, flushInterval time.Duration) CrawlerOption {
+	return func(c *Crawler) {
+		c.cacheWriteBehind = true
+		c.cacheFlushSize = flushSize
+		c.cacheFlushInterval = flushInterval
+	}
+}
+
+// WithCacheTimeout 设置每次读写缓存的超时时间，超时后视为未命中，
+// 可以避免缓存服务响应缓慢时拖慢整个爬虫
+func WithCacheTimeout(timeout time.Duration) CrawlerOption {
+	return func(c *Crawler) {
+		c.cacheTimeout = timeout
+	}
+}
+
+// WithCacheTTL 设置缓存的默认过期时间，过期的缓存视为未缓存，并会定期从缓存中删除。
+// ttl 为 0 时永不过期，这也是不设置时的默认行为。
+//
+// 在 BeforeRequest 中可以用 Request.SetCacheTTL 为单个请求设置过期时间。
+//
+// 缓存需要实现 cache.ExpirableCache 接口，已实现的缓存都支持过期时间。
+func WithCacheTTL(ttl time.Duration) CrawlerOption {
+	return func(c *Crawler) {
+		c.cacheTTL = ttl
+	}
+}
+
+// WithCacheKeyFunc 自定义缓存的键，可以用 NewCacheKeyFunc 组合内置的规则：
+//
+//	WithCacheKeyFunc(NewCacheKeyFunc(
+//		CacheKeyNormalizeURL(),
+//		CacheKeyDenyQuery("_", "timestamp", "utm_*"),
+//		CacheKeyHeaders("Accept-Language"),
+//	))
+//
+// 修改缓存的键后，之前缓存的响应将无法命中。
+func WithCacheKeyFunc(fn CacheKeyFunc) CrawlerOption {
+	return func(c *Crawler) {
+		c.cacheKeyFunc = fn
+	}
+}
+
+// WithCachePolicy 自定义哪些响应可以缓存，默认只缓存状态码为 200 或 201 的响应。
+//
+// 可以用来缓存 404、301 等响应，或者排除内容为错误页、验证码的 200 响应：
+//
+//	WithCachePolicy(func(statusCode int, headers *fasthttp.ResponseHeader, body []byte) bool {
+//		if statusCode == fasthttp.StatusOK {
+//			return !bytes.Contains(body, []byte("captcha"))
+//		}
+//		return statusCode == fasthttp.StatusNotFound
+//	})
+func WithCachePolicy(policy CachePolicy) CrawlerOption {
+	return func(c *Crawler) {
+		c.cachePolicy = policy
+	}
+}
+
+// WithOfflineMode 离线模式，只从缓存中读取响应，不会发出任何请求，可以用来
+// 在之前爬取的数据上重新运行解析逻辑。
+//
+// 已过期但还未被删除的缓存也会被使用，此时 Response.Stale 为 true。
+// 缓存未命中时返回 *CacheMissError，可以用 errors.Is(err, ErrCacheMiss) 判断。
+func WithOfflineMode() CrawlerOption {
+	return func(c *Crawler) {
+		c.offline = true
+	}
+}
+
+// WithStaleIfError 请求出错或服务器返回 500、502、503、504 时，如果缓存中有
+// 过期的响应，则使用过期的响应，此时 Response.Stale 为 true。
+//
+// 过期的缓存包括：超过 WithCacheTTL 过期时间但还未被删除的缓存（缓存需要实现
+// cache.StaleCache），以及开启 WithHTTPCache 时不新鲜的缓存。开启后不再自动删除
+// 过期的缓存。
+//
+// 开启后请求出错且没有过期的缓存时，返回错误而不是退出程序。
+func WithStaleIfError() CrawlerOption {
+	return func(c *Crawler) {
+		c.staleIfError = true
+	}
+}
+
+// WithItemPipeline 设置保存数据的 ItemPipeline，处理函数中用 Response.Emit 或
+// Crawler.Emit 提交数据。ItemPipeline 需要在程序退出前自行 Close
+func WithItemPipeline(p *pipeline.ItemPipeline) CrawlerOption {
+	return func(c *Crawler) {
+		c.itemPipeline = p
+	}
+}
+
+// WithHTTPCache 开启 HTTP 缓存语义，需要同时使用 WithCache。
+//
+// 缓存响应时会保存 ETag、Last-Modified 和根据 Cache-Control / Expires 计算出的
+// 新鲜时长，Cache-Control 为 no-store 的响应不会被缓存。
+// 新鲜的缓存直接使用；不新鲜的缓存会发送 If-None-Match / If-Modified-Since
+// 条件请求重新验证，服务器返回 304 时使用缓存的响应，Response.FromCache 为 true。
+//
+// 开启前已经缓存的响应没有验证信息，仍然会被直接使用。
+func WithHTTPCache() CrawlerOption {
+	return func(c *Crawler) {
+		c.httpCache = true
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: item.go
+ * @Created: 2026-10-18 23:21:53
+ * @Modified: 2026-10-19 00:20:06
+ */
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/thep0y/predator/internal/reflectutil"
+	"github.com/thep0y/predator/json"
+	"gorm.io/gorm/schema"
+)
+
+// ErrUnsupportedItem 数据不是结构体或键为字符串的 map
+var ErrUnsupportedItem = errors.New("item must be a struct or a map with string keys")
+
+// record 是规范化后的数据，value 是指向结构体的指针
+type record struct {
+	table string
+	value reflect.Value
+}
+
+// group 同一张表中相同类型的数据一起写入
+type group struct {
+	table string
+	typ   reflect.Type
+}
+
+func (r *record) group() group {
+	return group{table: r.table, typ: r.value.Type().Elem()}
+}
+
+// normalize 将数据转换为指向结构体的指针。结构体会被复制，
+// Emit 之后再修改原数据不会影响写入的数据
+func (p *ItemPipeline) normalize(item interface{}) (*record, error) {
+	v := reflectutil.Indirect(reflect.ValueOf(item))
+	switch v.Kind() {
+	case reflect.Struct:
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+
+		s, err := schema.Parse(ptr.Interface(), &p.schemas, p.db.NamingStrategy)
+		if err != nil {
+			return nil, err
+		}
+		if p.fixedTable {
+			return &record{table: p.table, value: ptr}, nil
+		}
+		return &record{table: s.Table, value: ptr}, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, ErrUnsupportedItem
+		}
+		ptr, err := p.mapValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return &record{table: p.table, value: ptr}, nil
+	}
+	return nil, ErrUnsupportedItem
+}
+
+// mapValue 将 map 转换为结构体，map 的键为列名。
+//
+// 每组不同的键和值类型对应一个结构体类型，自动迁移时会为新出现的键增加列。
+// 值不是基本类型、time.Time 或 []byte 时以 json 保存
+func (p *ItemPipeline) mapValue(m reflect.Value) (reflect.Value, error) {
+	keys := make([]string, 0, m.Len())
+	values := make(map[string]reflect.Value, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		val := iter.Value()
+		for val.Kind() == reflect.Interface {
+			val = val.Elem()
+		}
+		// nil 值不写入，保存为 NULL
+		if !val.IsValid() || (val.Kind() == reflect.Ptr && val.IsNil()) {
+			continue
+		}
+
+		key := iter.Key().String()
+		keys = append(keys, key)
+		values[key] = val
+	}
+	sort.Strings(keys)
+
+	var sig strings.Builder
+	sig.WriteString(p.table)
+	types := make([]reflect.Type, len(keys))
+	for i, key := range keys {
+		types[i] = columnType(values[key].Type())
+		fmt.Fprintf(&sig, "\x00%s\x00%s", key, types[i])
+	}
+
+	typ, err := p.structOf(sig.String(), keys, types)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	ptr := reflect.New(typ)
+	for i, key := range keys {
+		field := ptr.Elem().FieldByName(fieldName(i))
+		val := values[key]
+		if types[i] != val.Type() {
+			data, err := json.Marshal(val.Interface())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			val = reflect.ValueOf(string(data))
+		}
+		field.Set(val)
+	}
+	return ptr, nil
+}
+
+// columnType 返回保存值的类型，不能直接保存的类型以 json 字符串保存
+func columnType(t reflect.Type) reflect.Type {
+	if t == reflectutil.TimeType || t == reflectutil.BytesType {
+		return t
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return t
+	case reflect.Ptr:
+		if columnType(t.Elem()) == t.Elem() {
+			return t
+		}
+	}
+	return reflect.TypeOf("")
+}
+
+func fieldName(i int) string {
+	return fmt.Sprintf("F%d", i)
+}
+
+// structOf 创建 map 对应的结构体类型，相同的键和值类型共用一个类型
+func (p *ItemPipeline) structOf(sig string, keys []string, types []reflect.Type) (reflect.Type, error) {
+	if t, ok := p.mapTypes.Load(sig); ok {
+		return t.(reflect.Type), nil
+	}
+
+	unique := make(map[string]bool, len(p.uniqueKey))
+	for _, col := range p.uniqueKey {
+		unique[col] = true
+	}
+
+	fields := make([]reflect.StructField, 0, len(keys)+1)
+	hasID := false
+	for i, key := range keys {
+		if strings.ContainsAny(key, ";:\"`") {
+			return nil, fmt.Errorf("invalid column name: %q", key)
+		}
+		if key == "id" {
+			hasID = true
+		}
+
+		tag := "column:" + key
+		// MySQL 不能为没有长度的文本列创建索引
+		if unique[key] && types[i].Kind() == reflect.String {
+			tag += ";size:255"
+		}
+		fields = append(fields, reflect.StructField{
+			Name: fieldName(i),
+			Type: types[i],
+			Tag:  reflect.StructTag(`gorm:"` + tag + `"`),
+		})
+	}
+
+	if !hasID {
+		fields = append(fields, reflect.StructField{
+			Name: "ID",
+			Type: reflect.TypeOf(uint64(0)),
+			Tag:  `gorm:"column:id;primaryKey;autoIncrement"`,
+		})
+	}
+
+	t, _ := p.mapTypes.LoadOrStore(sig, reflect.StructOf(fields))
+	return t.(reflect.Type), nil
+}
@@ -0,0 +1,492 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: pipeline.go
+ * @Created: 2026-10-18 23:21:53
+ * @Modified: 2026-10-18 23:57:01
+ */
+
+// Package pipeline 将处理函数中产生的数据依次经过处理器，再批量保存到数据库中。
+//
+//	db := &dao.Sqlite{URI: "items.sqlite"}
+//	db.Init()
+//
+//	p, err := pipeline.NewItemPipeline(db,
+//		pipeline.WithProcessors(pipeline.Required("url"), pipeline.Dedupe("url")),
+//		pipeline.WithUniqueKey("url"),
+//	)
+//	defer p.Close()
+//
+//	c := predator.NewCrawler(predator.WithItemPipeline(p))
+//	c.ParseHTML("a", func(he *html.HTMLElement, r *predator.Response) {
+//		r.Emit(&Link{URL: he.Attr("href"), Text: he.Text()})
+//	})
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thep0y/predator/dao"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// DefaultBatchSize 默认每批写入的条数
+	DefaultBatchSize = 100
+	// DefaultFlushInterval 默认的写入间隔
+	DefaultFlushInterval = time.Second
+	// DefaultTable map 类型的数据默认保存到的表
+	DefaultTable = "items"
+	// DefaultMaxRetries 默认的写入失败后的最大重试次数
+	DefaultMaxRetries = 3
+)
+
+var (
+	// ErrPipelineClosed 已关闭的 ItemPipeline 不能再接收数据
+	ErrPipelineClosed = errors.New("item pipeline already closed")
+	// ErrUnsupportedDatabase 数据库没有实现 dao.Gormer 或还未初始化
+	ErrUnsupportedDatabase = errors.New("the database does not provide a gorm connection")
+)
+
+// Option 是 ItemPipeline 的设置
+type Option func(*ItemPipeline)
+
+// WithProcessors 按顺序添加处理器
+func WithProcessors(processors ...Processor) Option {
+	return func(p *ItemPipeline) {
+		p.processors = append(p.processors, processors...)
+	}
+}
+
+// WithBatchSize 每满 size 条写入一次
+func WithBatchSize(size int) Option {
+	return func(p *ItemPipeline) {
+		p.batchSize = size
+	}
+}
+
+// WithFlushInterval 距离上次写入超过 interval 时写入一次
+func WithFlushInterval(interval time.Duration) Option {
+	return func(p *ItemPipeline) {
+		p.flushInterval = interval
+	}
+}
+
+// WithMaxRetries 写入失败的数据会放回队列，在之后的写入中重试，同一张表连续失败
+// 超过 n 次后丢弃这些数据。n 小于 0 时不重试
+func WithMaxRetries(n int) Option {
+	return func(p *ItemPipeline) {
+		p.maxRetries = n
+		p.maxRetriesSet = true
+	}
+}
+
+// WithUniqueKey 按这些列判断数据是否已存在，已存在时覆盖除主键和创建时间外的其他列。
+// 会自动为这些列创建唯一索引
+func WithUniqueKey(columns ...string) Option {
+	return func(p *ItemPipeline) {
+		p.uniqueKey = columns
+	}
+}
+
+// WithTable 设置 map 类型的数据保存到的表，默认为 items。
+// 结构体按 gorm 的规则确定表名，可以实现 TableName 方法
+func WithTable(name string) Option {
+	return func(p *ItemPipeline) {
+		p.table = name
+	}
+}
+
+// WithFixedTable 所有数据都保存到 name 表中，包括结构体
+func WithFixedTable(name string) Option {
+	return func(p *ItemPipeline) {
+		p.table = name
+		p.fixedTable = true
+	}
+}
+
+// Stats 是 ItemPipeline 的统计
+type Stats struct {
+	// 接收的数据条数
+	Emitted uint64
+	// 被处理器丢弃的条数
+	Dropped uint64
+	// 处理器返回错误的条数
+	Invalid uint64
+	// 已写入数据库的条数，覆盖已有的数据也计入，同一批中唯一键相同的数据只计入最后一条
+	Stored uint64
+	// 重试次数用完后丢弃的条数
+	Failed uint64
+}
+
+// ItemPipeline 接收处理函数产生的数据，经过处理器后在后台批量写入数据库。
+//
+// 数据可以是结构体、结构体指针或键为字符串的 map。第一次写入某种数据时会自动迁移表结构，
+// map 的每个键对应一列。Emit 可以在多个协程中同时调用。
+//
+// 写入失败的数据会放回队列重试，重试次数用完后丢弃并计入 Stats.Failed。
+// 程序退出前需要调用 Flush 或 Close，否则还未写入的数据会丢失
+type ItemPipeline struct {
+	db            *gorm.DB
+	processors    []Processor
+	batchSize     int
+	flushInterval time.Duration
+	uniqueKey     []string
+	maxRetries    int
+	// 是否调用了 WithMaxRetries
+	maxRetriesSet bool
+	table         string
+	// 结构体是否也保存到 table 中
+	fixedTable bool
+
+	schemas  sync.Map
+	mapTypes sync.Map
+
+	lock    sync.Mutex
+	pending map[group][]reflect.Value
+	count   int
+	err     error
+	closed  bool
+
+	// 保证同一时间只有一次写入，同时保护 migrated 和 failures
+	flushLock sync.Mutex
+	migrated  map[group]bool
+	// 每张表连续写入失败的次数
+	failures map[group]int
+
+	notify    chan struct{}
+	done      chan struct{}
+	stopped   sync.WaitGroup
+	closeOnce sync.Once
+
+	stats Stats
+}
+
+// NewItemPipeline 创建 ItemPipeline，db 需要已经初始化，且实现了 dao.Gormer，
+// dao 中的 Database 都满足要求
+func NewItemPipeline(db dao.Database, opts ...Option) (*ItemPipeline, error) {
+	g, ok := db.(dao.Gormer)
+	if !ok || g.Gorm() == nil {
+		return nil, ErrUnsupportedDatabase
+	}
+
+	p := &ItemPipeline{
+		db:       g.Gorm(),
+		table:    DefaultTable,
+		pending:  make(map[group][]reflect.Value),
+		migrated: make(map[group]bool),
+		failures: make(map[group]int),
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	for _, op := range opts {
+		op(p)
+	}
+
+	if p.batchSize <= 0 {
+		p.batchSize = DefaultBatchSize
+	}
+	if p.flushInterval <= 0 {
+		p.flushInterval = DefaultFlushInterval
+	}
+	if !p.maxRetriesSet {
+		p.maxRetries = DefaultMaxRetries
+	}
+
+	p.stopped.Add(1)
+	go p.loop()
+
+	return p, nil
+}
+
+func (p *ItemPipeline) loop() {
+	defer p.stopped.Done()
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.notify:
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+		p.flush()
+	}
+}
+
+// Emit 依次用处理器处理数据，然后放入写入队列。
+//
+// 数据被处理器丢弃时返回 nil，处理器返回其他错误时返回该错误。
+// 写入数据库是异步的，写入时的错误由 Flush 返回
+func (p *ItemPipeline) Emit(item interface{}) error {
+	atomic.AddUint64(&p.stats.Emitted, 1)
+
+	var err error
+	for _, proc := range p.processors {
+		item, err = proc.Process(item)
+		if err != nil {
+			if errors.Is(err, ErrDropItem) {
+				atomic.AddUint64(&p.stats.Dropped, 1)
+				return nil
+			}
+			atomic.AddUint64(&p.stats.Invalid, 1)
+			return err
+		}
+		if item == nil {
+			atomic.AddUint64(&p.stats.Dropped, 1)
+			return nil
+		}
+	}
+
+	rec, err := p.normalize(item)
+	if err != nil {
+		atomic.AddUint64(&p.stats.Invalid, 1)
+		return err
+	}
+
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return ErrPipelineClosed
+	}
+	g := rec.group()
+	p.pending[g] = append(p.pending[g], rec.value)
+	p.count++
+	n := p.count
+	p.lock.Unlock()
+
+	// 写入速度跟不上时同步写入，避免队列无限增长
+	if n >= p.batchSize*4 {
+		p.flush()
+		return nil
+	}
+
+	if n >= p.batchSize {
+		select {
+		case p.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// flush 写入队列中的数据，返回是否有写入失败后放回队列等待重试的数据
+func (p *ItemPipeline) flush() bool {
+	p.flushLock.Lock()
+	defer p.flushLock.Unlock()
+
+	p.lock.Lock()
+	if p.count == 0 {
+		p.lock.Unlock()
+		return false
+	}
+	batches := p.pending
+	p.pending = make(map[group][]reflect.Value, len(batches))
+	p.count = 0
+	p.lock.Unlock()
+
+	requeued := false
+	for g, values := range batches {
+		n, err := p.write(g, values)
+		if err != nil {
+			if p.retry(g, values, err) {
+				requeued = true
+			}
+			continue
+		}
+		delete(p.failures, g)
+		atomic.AddUint64(&p.stats.Stored, uint64(n))
+	}
+	return requeued
+}
+
+// retry 将写入失败的数据放回队列，同一张表连续失败超过 maxRetries 次时丢弃这些数据，
+// 并记录错误。返回数据是否已放回队列
+func (p *ItemPipeline) retry(g group, values []reflect.Value, err error) bool {
+	p.failures[g]++
+	if p.failures[g] > p.maxRetries {
+		delete(p.failures, g)
+		atomic.AddUint64(&p.stats.Failed, uint64(len(values)))
+
+		p.lock.Lock()
+		if p.err == nil {
+			p.err = fmt.Errorf("write %s: %w", g.table, err)
+		}
+		p.lock.Unlock()
+		return false
+	}
+
+	// 放在同一张表新数据的前面，保持写入顺序
+	p.lock.Lock()
+	p.pending[g] = append(values, p.pending[g]...)
+	p.count += len(values)
+	p.lock.Unlock()
+	return true
+}
+
+// write 写入同一张表中相同类型的数据，第一次写入时迁移表结构，返回实际写入的条数
+func (p *ItemPipeline) write(g group, values []reflect.Value) (int, error) {
+	db := p.db.Table(g.table)
+
+	if !p.migrated[g] {
+		err := p.migrate(db, g)
+		if err != nil {
+			return 0, err
+		}
+		p.migrated[g] = true
+	}
+
+	if len(p.uniqueKey) > 0 {
+		values = p.lastByKey(g, values)
+	}
+
+	slice := reflect.MakeSlice(reflect.SliceOf(reflect.PtrTo(g.typ)), 0, len(values))
+	slice = reflect.Append(slice, values...)
+
+	if len(p.uniqueKey) > 0 {
+		columns := make([]clause.Column, len(p.uniqueKey))
+		for i, col := range p.uniqueKey {
+			columns[i] = clause.Column{Name: col}
+		}
+		db = db.Clauses(clause.OnConflict{Columns: columns, UpdateAll: true})
+	}
+
+	err := db.CreateInBatches(slice.Interface(), p.batchSize).Error
+	if err != nil {
+		return 0, err
+	}
+	return len(values), nil
+}
+
+// migrate 迁移表结构，设置了 uniqueKey 时创建唯一索引
+func (p *ItemPipeline) migrate(db *gorm.DB, g group) error {
+	model := reflect.New(g.typ).Interface()
+
+	err := db.AutoMigrate(model)
+	if err != nil {
+		return err
+	}
+	if len(p.uniqueKey) == 0 {
+		return nil
+	}
+
+	name := "idx_" + g.table + "_" + strings.Join(p.uniqueKey, "_")
+	if db.Migrator().HasIndex(model, name) {
+		return nil
+	}
+
+	columns := make([]interface{}, len(p.uniqueKey))
+	for i, col := range p.uniqueKey {
+		columns[i] = clause.Column{Name: col}
+	}
+	return db.Exec("CREATE UNIQUE INDEX ? ON ? ?", clause.Column{Name: name}, clause.Table{Name: g.table}, columns).Error
+}
+
+// lastByKey 同一批中唯一键相同的数据只保留最后一条，
+// 一条 INSERT ... ON CONFLICT 语句不能更新同一行两次。
+//
+// 唯一键中有列没有值的数据保存为 NULL，不会与其他数据冲突，所以不去重
+func (p *ItemPipeline) lastByKey(g group, values []reflect.Value) []reflect.Value {
+	s, err := schema.Parse(reflect.New(g.typ).Interface(), &p.schemas, p.db.NamingStrategy)
+	if err != nil {
+		return values
+	}
+
+	fields := make([]*schema.Field, len(p.uniqueKey))
+	for i, col := range p.uniqueKey {
+		fields[i] = s.LookUpField(col)
+		// map 中没有这一列
+		if fields[i] == nil {
+			return values
+		}
+	}
+
+	index := make(map[string]int, len(values))
+	result := values[:0:0]
+	for _, v := range values {
+		var key strings.Builder
+		complete := true
+		for _, f := range fields {
+			val, _ := f.ValueOf(v.Elem())
+			if isNull(val) {
+				complete = false
+				break
+			}
+			fmt.Fprint(&key, val)
+			key.WriteByte(0)
+		}
+
+		if !complete {
+			result = append(result, v)
+			continue
+		}
+
+		if i, ok := index[key.String()]; ok {
+			result[i] = v
+			continue
+		}
+		index[key.String()] = len(result)
+		result = append(result, v)
+	}
+	return result
+}
+
+// isNull 判断值是否会保存为 NULL
+func isNull(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+	v := reflect.ValueOf(val)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// Flush 立即写入队列中的数据，写入失败时立即重试，直到写入成功或重试次数用完。
+// 返回上次调用 Flush 以来写入时出现的第一个错误
+func (p *ItemPipeline) Flush() error {
+	for p.flush() {
+	}
+
+	p.lock.Lock()
+	err := p.err
+	p.err = nil
+	p.lock.Unlock()
+	return err
+}
+
+// Close 停止后台写入，写入队列中剩余的数据。不会关闭数据库连接
+func (p *ItemPipeline) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		p.lock.Lock()
+		p.closed = true
+		p.lock.Unlock()
+
+		close(p.done)
+		p.stopped.Wait()
+
+		err = p.Flush()
+	})
+	return err
+}
+
+// Stats 返回统计
+func (p *ItemPipeline) Stats() Stats {
+	return Stats{
+		Emitted: atomic.LoadUint64(&p.stats.Emitted),
+		Dropped: atomic.LoadUint64(&p.stats.Dropped),
+		Invalid: atomic.LoadUint64(&p.stats.Invalid),
+		Stored:  atomic.LoadUint64(&p.stats.Stored),
+		Failed:  atomic.LoadUint64(&p.stats.Failed),
+	}
+}
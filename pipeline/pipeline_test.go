@@ -0,0 +1,234 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: pipeline_test.go
+ * @Created: 2026-10-18 23:21:53
+ * @Modified: 2026-10-18 23:57:01
+ */
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/thep0y/predator/dao"
+	"gorm.io/gorm"
+)
+
+type Book struct {
+	ID    uint
+	URL   string `gorm:"size:255"`
+	Title string
+	Price float64
+	// 覆盖时不会更新
+	CreatedAt time.Time
+}
+
+func openDB() (*dao.Sqlite, func()) {
+	dir, err := os.MkdirTemp("", "predator-pipeline-")
+	if err != nil {
+		panic(err)
+	}
+
+	db := &dao.Sqlite{URI: filepath.Join(dir, "items.sqlite")}
+	if err = db.Init(); err != nil {
+		panic(err)
+	}
+	return db, func() {
+		sqlDB, _ := db.DB.DB()
+		sqlDB.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestItemPipeline(t *testing.T) {
+	Convey("测试数据管道", t, func() {
+		db, cleanup := openDB()
+		defer cleanup()
+
+		Convey("处理器", func() {
+			p, err := NewItemPipeline(db,
+				WithProcessors(
+					Required("URL"),
+					Transform(func(item interface{}) (interface{}, error) {
+						b := item.(*Book)
+						b.Title = strings.TrimSpace(b.Title)
+						return b, nil
+					}),
+					Dedupe("url"),
+				),
+			)
+			So(err, ShouldBeNil)
+
+			So(p.Emit(&Book{URL: "/1", Title: " Go "}), ShouldBeNil)
+			So(p.Emit(&Book{URL: "/1", Title: "Go"}), ShouldBeNil)
+			err = p.Emit(&Book{Title: "no url"})
+			So(errors.Is(err, ErrInvalidItem), ShouldBeTrue)
+			So(p.Emit(&Book{URL: "/2", Title: "Rust"}), ShouldBeNil)
+			So(p.Close(), ShouldBeNil)
+
+			So(p.Stats(), ShouldResemble, Stats{Emitted: 4, Dropped: 1, Invalid: 1, Stored: 2})
+
+			var books []Book
+			So(db.DB.Order("url").Find(&books).Error, ShouldBeNil)
+			So(len(books), ShouldEqual, 2)
+			So(books[0].Title, ShouldEqual, "Go")
+
+			So(p.Emit(&Book{URL: "/3"}), ShouldEqual, ErrPipelineClosed)
+		})
+
+		Convey("按唯一键覆盖", func() {
+			p, err := NewItemPipeline(db, WithUniqueKey("url"))
+			So(err, ShouldBeNil)
+
+			So(p.Emit(Book{URL: "/1", Title: "old", Price: 1}), ShouldBeNil)
+			So(p.Flush(), ShouldBeNil)
+
+			var old Book
+			So(db.DB.First(&old).Error, ShouldBeNil)
+
+			// 同一批中的重复数据只保留最后一条
+			So(p.Emit(Book{URL: "/1", Title: "new", Price: 2}), ShouldBeNil)
+			So(p.Emit(Book{URL: "/1", Title: "newer", Price: 3}), ShouldBeNil)
+			So(p.Emit(Book{URL: "/2", Title: "other"}), ShouldBeNil)
+			So(p.Close(), ShouldBeNil)
+
+			var books []Book
+			So(db.DB.Order("url").Find(&books).Error, ShouldBeNil)
+			So(len(books), ShouldEqual, 2)
+			So(books[0].Title, ShouldEqual, "newer")
+			So(books[0].Price, ShouldEqual, 3)
+			So(books[0].CreatedAt.Equal(old.CreatedAt), ShouldBeTrue)
+			// 被同一批中后一条覆盖的数据没有写入
+			So(p.Stats().Stored, ShouldEqual, 3)
+		})
+
+		Convey("没有唯一键的数据不去重", func() {
+			p, err := NewItemPipeline(db, WithTable("products"), WithUniqueKey("sku"))
+			So(err, ShouldBeNil)
+
+			So(p.Emit(map[string]interface{}{"sku": "a", "name": "A"}), ShouldBeNil)
+			So(p.Flush(), ShouldBeNil)
+
+			So(p.Emit(map[string]interface{}{"name": "x"}), ShouldBeNil)
+			So(p.Emit(map[string]interface{}{"name": "y"}), ShouldBeNil)
+			So(p.Emit(map[string]interface{}{"sku": nil, "name": "z"}), ShouldBeNil)
+			So(p.Close(), ShouldBeNil)
+
+			var count int64
+			So(db.DB.Table("products").Where("sku IS NULL").Count(&count).Error, ShouldBeNil)
+			So(count, ShouldEqual, 3)
+			So(p.Stats().Stored, ShouldEqual, 4)
+		})
+
+		Convey("map 类型的数据", func() {
+			p, err := NewItemPipeline(db, WithTable("products"), WithUniqueKey("sku"))
+			So(err, ShouldBeNil)
+
+			So(p.Emit(map[string]interface{}{"sku": "a", "price": 1.5}), ShouldBeNil)
+			So(p.Flush(), ShouldBeNil)
+			// 新出现的键自动增加列，不能直接保存的值以 json 保存
+			So(p.Emit(map[string]interface{}{"sku": "a", "price": 2.5, "tags": []string{"x", "y"}}), ShouldBeNil)
+			So(p.Emit(map[string]string{"sku": "b", "name": "B"}), ShouldBeNil)
+			So(p.Emit([]string{"unsupported"}), ShouldEqual, ErrUnsupportedItem)
+			So(p.Close(), ShouldBeNil)
+
+			var rows []map[string]interface{}
+			So(db.DB.Table("products").Order("sku").Find(&rows).Error, ShouldBeNil)
+			So(len(rows), ShouldEqual, 2)
+			So(rows[0]["price"], ShouldEqual, 2.5)
+			So(rows[0]["tags"], ShouldEqual, `["x","y"]`)
+			So(rows[1]["name"], ShouldEqual, "B")
+		})
+
+		Convey("后台批量写入", func() {
+			p, err := NewItemPipeline(db, WithBatchSize(10), WithFlushInterval(time.Hour))
+			So(err, ShouldBeNil)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 5; j++ {
+						p.Emit(&Book{URL: fmt.Sprintf("/%d/%d", i, j)})
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			// 达到 batchSize 后在后台写入
+			var count int64
+			ok := false
+			for i := 0; i < 100 && !ok; i++ {
+				time.Sleep(10 * time.Millisecond)
+				db.DB.Model(&Book{}).Count(&count)
+				ok = count >= 10
+			}
+			So(ok, ShouldBeTrue)
+
+			So(p.Close(), ShouldBeNil)
+			So(db.DB.Model(&Book{}).Count(&count).Error, ShouldBeNil)
+			So(count, ShouldEqual, 20)
+		})
+
+		Convey("写入失败后重试", func() {
+			failures := 2
+			err := db.DB.Callback().Create().Before("gorm:create").Register("test:fail", func(tx *gorm.DB) {
+				if failures > 0 {
+					failures--
+					tx.AddError(errors.New("database is locked"))
+				}
+			})
+			So(err, ShouldBeNil)
+
+			p, err := NewItemPipeline(db, WithFlushInterval(time.Hour))
+			So(err, ShouldBeNil)
+
+			So(p.Emit(&Book{URL: "/1"}), ShouldBeNil)
+			// 第一次写入失败后放回队列
+			So(p.flush(), ShouldBeTrue)
+			So(p.Stats().Failed, ShouldEqual, 0)
+
+			So(p.Flush(), ShouldBeNil)
+			So(p.Stats().Stored, ShouldEqual, 1)
+			So(p.Stats().Failed, ShouldEqual, 0)
+
+			// 重试次数用完后丢弃
+			failures = 10
+			p2, err := NewItemPipeline(db, WithMaxRetries(1))
+			So(err, ShouldBeNil)
+			So(p2.Emit(&Book{URL: "/2"}), ShouldBeNil)
+			So(p2.Flush(), ShouldNotBeNil)
+			So(p2.Stats().Failed, ShouldEqual, 1)
+			So(failures, ShouldEqual, 8)
+
+			So(p.Close(), ShouldBeNil)
+			So(p2.Close(), ShouldBeNil)
+		})
+
+		Convey("写入出错", func() {
+			p, err := NewItemPipeline(db, WithTable("broken"))
+			So(err, ShouldBeNil)
+
+			So(db.DB.Exec("CREATE TABLE broken (id integer primary key, sku text NOT NULL)").Error, ShouldBeNil)
+			So(p.Emit(map[string]interface{}{"name": "no sku"}), ShouldBeNil)
+			So(p.Flush(), ShouldNotBeNil)
+			So(p.Stats().Failed, ShouldEqual, 1)
+			So(p.Close(), ShouldBeNil)
+		})
+
+		Convey("不支持的数据库", func() {
+			_, err := NewItemPipeline(&dao.Sqlite{})
+			So(err, ShouldEqual, ErrUnsupportedDatabase)
+		})
+	})
+}
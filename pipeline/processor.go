@@ -0,0 +1,157 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: processor.go
+ * @Created: 2026-10-18 23:21:53
+ * @Modified: 2026-10-18 23:57:01
+ */
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm/schema"
+)
+
+var (
+	// ErrDropItem 处理器返回此错误时丢弃数据，Emit 不返回错误
+	ErrDropItem = errors.New("item dropped")
+	// ErrInvalidItem 数据没有通过验证
+	ErrInvalidItem = errors.New("invalid item")
+)
+
+// naming 与 gorm 默认的命名规则相同
+var naming = schema.NamingStrategy{}
+
+// Processor 在数据保存前依次处理数据，可以验证、转换或丢弃数据。
+//
+// Emit 可能在多个协程中同时调用，所以 Processor 需要是并发安全的
+type Processor interface {
+	// 返回处理后的数据，返回 ErrDropItem 或 nil 时丢弃数据
+	Process(item interface{}) (interface{}, error)
+}
+
+// ProcessorFunc 将函数转换为 Processor
+type ProcessorFunc func(item interface{}) (interface{}, error)
+
+func (f ProcessorFunc) Process(item interface{}) (interface{}, error) {
+	return f(item)
+}
+
+// Validate 验证数据，fn 返回的错误会被包装为 ErrInvalidItem
+func Validate(fn func(item interface{}) error) Processor {
+	return ProcessorFunc(func(item interface{}) (interface{}, error) {
+		if err := fn(item); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidItem, err)
+		}
+		return item, nil
+	})
+}
+
+// Required 验证字段不是零值，字段可以是结构体的字段名、列名或 map 的键
+func Required(fields ...string) Processor {
+	return Validate(func(item interface{}) error {
+		for _, name := range fields {
+			v, ok := Field(item, name)
+			if !ok || v.IsZero() {
+				return fmt.Errorf("%s is required", name)
+			}
+		}
+		return nil
+	})
+}
+
+// Transform 转换数据，可以返回与原数据不同类型的数据
+func Transform(fn func(item interface{}) (interface{}, error)) Processor {
+	return ProcessorFunc(fn)
+}
+
+// Dedupe 丢弃这些字段的值都与之前的某条数据相同的数据，只在内存中去重。
+// 需要跨进程去重时使用 WithUniqueKey。
+//
+// 数据在处理时就被记录，写入失败的数据会重试，但重试次数用完被丢弃后，
+// 之后相同的数据仍会被丢弃
+func Dedupe(fields ...string) Processor {
+	return DedupeFunc(func(item interface{}) string {
+		var key strings.Builder
+		for _, name := range fields {
+			if v, ok := Field(item, name); ok {
+				fmt.Fprint(&key, v.Interface())
+			}
+			key.WriteByte(0)
+		}
+		return key.String()
+	})
+}
+
+// DedupeFunc 丢弃 key 返回的值与之前的某条数据相同的数据，与 Dedupe 一样在处理时记录
+func DedupeFunc(key func(item interface{}) string) Processor {
+	var lock sync.Mutex
+	seen := make(map[string]struct{})
+
+	return ProcessorFunc(func(item interface{}) (interface{}, error) {
+		k := key(item)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if _, ok := seen[k]; ok {
+			return nil, ErrDropItem
+		}
+		seen[k] = struct{}{}
+		return item, nil
+	})
+}
+
+// Field 取得数据中的字段，name 可以是结构体的字段名、gorm 的列名或 map 的键
+func Field(item interface{}, name string) (reflect.Value, bool) {
+	v := reflect.ValueOf(item)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return reflect.Value{}, false
+		}
+		f := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		for f.IsValid() && f.Kind() == reflect.Interface {
+			if f.IsNil() {
+				return reflect.Value{}, false
+			}
+			f = f.Elem()
+		}
+		return f, f.IsValid()
+	case reflect.Struct:
+		if f := v.FieldByName(name); f.IsValid() {
+			return f, true
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if columnName(t.Field(i)) == name {
+				return v.Field(i), true
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// columnName 返回字段的列名，优先使用 gorm 标签中的列名
+func columnName(f reflect.StructField) string {
+	for _, opt := range strings.Split(f.Tag.Get("gorm"), ";") {
+		kv := strings.SplitN(opt, ":", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "column") {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return naming.ColumnName("", f.Name)
+}
@@ -3,13 +3,16 @@
  * @Email: thepoy@163.com
  * @File Name: proxy.go
  * @Created: 2021-07-27 12:15:35
- * @Modified:  2021-11-05 14:12:39
+ * @Modified:  2026-10-18 23:51:03
  */
 
 package predator
 
 import (
+	"bytes"
+	"errors"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/thep0y/predator/proxy"
@@ -26,24 +29,164 @@ func (c *Crawler) DialWithProxy() fasthttp.DialFunc {
 
 func (c *Crawler) DialWithProxyAndTimeout(timeout time.Duration) fasthttp.DialFunc {
 	return func(addr string) (net.Conn, error) {
-		proxyAddr := tools.Shuffle(c.proxyURLPool)[0]
-		c.log.Debug().Str("ProxyIP", proxyAddr).Msg("an proxy ip is selected from the proxy pool")
-		if proxyAddr[:7] == "http://" || proxyAddr[:8] == "https://" {
-			return proxy.HttpProxy(proxyAddr, addr, timeout)
-		} else if proxyAddr[:9] == "socks5://" {
-			return proxy.Socks5Proxy(proxyAddr, addr)
-		} else {
+		choices, err := c.selectProxies(addr)
+		if err != nil {
+			c.log.Error().Caller().Err(err).Str("addr", addr).Send()
+			return nil, err
+		}
+
+		return c.dialChoices(choices, addr, timeout)
+	}
+}
+
+// dialChoices 按顺序使用每一组代理连接 addr，只有前一组代理失效时才使用后一组
+func (c *Crawler) dialChoices(choices [][]string, addr string, timeout time.Duration) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	for _, candidates := range choices {
+		proxyAddr := candidates[0]
+
+		var release func()
+		if c.proxyLimiter != nil && proxyAddr != "" {
+			proxyAddr, release, err = c.proxyLimiter.Acquire(c.Context, candidates)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		conn, err = c.dialProxy(proxyAddr, addr, timeout)
+		if err == nil {
+			if release != nil {
+				conn = proxy.WrapConn(conn, release)
+			}
+			return conn, nil
+		}
+
+		if release != nil {
+			release()
+		}
+
+		// 只有代理失效时才使用下一个备选代理
+		if _, ok := proxy.IsProxyInvalid(err); !ok {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (c *Crawler) dialProxy(proxyAddr, addr string, timeout time.Duration) (net.Conn, error) {
+	if proxyAddr == "" {
+		c.log.Debug().Str("addr", addr).Msg("connect directly without proxy")
+		if timeout == 0 {
+			return fasthttp.Dial(addr)
+		}
+		return fasthttp.DialTimeout(addr, timeout)
+	}
+
+	c.log.Debug().Str("ProxyIP", proxyAddr).Msg("an proxy ip is selected from the proxy pool")
+	conn, err := proxy.Dial(proxyAddr, addr, timeout)
+	if err != nil {
+		var pe *proxy.ProxyErr
+		if errors.As(err, &pe) && pe.Code == proxy.ErrUnknownProtocolCode {
 			c.log.Fatal().Caller().
-				Err(&proxy.ProxyErr{
-					Code: proxy.ErrUnknownProtocolCode,
-					Args: map[string]string{
-						"proxy_addr": proxyAddr,
-					},
-					Msg: "only support http and socks5 protocol, but the incoming proxy address uses an unknown protocol",
-				}).
+				Err(err).
 				Str("proxy", proxyAddr).
 				Send()
-			return nil, nil
 		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// selectProxies 选择连接 addr 时使用的代理，空字符串表示直接连接。
+//
+// 返回值中的每一项都是一组可以互相替代的代理，设置了代理限制时从中选择
+// 有余量的代理，否则使用第一个；只有前一组代理失效时才使用后一组，只有
+// pac 脚本会返回多组代理。
+//
+// 优先级：不使用代理的规则 > 代理池 > pac 脚本 > 环境变量
+func (c *Crawler) selectProxies(addr string) ([][]string, error) {
+	if c.proxyBypass.Match(addr) {
+		return [][]string{{""}}, nil
+	}
+
+	if c.ProxyPoolAmount() > 0 {
+		return [][]string{tools.Shuffle(c.proxyURLPool)}, nil
+	}
+
+	if c.proxyPAC != nil {
+		proxies, err := c.proxyPAC.FindProxyForAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		choices := make([][]string, 0, len(proxies))
+		for _, p := range proxies {
+			choices = append(choices, []string{p})
+		}
+		return choices, nil
+	}
+
+	return [][]string{{c.proxyEnv.ProxyFor(addr)}}, nil
+}
+
+// requestClient 返回发送请求的客户端，以及请求可能经过的代理。
+//
+// 拨号时只能拿到 host:port，所以使用 pac 脚本时，每个请求都用完整的链接执行
+// FindProxyForURL，选择了相同代理的请求共用一个客户端，长连接只会在这些请求之间
+// 复用。重定向时使用第一个请求选择的代理。
+func (c *Crawler) requestClient(req *fasthttp.Request) (*fasthttp.Client, []string, error) {
+	uri := req.URI()
+	host := string(uri.Host())
+	addr := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else if bytes.Equal(uri.Scheme(), []byte("https")) {
+		addr = net.JoinHostPort(host, "443")
+	} else {
+		addr = net.JoinHostPort(host, "80")
+	}
+
+	// 与 selectProxies 的优先级相同
+	if c.proxyBypass.Match(addr) {
+		return c.client, nil, nil
+	}
+
+	if c.ProxyPoolAmount() > 0 {
+		return c.client, c.proxyURLPool, nil
+	}
+
+	if c.proxyPAC == nil {
+		return c.client, []string{c.proxyEnv.ProxyFor(addr)}, nil
 	}
+
+	proxies, err := c.proxyPAC.FindProxy(uri.String(), host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := strings.Join(proxies, ";")
+	if client, ok := c.pacClients.Load(key); ok {
+		return client.(*fasthttp.Client), proxies, nil
+	}
+
+	choices := make([][]string, 0, len(proxies))
+	for _, p := range proxies {
+		choices = append(choices, []string{p})
+	}
+
+	client := &fasthttp.Client{TLSConfig: c.client.TLSConfig}
+	client.Dial = func(addr string) (net.Conn, error) {
+		if c.proxyBypass.Match(addr) {
+			return c.dialChoices([][]string{{""}}, addr, 0)
+		}
+		return c.dialChoices(choices, addr, 0)
+	}
+
+	actual, _ := c.pacClients.LoadOrStore(key, client)
+	return actual.(*fasthttp.Client), proxies, nil
+}
+
+// useProxy 是否设置了代理池、pac 脚本或环境变量中的代理
+func (c *Crawler) useProxy() bool {
+	return c.ProxyPoolAmount() > 0 || c.proxyPAC != nil || !c.proxyEnv.Empty()
 }
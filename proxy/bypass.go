@@ -0,0 +1,166 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: bypass.go
+ * @Created: 2026-10-18 11:20:36
+ * @Modified: 2026-10-18 11:20:36
+ */
+
+package proxy
+
+import (
+	"net"
+	"strings"
+)
+
+// Bypass 不使用代理、直接连接的地址规则，规则格式与 NO_PROXY 环境变量相同：
+//
+//   - `*` 匹配所有地址
+//   - `localhost` 匹配 localhost 和所有回环地址
+//   - `example.com` 匹配 example.com 及其所有子域名
+//   - `.example.com` 或 `*.example.com` 只匹配 example.com 的子域名
+//   - `10.0.0.0/8` 匹配网段内的所有 ip
+//   - `192.168.1.1` 匹配单个 ip
+//
+// 除 CIDR 外，每条规则都可以带上端口，如 `example.com:8080`，此时只匹配该端口。
+type Bypass struct {
+	all     bool
+	nets    []*net.IPNet
+	ips     []ipRule
+	domains []domainRule
+}
+
+type ipRule struct {
+	ip   net.IP
+	port string
+}
+
+type domainRule struct {
+	// 统一保存为以 . 开头的后缀
+	suffix string
+	// 是否匹配域名本身
+	matchHost bool
+	port      string
+}
+
+var loopback = []*net.IPNet{
+	{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+}
+
+// NewBypass 根据规则创建 Bypass，每条规则中也可以包含多个以逗号或空格分隔的规则
+func NewBypass(rules ...string) *Bypass {
+	b := new(Bypass)
+	for _, r := range rules {
+		b.Add(r)
+	}
+	return b
+}
+
+// Add 添加以逗号或空格分隔的规则
+func (b *Bypass) Add(rules string) {
+	for _, r := range strings.FieldsFunc(rules, func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		b.add(strings.ToLower(strings.TrimSpace(r)))
+	}
+}
+
+func (b *Bypass) add(rule string) {
+	if rule == "" {
+		return
+	}
+
+	if rule == "*" {
+		b.all = true
+		return
+	}
+
+	if _, ipNet, err := net.ParseCIDR(rule); err == nil {
+		b.nets = append(b.nets, ipNet)
+		return
+	}
+
+	host, port := rule, ""
+	if h, p, err := net.SplitHostPort(rule); err == nil {
+		host, port = h, p
+	}
+
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		b.ips = append(b.ips, ipRule{ip, port})
+		return
+	}
+
+	if host == "localhost" {
+		b.domains = append(b.domains, domainRule{".localhost", true, port})
+		for _, n := range loopback {
+			b.nets = append(b.nets, n)
+		}
+		return
+	}
+
+	host = strings.TrimPrefix(host, "*")
+	if strings.HasPrefix(host, ".") {
+		b.domains = append(b.domains, domainRule{host, false, port})
+	} else {
+		b.domains = append(b.domains, domainRule{"." + host, true, port})
+	}
+}
+
+// Merge 将另一个 Bypass 的规则合并到当前 Bypass 中
+func (b *Bypass) Merge(o *Bypass) {
+	if o == nil {
+		return
+	}
+	b.all = b.all || o.all
+	b.nets = append(b.nets, o.nets...)
+	b.ips = append(b.ips, o.ips...)
+	b.domains = append(b.domains, o.domains...)
+}
+
+// Match 判断地址是否应该直接连接，addr 的格式为 host:port
+func (b *Bypass) Match(addr string) bool {
+	if b == nil {
+		return false
+	}
+
+	if b.all {
+		return true
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range b.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		for _, r := range b.ips {
+			if r.ip.Equal(ip) && (r.port == "" || r.port == port) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, r := range b.domains {
+		if r.port != "" && r.port != port {
+			continue
+		}
+		if strings.HasSuffix(host, r.suffix) || (r.matchHost && host == r.suffix[1:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Empty 判断是否没有任何规则
+func (b *Bypass) Empty() bool {
+	return b == nil || (!b.all && len(b.nets) == 0 && len(b.ips) == 0 && len(b.domains) == 0)
+}
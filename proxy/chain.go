@@ -0,0 +1,140 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: chain.go
+ * @Created: 2026-10-18 10:31:47
+ * @Modified: 2026-10-18 23:47:16
+ */
+
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChainSeparator 代理链中各个代理之间的分隔符。
+//
+// `>` 在 url 中必须被转义，所以不会与代理链接本身产生歧义。
+const ChainSeparator = ">"
+
+// Chain 将多个代理组合成一个代理链，返回值可以作为代理池中的一个代理使用。
+//
+// 请求会按顺序经过每一个代理，如 Chain("socks5://127.0.0.1:1080", "http://proxy.corp:3128")
+// 会先连接本地的 socks5 代理，再通过它连接公司的 http 代理，最后由 http 代理连接目标地址。
+func Chain(hops ...string) string {
+	return strings.Join(hops, ChainSeparator)
+}
+
+// IsChain 判断代理地址是否为代理链
+func IsChain(proxyAddr string) bool {
+	return strings.Contains(proxyAddr, ChainSeparator)
+}
+
+type hop struct {
+	raw string
+	u   *url.URL
+}
+
+func parseChain(chainAddr string) ([]hop, error) {
+	parts := strings.Split(chainAddr, ChainSeparator)
+	hops := make([]hop, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		u, err := parseProxyURL(p)
+		if err != nil {
+			return nil, err
+		}
+
+		switch u.Scheme {
+		case "http", "socks5", "socks5h":
+		case "https":
+			// 代理链中的每一跳都直接在上一跳的连接上握手，不支持与代理建立 tls 连接
+			return nil, &ProxyErr{
+				Code:  ErrUnknownProtocolCode,
+				Msg:   "https proxy is not supported in the proxy chain",
+				Proxy: chainAddr,
+				Args:  map[string]string{"hop": redact(p)},
+			}
+		default:
+			return nil, &ProxyErr{
+				Code:  ErrUnknownProtocolCode,
+				Msg:   "only support http and socks5 protocol in the proxy chain",
+				Proxy: chainAddr,
+				Args:  map[string]string{"hop": redact(p)},
+			}
+		}
+
+		hops = append(hops, hop{p, u})
+	}
+	return hops, nil
+}
+
+// ChainProxy 通过代理链连接目标地址，每一跳都在上一跳建立的连接上完成握手。
+// timeout 限制连接第一跳的时间，以及所有代理握手的总时间，为 0 时不限制。
+//
+// 任意一跳失败时返回的 *ProxyErr 的 Proxy 字段是整个代理链，出错的代理和
+// 它在代理链中的位置保存在 Args 中。
+func ChainProxy(chainAddr, addr string, timeout time.Duration) (net.Conn, error) {
+	hops, err := parseChain(chainAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dial(hops[0].raw, hops[0].u.Host, timeout)
+	if err != nil {
+		return nil, chainErr(chainAddr, 0, hops[0].raw, err)
+	}
+
+	err = withDeadline(conn, timeout, func() error {
+		for i, h := range hops {
+			target := addr
+			if i < len(hops)-1 {
+				target = hops[i+1].u.Host
+			}
+
+			var err error
+			if strings.HasPrefix(h.u.Scheme, "socks5") {
+				err = socks5Handshake(conn, h.raw, h.u, target)
+			} else {
+				err = httpHandshake(conn, h.raw, h.u, target)
+			}
+
+			if err != nil {
+				// 中间的代理无法连接下一跳时，说明下一跳已失效
+				if pe, ok := err.(*ProxyErr); ok && pe.Phase == PhaseConnect && i < len(hops)-1 {
+					pe.Phase = PhaseDial
+					pe.Code = PhaseDial.code()
+					return chainErr(chainAddr, i+1, hops[i+1].raw, pe)
+				}
+				return chainErr(chainAddr, i, h.raw, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func chainErr(chainAddr string, index int, hopAddr string, err error) error {
+	pe, ok := err.(*ProxyErr)
+	if !ok {
+		return err
+	}
+
+	if pe.Args == nil {
+		pe.Args = make(map[string]string)
+	}
+	pe.Args["hop"] = redact(hopAddr)
+	pe.Args["hop_index"] = strconv.Itoa(index)
+	pe.Proxy = chainAddr
+
+	return pe
+}
@@ -0,0 +1,34 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: dial.go
+ * @Created: 2026-10-18 10:52:09
+ * @Modified: 2026-10-19 00:14:10
+ */
+
+package proxy
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// Dial 根据代理地址的协议选择对应的代理连接目标地址，支持 http、https、socks5
+// 和由 Chain 组合成的代理链
+func Dial(proxyAddr, addr string, timeout time.Duration) (net.Conn, error) {
+	switch {
+	case IsChain(proxyAddr):
+		return ChainProxy(proxyAddr, addr, timeout)
+	case strings.HasPrefix(proxyAddr, "http://"), strings.HasPrefix(proxyAddr, "https://"):
+		return HttpProxy(proxyAddr, addr, timeout)
+	case strings.HasPrefix(proxyAddr, "socks5://"), strings.HasPrefix(proxyAddr, "socks5h://"):
+		return Socks5ProxyTimeout(proxyAddr, addr, timeout)
+	default:
+		return nil, &ProxyErr{
+			Code:  ErrUnknownProtocolCode,
+			Proxy: proxyAddr,
+			Msg:   "only support http and socks5 protocol, but the incoming proxy address uses an unknown protocol",
+		}
+	}
+}
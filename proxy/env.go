@@ -0,0 +1,77 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: env.go
+ * @Created: 2026-10-18 11:46:02
+ * @Modified: 2026-10-18 11:46:02
+ */
+
+package proxy
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// Environment 从环境变量中读取的代理配置
+type Environment struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	AllProxy   string
+	NoProxy    *Bypass
+}
+
+func getEnvAny(names ...string) string {
+	for _, n := range names {
+		if val := os.Getenv(n); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// normalize 为没有协议的代理地址加上 http 协议，与 curl 等工具的行为一致
+func normalize(proxyAddr string) string {
+	if proxyAddr == "" || strings.Contains(proxyAddr, "://") {
+		return proxyAddr
+	}
+	return "http://" + proxyAddr
+}
+
+// FromEnvironment 读取 HTTP_PROXY、HTTPS_PROXY、ALL_PROXY 和 NO_PROXY 环境变量，
+// 大写和小写的变量名都可以使用，大写优先。
+func FromEnvironment() *Environment {
+	return &Environment{
+		HTTPProxy:  normalize(getEnvAny("HTTP_PROXY", "http_proxy")),
+		HTTPSProxy: normalize(getEnvAny("HTTPS_PROXY", "https_proxy")),
+		AllProxy:   normalize(getEnvAny("ALL_PROXY", "all_proxy")),
+		NoProxy:    NewBypass(getEnvAny("NO_PROXY", "no_proxy")),
+	}
+}
+
+// ProxyFor 返回连接 addr 时应该使用的代理，返回空字符串时直接连接。
+//
+// 拨号时只能拿到 host:port，所以用端口区分协议：443 端口使用 HTTPS_PROXY，
+// 其他端口使用 HTTP_PROXY，没有对应的代理时使用 ALL_PROXY。
+func (e *Environment) ProxyFor(addr string) string {
+	if e == nil || e.NoProxy.Match(addr) {
+		return ""
+	}
+
+	p := e.HTTPProxy
+	if _, port, err := net.SplitHostPort(addr); err == nil && port == "443" {
+		p = e.HTTPSProxy
+	}
+
+	if p == "" {
+		p = e.AllProxy
+	}
+
+	return p
+}
+
+// Empty 判断环境变量中是否没有设置任何代理
+func (e *Environment) Empty() bool {
+	return e == nil || (e.HTTPProxy == "" && e.HTTPSProxy == "" && e.AllProxy == "")
+}
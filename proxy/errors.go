@@ -3,14 +3,15 @@
  * @Email:     2021-11-05 12:11:41
  * @File Name: errors.go
  * @Created:   2021-11-05 12:11:41
- * @Modified:  2021-11-05 14:22:07
+ * @Modified:  2026-10-18 23:46:52
  */
 
 package proxy
 
 import (
+	"errors"
 	"fmt"
-	"regexp"
+	"net/url"
 	"sort"
 	"strings"
 )
@@ -25,65 +26,163 @@ const (
 	ErrUnkownProxyIPCode
 	ErrIPOrPortIsNullCode
 	ErrEmptyProxyPoolCode
+	// 与代理服务器握手失败
+	ErrProxyHandshakeCode
+	// 代理认证失败
+	ErrProxyAuthCode
+	// 代理服务器拒绝连接目标地址
+	ErrProxyRefusedCode
 )
 
 func (ec ErrCode) String() string {
 	return fmt.Sprintf("proxy error [ %d ]", ec)
 }
 
+// Phase 代理连接失败时所处的阶段
+type Phase uint8
+
+const (
+	// 没有阶段信息，多用于代理池等与连接无关的错误
+	PhaseNone Phase = iota
+	// 与代理服务器建立 tcp 连接
+	PhaseDial
+	// 与代理服务器握手，如 socks5 的方法协商、读写 CONNECT 请求
+	PhaseHandshake
+	// 代理认证失败
+	PhaseAuth
+	// 代理服务器拒绝连接目标地址，如 CONNECT 响应的状态码不是 200
+	PhaseConnect
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseDial:
+		return "dial"
+	case PhaseHandshake:
+		return "handshake"
+	case PhaseAuth:
+		return "auth"
+	case PhaseConnect:
+		return "connect"
+	default:
+		return "none"
+	}
+}
+
+// code 返回各阶段的错误对应的错误码，连接代理失败时认为代理已失效
+func (p Phase) code() ErrCode {
+	switch p {
+	case PhaseHandshake:
+		return ErrProxyHandshakeCode
+	case PhaseAuth:
+		return ErrProxyAuthCode
+	case PhaseConnect:
+		return ErrProxyRefusedCode
+	default:
+		return ErrProxyExpiredCode
+	}
+}
+
 type ProxyErr struct {
 	Code ErrCode
 	Args map[string]string
 	Msg  string
+	// 出错的代理，与传入代理池的代理链接相同
+	Proxy string
+	// 出错时所处的阶段
+	Phase Phase
+	// 原始错误
+	Err error
+}
+
+func newProxyErr(proxyAddr string, phase Phase, msg string, cause error) *ProxyErr {
+	return &ProxyErr{
+		Code:  phase.code(),
+		Msg:   msg,
+		Proxy: proxyAddr,
+		Phase: phase,
+		Err:   cause,
+	}
+}
+
+// redact 隐藏代理链接中的密码
+func redact(proxyAddr string) string {
+	if IsChain(proxyAddr) {
+		hops := strings.Split(proxyAddr, ChainSeparator)
+		for i, h := range hops {
+			hops[i] = redact(h)
+		}
+		return Chain(hops...)
+	}
+
+	u, err := url.Parse(proxyAddr)
+	if err != nil || u.User == nil {
+		return proxyAddr
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
 }
 
 func (pe ProxyErr) Error() string {
 	var s strings.Builder
 	s.WriteString(pe.Code.String())
 
-	if len(pe.Msg) > 0 || len(pe.Args) > 0 {
-		s.WriteByte(' ')
-	}
+	fields := make([]string, 0, len(pe.Args)+4)
 
 	if pe.Msg != "" {
-		s.WriteString("err=")
-		s.WriteString(pe.Msg)
+		fields = append(fields, "err="+pe.Msg)
+	}
+
+	if pe.Phase != PhaseNone {
+		fields = append(fields, "phase="+pe.Phase.String())
 	}
 
-	keys := make([]string, len(pe.Args))
-	for _, k := range pe.Args {
+	if pe.Proxy != "" {
+		fields = append(fields, "proxy="+redact(pe.Proxy))
+	}
+
+	keys := make([]string, 0, len(pe.Args))
+	for k := range pe.Args {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 
-	for i, k := range keys {
-		if i > 0 {
-			s.WriteByte(' ')
-		}
-		s.WriteString(k)
-		s.WriteByte('=')
-		s.WriteString(pe.Args[k])
+	for _, k := range keys {
+		fields = append(fields, k+"="+pe.Args[k])
+	}
+
+	if pe.Err != nil {
+		fields = append(fields, "cause="+pe.Err.Error())
+	}
+
+	if len(fields) > 0 {
+		s.WriteByte(' ')
+		s.WriteString(strings.Join(fields, " "))
 	}
 
 	return s.String()
 }
 
+func (pe ProxyErr) Unwrap() error {
+	return pe.Err
+}
+
+// IsProxyInvalid 判断错误是否由失效的代理引起，如果是则返回失效的代理。
+//
+// 只有连接、握手和认证阶段的错误才认为是代理失效，CONNECT 被拒绝
+// 通常是目标地址的问题，不应该删除代理。
 func IsProxyInvalid(err error) (string, bool) {
-	if len(err.Error()) < 26 {
+	var pe *ProxyErr
+	if !errors.As(err, &pe) || pe.Proxy == "" {
 		return "", false
 	}
 
-	// http proxy expired or invalid
-	if err.Error()[:26] == "cannot connect to proxy ip" {
-		re := regexp.MustCompile(`cannot connect to proxy ip \[ (.+?) \] -> .+?`)
-		return re.FindAllStringSubmatch(err.Error(), 1)[0][1], true
-	}
-
-	// socks5 proxy expired or invalid
-	if err.Error()[:17] == "socks connect tcp" {
-		re := regexp.MustCompile("socks connect tcp (.+?)->.+?")
-		return re.FindAllStringSubmatch(err.Error(), 1)[0][1], true
+	switch pe.Phase {
+	case PhaseDial, PhaseHandshake, PhaseAuth:
+		return pe.Proxy, true
+	default:
+		return "", false
 	}
-
-	return "", false
 }
@@ -3,7 +3,7 @@
  * @Email: thepoy@163.com
  * @File Name: http.go
  * @Created: 2021-07-23 09:22:36
- * @Modified:  2021-11-05 14:07:10
+ * @Modified: 2026-10-18 23:46:52
  */
 
 package proxy
@@ -11,41 +11,101 @@ package proxy
 import (
 	"bufio"
 	"encoding/base64"
-	"fmt"
 	"net"
-	"strings"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/valyala/fasthttp"
 )
 
-func HttpProxy(proxyAddr, addr string, timeout time.Duration) (net.Conn, error) {
-	var auth string
-	proxyAddr = strings.Split(proxyAddr, "//")[1]
-	if strings.Contains(proxyAddr, "@") {
-		split := strings.Split(proxyAddr, "@")
-		auth = base64.StdEncoding.EncodeToString([]byte(split[0]))
-		proxyAddr = split[1]
+func parseProxyURL(proxyAddr string) (*url.URL, error) {
+	u, err := url.Parse(proxyAddr)
+	if err != nil {
+		return nil, &ProxyErr{
+			Code:  ErrWrongFormatCode,
+			Msg:   "cannot parse the proxy address",
+			Proxy: proxyAddr,
+			Err:   err,
+		}
 	}
+
+	if u.Host == "" {
+		return nil, &ProxyErr{
+			Code:  ErrIPOrPortIsNullCode,
+			Msg:   "ip and port cannot be empty",
+			Proxy: proxyAddr,
+		}
+	}
+
+	return u, nil
+}
+
+func dial(proxyAddr, host string, timeout time.Duration) (net.Conn, error) {
 	var conn net.Conn
 	var err error
 	if timeout == 0 {
-		conn, err = fasthttp.Dial(proxyAddr)
+		conn, err = fasthttp.Dial(host)
 	} else {
-		conn, err = fasthttp.DialTimeout(proxyAddr, timeout)
+		conn, err = fasthttp.DialTimeout(host, timeout)
+	}
+	if err != nil {
+		return nil, newProxyErr(proxyAddr, PhaseDial, "cannot connect to proxy ip", err)
+	}
+	return conn, nil
+}
+
+func HttpProxy(proxyAddr, addr string, timeout time.Duration) (net.Conn, error) {
+	u, err := parseProxyURL(proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dial(proxyAddr, u.Host, timeout)
+	if err != nil {
+		return nil, err
 	}
+
+	err = withDeadline(conn, timeout, func() error {
+		return httpHandshake(conn, proxyAddr, u, addr)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("cannot connect to proxy ip [ %s ] -> %s", proxyAddr, err)
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// withDeadline 在 timeout 内完成与代理服务器的握手，握手结束后清除超时，
+// 避免影响之后通过该连接发送的请求。timeout 为 0 时不设置超时。
+func withDeadline(conn net.Conn, timeout time.Duration, handshake func() error) error {
+	if timeout <= 0 {
+		return handshake()
 	}
 
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if err := handshake(); err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// httpHandshake 在已建立的连接上向 http 代理发送 CONNECT 请求
+func httpHandshake(conn net.Conn, proxyAddr string, u *url.URL, addr string) error {
 	req := "CONNECT " + addr + " HTTP/1.1\r\n"
-	if auth != "" {
+	req += "Host: " + addr + "\r\n"
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
 		req += "Proxy-Authorization: Basic " + auth + "\r\n"
 	}
 	req += "\r\n"
 
 	if _, err := conn.Write([]byte(req)); err != nil {
-		return nil, err
+		return newProxyErr(proxyAddr, PhaseHandshake, "cannot send CONNECT request", err)
 	}
 
 	res := fasthttp.AcquireResponse()
@@ -54,12 +114,20 @@ func HttpProxy(proxyAddr, addr string, timeout time.Duration) (net.Conn, error)
 	res.SkipBody = true
 
 	if err := res.Read(bufio.NewReader(conn)); err != nil {
-		conn.Close()
-		return nil, err
+		return newProxyErr(proxyAddr, PhaseHandshake, "cannot read CONNECT response", err)
 	}
-	if res.Header.StatusCode() != 200 {
-		conn.Close()
-		return nil, fmt.Errorf("could not connect to proxy: %s status code: %d", proxyAddr, res.Header.StatusCode())
+
+	switch code := res.Header.StatusCode(); code {
+	case fasthttp.StatusOK:
+		return nil
+	case fasthttp.StatusProxyAuthRequired:
+		return newProxyErr(proxyAddr, PhaseAuth, "proxy authentication failed", nil)
+	default:
+		pe := newProxyErr(proxyAddr, PhaseConnect, "proxy refused to connect to the target", nil)
+		pe.Args = map[string]string{
+			"addr":        addr,
+			"status_code": strconv.Itoa(code),
+		}
+		return pe
 	}
-	return conn, nil
 }
@@ -0,0 +1,233 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: limiter.go
+ * @Created: 2026-10-18 13:37:15
+ * @Modified: 2026-10-18 23:51:03
+ */
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Limit 单个代理的限制
+type Limit struct {
+	// 每秒允许的请求数，小于等于 0 时不限制
+	Rate float64
+	// 令牌桶的容量，即允许的突发请求数，小于等于 0 时为 1
+	Burst int
+	// 最大并发连接数，小于等于 0 时不限制
+	MaxConns int
+}
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+	conns  int
+}
+
+func newBucket(limit Limit) *bucket {
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	return &bucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// available 判断当前是否可以使用此代理，不可用时返回需要等待的时间，
+// 等待时间小于 0 表示需要等待其他连接释放
+func (b *bucket) available(now time.Time) (bool, time.Duration) {
+	if b.limit.MaxConns > 0 && b.conns >= b.limit.MaxConns {
+		return false, -1
+	}
+
+	if b.limit.Rate <= 0 {
+		return true, 0
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if max := float64(b.limit.Burst); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+	}
+
+	return true, 0
+}
+
+func (b *bucket) take() {
+	b.conns++
+	if b.limit.Rate > 0 {
+		b.tokens--
+	}
+}
+
+// Limiter 对每个代理分别限制请求速率和并发连接数
+type Limiter struct {
+	lock    sync.Mutex
+	def     Limit
+	limits  map[string]Limit
+	buckets map[string]*bucket
+	// 有连接释放时关闭，用来唤醒等待的请求
+	released chan struct{}
+}
+
+// NewLimiter 创建 Limiter，def 是没有单独设置限制的代理使用的默认限制
+func NewLimiter(def Limit) *Limiter {
+	return &Limiter{
+		def:      def,
+		limits:   make(map[string]Limit),
+		buckets:  make(map[string]*bucket),
+		released: make(chan struct{}),
+	}
+}
+
+// Set 单独设置某个代理的限制
+func (l *Limiter) Set(proxyAddr string, limit Limit) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.limits[proxyAddr] = limit
+	delete(l.buckets, proxyAddr)
+}
+
+// SetDefault 设置默认限制
+func (l *Limiter) SetDefault(limit Limit) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.def = limit
+	for p := range l.buckets {
+		if _, ok := l.limits[p]; !ok {
+			delete(l.buckets, p)
+		}
+	}
+}
+
+// RateLimited 判断 candidates 中是否有限制了请求速率的代理。
+//
+// 速率是按请求限制的，经过这些代理的请求不能复用长连接；只限制并发连接数时，
+// 长连接会一直占用名额，可以复用。
+func (l *Limiter) RateLimited(candidates ...string) bool {
+	if l == nil {
+		return false
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, p := range candidates {
+		if p == "" {
+			continue
+		}
+		limit, ok := l.limits[p]
+		if !ok {
+			limit = l.def
+		}
+		if limit.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Limiter) bucket(proxyAddr string) *bucket {
+	b, ok := l.buckets[proxyAddr]
+	if !ok {
+		limit, ok := l.limits[proxyAddr]
+		if !ok {
+			limit = l.def
+		}
+		b = newBucket(limit)
+		l.buckets[proxyAddr] = b
+	}
+	return b
+}
+
+// Acquire 按顺序从 candidates 中选择第一个有余量的代理，所有代理都没有余量时
+// 等待，直到有代理可用或 ctx 结束。
+//
+// 返回的 release 用于释放占用的连接数，连接关闭时必须调用。
+func (l *Limiter) Acquire(ctx context.Context, candidates []string) (string, func(), error) {
+	for {
+		l.lock.Lock()
+		now := time.Now()
+		minWait := time.Duration(-1)
+		for _, p := range candidates {
+			b := l.bucket(p)
+			ok, wait := b.available(now)
+			if ok {
+				b.take()
+				l.lock.Unlock()
+				return p, l.releaseFunc(b), nil
+			}
+			if wait >= 0 && (minWait < 0 || wait < minWait) {
+				minWait = wait
+			}
+		}
+		released := l.released
+		l.lock.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if minWait >= 0 {
+			timer = time.NewTimer(minWait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return "", nil, ctx.Err()
+		case <-released:
+		case <-timeout:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (l *Limiter) releaseFunc(b *bucket) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			b.conns--
+			close(l.released)
+			l.released = make(chan struct{})
+			l.lock.Unlock()
+		})
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// WrapConn 在连接关闭时调用 release
+func WrapConn(conn net.Conn, release func()) net.Conn {
+	return &limitedConn{conn, release}
+}
@@ -0,0 +1,511 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: pac.go
+ * @Created: 2026-10-18 12:24:51
+ * @Modified: 2026-10-18 23:49:56
+ */
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robertkrimen/otto"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	ErrNoFindProxyForURL = errors.New("FindProxyForURL is not defined in the pac script")
+	ErrPACTimeout        = errors.New("FindProxyForURL timed out")
+)
+
+const (
+	// DefaultPACTimeout 执行一次 FindProxyForURL 的默认超时时间
+	DefaultPACTimeout = 5 * time.Second
+	// pac 内置函数中每次 dns 查询的超时时间
+	pacDNSTimeout = 2 * time.Second
+)
+
+// PAC 是一个代理自动配置（Proxy Auto-Config）脚本，使用纯 go 实现的 js 解释器执行
+type PAC struct {
+	// 执行一次 FindProxyForURL 的超时时间，为 0 时使用 DefaultPACTimeout
+	Timeout time.Duration
+
+	// otto 不是并发安全的，每次执行时从 vms 中取出一个 vm 的副本，
+	// 避免一次耗时的 dns 查询阻塞其他请求
+	lock sync.Mutex
+	vm   *otto.Otto
+	vms  sync.Pool
+}
+
+// LoadPAC 从本地文件或 http(s) 链接中加载 pac 脚本
+func LoadPAC(src string) (*PAC, error) {
+	var script []byte
+	var err error
+
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		var code int
+		code, script, err = fasthttp.GetTimeout(nil, src, 30*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		if code != fasthttp.StatusOK {
+			return nil, errors.New("cannot download pac script, status code: " + strconv.Itoa(code))
+		}
+	} else {
+		script, err = os.ReadFile(strings.TrimPrefix(src, "file://"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewPAC(script)
+}
+
+// NewPAC 解析 pac 脚本
+func NewPAC(script []byte) (*PAC, error) {
+	vm := otto.New()
+
+	err := registerPACFunctions(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = vm.Run(string(script)); err != nil {
+		return nil, err
+	}
+
+	fn, err := vm.Get("FindProxyForURL")
+	if err != nil {
+		return nil, err
+	}
+	if !fn.IsFunction() {
+		return nil, ErrNoFindProxyForURL
+	}
+
+	p := &PAC{vm: vm}
+	p.vms.New = func() interface{} {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.vm.Copy()
+	}
+
+	return p, nil
+}
+
+// FindProxy 执行 FindProxyForURL，将结果转换为代理链接列表，列表中的空字符串表示直接连接。
+//
+// 返回多个代理时，前一个代理失效才会使用后一个。执行超过 Timeout 时返回 ErrPACTimeout。
+func (p *PAC) FindProxy(rawURL, host string) ([]string, error) {
+	result, err := p.call(rawURL, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePACResult(result), nil
+}
+
+func (p *PAC) call(rawURL, host string) (result string, err error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPACTimeout
+	}
+
+	vm := p.vms.Get().(*otto.Otto)
+	vm.Interrupt = make(chan func(), 1)
+
+	timer := time.AfterFunc(timeout, func() {
+		vm.Interrupt <- func() {
+			panic(ErrPACTimeout)
+		}
+	})
+
+	defer func() {
+		// 超时的 vm 中可能还有未处理的中断，不能再使用
+		if !timer.Stop() {
+			if r := recover(); r != nil {
+				if r != ErrPACTimeout {
+					panic(r)
+				}
+				result, err = "", ErrPACTimeout
+			}
+			return
+		}
+		vm.Interrupt = nil
+		p.vms.Put(vm)
+	}()
+
+	val, err := vm.Call("FindProxyForURL", nil, rawURL, host)
+	if err != nil {
+		return "", err
+	}
+	return val.String(), nil
+}
+
+// FindProxyForAddr 根据拨号地址 host:port 查找代理。
+//
+// 拨号时只能拿到 host:port，所以传给 FindProxyForURL 的链接只有协议和主机名，
+// 443 端口认为是 https，其他端口认为是 http。知道请求链接时应该使用 FindProxy，
+// 否则脚本中根据路径和查询参数选择代理的规则不会生效。
+func (p *PAC) FindProxyForAddr(addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+
+	scheme := "http"
+	if port == "443" {
+		scheme = "https"
+	}
+
+	u := scheme + "://" + addr + "/"
+	if port == "" || port == "80" || port == "443" {
+		u = scheme + "://" + host + "/"
+	}
+
+	return p.FindProxy(u, host)
+}
+
+// ParsePACResult 将 FindProxyForURL 的返回值，如 "PROXY a:8080; SOCKS5 b:1080; DIRECT"，
+// 转换为本包可以使用的代理链接，不支持的代理类型会被忽略
+func ParsePACResult(result string) []string {
+	proxies := make([]string, 0, 2)
+	for _, item := range strings.Split(result, ";") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			proxies = append(proxies, "")
+		case "PROXY", "HTTP":
+			if len(fields) > 1 {
+				proxies = append(proxies, "http://"+fields[1])
+			}
+		case "HTTPS":
+			if len(fields) > 1 {
+				proxies = append(proxies, "https://"+fields[1])
+			}
+		case "SOCKS", "SOCKS5":
+			if len(fields) > 1 {
+				proxies = append(proxies, "socks5://"+fields[1])
+			}
+		}
+	}
+
+	// 空结果与 DIRECT 相同
+	if len(proxies) == 0 {
+		proxies = append(proxies, "")
+	}
+
+	return proxies
+}
+
+/************************* pac 内置函数 ****************************/
+
+func resolve(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pacDNSTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			return ip4
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs[0].IP
+	}
+	return nil
+}
+
+func myIPAddress() string {
+	// udp 不会真正发送数据，只用来获取本机出口 ip
+	conn, err := net.Dial("udp", "8.8.8.8:53")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func shExpMatch(str, shexp string) bool {
+	var s strings.Builder
+	s.WriteString("^")
+	for _, r := range shexp {
+		switch r {
+		case '*':
+			s.WriteString(".*")
+		case '?':
+			s.WriteString(".")
+		default:
+			s.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	s.WriteString("$")
+
+	re, err := regexp.Compile(s.String())
+	if err != nil {
+		return false
+	}
+	return re.MatchString(str)
+}
+
+func isInNet(host, pattern, mask string) bool {
+	ip := resolve(host)
+	p := net.ParseIP(pattern)
+	m := net.ParseIP(mask)
+	if ip == nil || p == nil || m == nil {
+		return false
+	}
+
+	ip, p, m = ip.To4(), p.To4(), m.To4()
+	if ip == nil || p == nil || m == nil {
+		return false
+	}
+
+	ipMask := net.IPMask(m)
+	return ip.Mask(ipMask).Equal(p.Mask(ipMask))
+}
+
+var (
+	weekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+	months   = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+)
+
+func indexOf(list []string, s string) int {
+	s = strings.ToUpper(s)
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// timeArgs 去掉参数末尾的 "GMT"，并返回当前时间
+func timeArgs(call otto.FunctionCall) ([]otto.Value, time.Time) {
+	args := call.ArgumentList
+	now := time.Now()
+	if len(args) > 0 && strings.ToUpper(args[len(args)-1].String()) == "GMT" {
+		args = args[:len(args)-1]
+		now = now.UTC()
+	}
+	return args, now
+}
+
+// inRange 判断 v 是否在 [start, end] 中，start 大于 end 时表示跨越了周期
+func inRange(v, start, end int) bool {
+	if start <= end {
+		return start <= v && v <= end
+	}
+	return v >= start || v <= end
+}
+
+func boolValue(b bool) otto.Value {
+	if b {
+		return otto.TrueValue()
+	}
+	return otto.FalseValue()
+}
+
+func weekdayRange(call otto.FunctionCall) otto.Value {
+	args, now := timeArgs(call)
+	if len(args) == 0 {
+		return otto.FalseValue()
+	}
+
+	start := indexOf(weekdays, args[0].String())
+	end := start
+	if len(args) > 1 {
+		end = indexOf(weekdays, args[1].String())
+	}
+	if start < 0 || end < 0 {
+		return otto.FalseValue()
+	}
+
+	return boolValue(inRange(int(now.Weekday()), start, end))
+}
+
+func timeRange(call otto.FunctionCall) otto.Value {
+	args, now := timeArgs(call)
+
+	nums := make([]int, 0, len(args))
+	for _, a := range args {
+		n, err := a.ToInteger()
+		if err != nil {
+			return otto.FalseValue()
+		}
+		nums = append(nums, int(n))
+	}
+
+	h, m, s := now.Clock()
+
+	switch len(nums) {
+	case 1:
+		return boolValue(h == nums[0])
+	case 2:
+		return boolValue(inRange(h, nums[0], nums[1]))
+	case 4:
+		return boolValue(inRange(h*60+m, nums[0]*60+nums[1], nums[2]*60+nums[3]))
+	case 6:
+		return boolValue(inRange(
+			h*3600+m*60+s,
+			nums[0]*3600+nums[1]*60+nums[2],
+			nums[3]*3600+nums[4]*60+nums[5],
+		))
+	default:
+		return otto.FalseValue()
+	}
+}
+
+type dateField struct {
+	// 0 年，1 月，2 日
+	kind  int
+	value int
+}
+
+func parseDateField(v otto.Value) (dateField, bool) {
+	if m := indexOf(months, v.String()); m >= 0 {
+		return dateField{1, m}, true
+	}
+	n, err := v.ToInteger()
+	if err != nil {
+		return dateField{}, false
+	}
+	if n > 31 {
+		return dateField{0, int(n)}, true
+	}
+	return dateField{2, int(n)}, true
+}
+
+// dateValue 将日期中出现的年、月、日按顺序组合成可比较的整数，并返回出现的字段
+func dateValue(fields []dateField) (int, []int) {
+	sorted := make([]dateField, len(fields))
+	copy(sorted, fields)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].kind < sorted[j].kind
+	})
+
+	v := 0
+	kinds := make([]int, 0, len(sorted))
+	for _, f := range sorted {
+		v = v*10000 + f.value
+		kinds = append(kinds, f.kind)
+	}
+	return v, kinds
+}
+
+func dateRange(call otto.FunctionCall) otto.Value {
+	args, now := timeArgs(call)
+	if len(args) == 0 {
+		return otto.FalseValue()
+	}
+
+	fields := make([]dateField, 0, len(args))
+	seen := make([]bool, 3)
+	distinct := true
+	for _, a := range args {
+		f, ok := parseDateField(a)
+		if !ok {
+			return otto.FalseValue()
+		}
+		if seen[f.kind] {
+			distinct = false
+		}
+		seen[f.kind] = true
+		fields = append(fields, f)
+	}
+
+	current := func(kinds []int) int {
+		v := 0
+		for _, k := range kinds {
+			switch k {
+			case 0:
+				v = v*10000 + now.Year()
+			case 1:
+				v = v*10000 + int(now.Month()) - 1
+			case 2:
+				v = v*10000 + now.Day()
+			}
+		}
+		return v
+	}
+
+	// 每种字段只出现一次时，是单个日期
+	if distinct {
+		v, kinds := dateValue(fields)
+		return boolValue(v == current(kinds))
+	}
+
+	if len(fields)%2 != 0 {
+		return otto.FalseValue()
+	}
+
+	start, kinds := dateValue(fields[:len(fields)/2])
+	end, _ := dateValue(fields[len(fields)/2:])
+
+	return boolValue(inRange(current(kinds), start, end))
+}
+
+func registerPACFunctions(vm *otto.Otto) error {
+	funcs := map[string]interface{}{
+		"isPlainHostName": func(host string) bool {
+			return !strings.Contains(host, ".")
+		},
+		"dnsDomainIs": func(host, domain string) bool {
+			return strings.HasSuffix(strings.ToLower(host), strings.ToLower(domain))
+		},
+		"localHostOrDomainIs": func(host, hostdom string) bool {
+			host, hostdom = strings.ToLower(host), strings.ToLower(hostdom)
+			return host == hostdom || (!strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+"."))
+		},
+		"isResolvable": func(host string) bool {
+			return resolve(host) != nil
+		},
+		"isInNet": isInNet,
+		"dnsResolve": func(call otto.FunctionCall) otto.Value {
+			ip := resolve(call.Argument(0).String())
+			if ip == nil {
+				return otto.NullValue()
+			}
+			v, _ := call.Otto.ToValue(ip.String())
+			return v
+		},
+		"myIpAddress": myIPAddress,
+		"dnsDomainLevels": func(host string) int {
+			return strings.Count(host, ".")
+		},
+		"shExpMatch":   shExpMatch,
+		"weekdayRange": weekdayRange,
+		"dateRange":    dateRange,
+		"timeRange":    timeRange,
+		"alert":        func(string) {},
+	}
+
+	for name, fn := range funcs {
+		if err := vm.Set(name, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
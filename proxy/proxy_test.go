@@ -0,0 +1,550 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: proxy_test.go
+ * @Created: 2026-10-18 10:05:12
+ * @Modified: 2026-10-19 00:14:10
+ */
+
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeServer 启动一个本地服务，用于模拟代理
+func fakeServer(handle func(conn net.Conn)) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+// closedAddr 返回一个没有监听的本地地址
+func closedAddr() string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func httpProxyServer(status int) (string, func()) {
+	return fakeServer(func(conn net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		req.Body.Close()
+		resp := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1}
+		resp.Write(conn)
+	})
+}
+
+func socks5ProxyServer(authOK bool) (string, func()) {
+	return fakeServer(func(conn net.Conn) {
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		conn.Write([]byte{socks5Version, buf[2]})
+
+		if buf[2] == socks5AuthPassword {
+			head := make([]byte, 2)
+			io.ReadFull(conn, head)
+			io.ReadFull(conn, make([]byte, int(head[1])))
+			io.ReadFull(conn, head[:1])
+			io.ReadFull(conn, make([]byte, int(head[0])))
+			if !authOK {
+				conn.Write([]byte{0x01, 0x01})
+				return
+			}
+			conn.Write([]byte{0x01, 0x00})
+		}
+
+		head := make([]byte, 5)
+		io.ReadFull(conn, head)
+		io.ReadFull(conn, make([]byte, int(head[4])+2))
+		conn.Write([]byte{socks5Version, 0, 0, socks5AddrIPv4, 127, 0, 0, 1, 0, 80})
+		conn.Write([]byte("pong"))
+	})
+}
+
+func TestHttpProxy(t *testing.T) {
+	Convey("测试 http 代理", t, func() {
+		Convey("连接成功", func() {
+			addr, stop := httpProxyServer(http.StatusOK)
+			defer stop()
+
+			conn, err := HttpProxy("http://"+addr, "example.com:80", 0)
+			So(err, ShouldBeNil)
+			conn.Close()
+		})
+
+		Convey("代理无法连接", func() {
+			p := "http://" + closedAddr()
+			_, err := HttpProxy(p, "example.com:80", 0)
+
+			var pe *ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Phase, ShouldEqual, PhaseDial)
+			So(pe.Proxy, ShouldEqual, p)
+			So(pe.Err, ShouldNotBeNil)
+
+			invalid, ok := IsProxyInvalid(err)
+			So(ok, ShouldBeTrue)
+			So(invalid, ShouldEqual, p)
+		})
+
+		Convey("认证失败", func() {
+			addr, stop := httpProxyServer(http.StatusProxyAuthRequired)
+			defer stop()
+
+			_, err := HttpProxy("http://user:pass@"+addr, "example.com:80", 0)
+
+			var pe *ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Phase, ShouldEqual, PhaseAuth)
+			So(err.Error(), ShouldNotContainSubstring, "pass@")
+		})
+
+		Convey("CONNECT 被拒绝时不认为代理失效", func() {
+			addr, stop := httpProxyServer(http.StatusBadGateway)
+			defer stop()
+
+			_, err := HttpProxy("http://"+addr, "example.com:80", 0)
+
+			var pe *ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Phase, ShouldEqual, PhaseConnect)
+			So(pe.Args["status_code"], ShouldEqual, "502")
+
+			_, ok := IsProxyInvalid(err)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestSocks5Proxy(t *testing.T) {
+	Convey("测试 socks5 代理", t, func() {
+		Convey("连接成功", func() {
+			addr, stop := socks5ProxyServer(true)
+			defer stop()
+
+			conn, err := Socks5Proxy("socks5://user:pass@"+addr, "example.com:80")
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			b, err := io.ReadAll(conn)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "pong")
+		})
+
+		Convey("认证失败", func() {
+			addr, stop := socks5ProxyServer(false)
+			defer stop()
+
+			p := "socks5://user:pass@" + addr
+			_, err := Socks5Proxy(p, "example.com:80")
+
+			var pe *ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Phase, ShouldEqual, PhaseAuth)
+			So(pe.Code, ShouldEqual, ErrProxyAuthCode)
+
+			invalid, ok := IsProxyInvalid(err)
+			So(ok, ShouldBeTrue)
+			So(invalid, ShouldEqual, p)
+		})
+
+		Convey("代理无法连接", func() {
+			_, err := Socks5Proxy("socks5://"+closedAddr(), "example.com:80")
+
+			var pe *ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Phase, ShouldEqual, PhaseDial)
+			So(pe.Code, ShouldEqual, ErrProxyExpiredCode)
+		})
+
+		Convey("握手超时", func() {
+			addr, stop := fakeServer(func(conn net.Conn) {
+				io.Copy(io.Discard, conn)
+			})
+			defer stop()
+
+			start := time.Now()
+			_, err := Socks5ProxyTimeout("socks5://"+addr, "example.com:80", 200*time.Millisecond)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+
+			var pe *ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Phase, ShouldEqual, PhaseHandshake)
+			So(pe.Code, ShouldEqual, ErrProxyHandshakeCode)
+		})
+	})
+}
+
+func TestProxyErr(t *testing.T) {
+	Convey("测试错误信息中的参数", t, func() {
+		err := ProxyErr{
+			Code: ErrUnkownProxyIPCode,
+			Msg:  "proxy address is unkown",
+			Args: map[string]string{
+				"b": "2",
+				"a": "1",
+			},
+		}
+		So(err.Error(), ShouldEqual, "proxy error [ 4 ] err=proxy address is unkown a=1 b=2")
+	})
+}
+
+// socks5ForwardServer 是一个真正转发数据的 socks5 代理，用于测试代理链
+func socks5ForwardServer() (string, func()) {
+	return fakeServer(func(conn net.Conn) {
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		conn.Write([]byte{socks5Version, socks5AuthNone})
+
+		head := make([]byte, 4)
+		io.ReadFull(conn, head)
+		ip := make(net.IP, net.IPv4len)
+		io.ReadFull(conn, ip)
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+
+		target, err := net.Dial("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(int(port[0])<<8|int(port[1]))))
+		if err != nil {
+			conn.Write([]byte{socks5Version, 0x05, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer target.Close()
+
+		conn.Write([]byte{socks5Version, 0, 0, socks5AddrIPv4, 127, 0, 0, 1, 0, 80})
+
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	})
+}
+
+func TestChainProxy(t *testing.T) {
+	Convey("测试代理链", t, func() {
+		socksAddr, stopSocks := socks5ForwardServer()
+		defer stopSocks()
+
+		Convey("连接成功", func() {
+			httpAddr, stopHTTP := httpProxyServer(http.StatusOK)
+			defer stopHTTP()
+
+			chain := Chain("socks5://"+socksAddr, "http://"+httpAddr)
+			So(IsChain(chain), ShouldBeTrue)
+
+			conn, err := Dial(chain, "example.com:80", 0)
+			So(err, ShouldBeNil)
+			conn.Close()
+		})
+
+		Convey("下一跳无法连接", func() {
+			next := "http://user:pass@" + closedAddr()
+			chain := Chain("socks5://"+socksAddr, next)
+
+			_, err := Dial(chain, "example.com:80", 0)
+
+			var pe *ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Phase, ShouldEqual, PhaseDial)
+			So(pe.Args["hop_index"], ShouldEqual, "1")
+			So(err.Error(), ShouldNotContainSubstring, "pass@")
+
+			invalid, ok := IsProxyInvalid(err)
+			So(ok, ShouldBeTrue)
+			So(invalid, ShouldEqual, chain)
+		})
+
+		Convey("不支持的协议", func() {
+			_, err := Dial(Chain("socks5://"+socksAddr, "ftp://127.0.0.1:21"), "example.com:80", 0)
+
+			var pe *ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Code, ShouldEqual, ErrUnknownProtocolCode)
+
+			_, err = Dial(Chain("socks5://"+socksAddr, "https://127.0.0.1:3128"), "example.com:80", 0)
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Code, ShouldEqual, ErrUnknownProtocolCode)
+		})
+
+		Convey("后续代理握手超时", func() {
+			silent, stop := fakeServer(func(conn net.Conn) {
+				io.Copy(io.Discard, conn)
+			})
+			defer stop()
+
+			start := time.Now()
+			_, err := Dial(Chain("socks5://"+socksAddr, "http://"+silent), "example.com:80", 200*time.Millisecond)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+
+			var pe *ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Phase, ShouldEqual, PhaseHandshake)
+			So(pe.Args["hop_index"], ShouldEqual, "1")
+		})
+	})
+}
+
+func TestBypass(t *testing.T) {
+	Convey("测试不使用代理的规则", t, func() {
+		b := NewBypass("localhost, .internal.corp", "example.com:8080", "10.0.0.0/8 192.168.1.1")
+
+		So(b.Match("localhost:80"), ShouldBeTrue)
+		So(b.Match("127.0.0.1:80"), ShouldBeTrue)
+		So(b.Match("[::1]:80"), ShouldBeTrue)
+
+		So(b.Match("api.internal.corp:443"), ShouldBeTrue)
+		So(b.Match("internal.corp:443"), ShouldBeFalse)
+
+		So(b.Match("example.com:8080"), ShouldBeTrue)
+		So(b.Match("www.example.com:8080"), ShouldBeTrue)
+		So(b.Match("example.com:80"), ShouldBeFalse)
+
+		So(b.Match("10.1.2.3:80"), ShouldBeTrue)
+		So(b.Match("192.168.1.1:80"), ShouldBeTrue)
+		So(b.Match("192.168.1.2:80"), ShouldBeFalse)
+
+		So(b.Match("github.com:443"), ShouldBeFalse)
+		So(NewBypass("*").Match("github.com:443"), ShouldBeTrue)
+
+		var empty *Bypass
+		So(empty.Match("localhost:80"), ShouldBeFalse)
+	})
+
+	Convey("测试环境变量中的代理", t, func() {
+		vars := map[string]string{
+			"HTTP_PROXY":  "127.0.0.1:3128",
+			"HTTPS_PROXY": "",
+			"https_proxy": "",
+			"ALL_PROXY":   "socks5://127.0.0.1:1080",
+			"NO_PROXY":    "example.com",
+		}
+		for k, v := range vars {
+			old, ok := os.LookupEnv(k)
+			os.Setenv(k, v)
+			if ok {
+				defer os.Setenv(k, old)
+			} else {
+				defer os.Unsetenv(k)
+			}
+		}
+
+		env := FromEnvironment()
+		So(env.ProxyFor("github.com:80"), ShouldEqual, "http://127.0.0.1:3128")
+		So(env.ProxyFor("github.com:443"), ShouldEqual, "socks5://127.0.0.1:1080")
+		So(env.ProxyFor("www.example.com:80"), ShouldEqual, "")
+	})
+}
+
+func TestPAC(t *testing.T) {
+	script := []byte(`
+function FindProxyForURL(url, host) {
+	if (isPlainHostName(host) || dnsDomainIs(host, ".internal.corp")) {
+		return "DIRECT";
+	}
+	if (isInNet(host, "10.0.0.0", "255.0.0.0")) {
+		return "SOCKS 10.0.0.1:1080";
+	}
+	if (shExpMatch(url, "https://*.example.com/*")) {
+		return "PROXY a.corp:8080; SOCKS5 b.corp:1080; DIRECT";
+	}
+	return "PROXY default.corp:3128";
+}
+`)
+
+	Convey("测试 pac 脚本", t, func() {
+		pac, err := NewPAC(script)
+		So(err, ShouldBeNil)
+
+		proxies, err := pac.FindProxyForAddr("intranet:80")
+		So(err, ShouldBeNil)
+		So(proxies, ShouldResemble, []string{""})
+
+		proxies, _ = pac.FindProxyForAddr("api.internal.corp:443")
+		So(proxies, ShouldResemble, []string{""})
+
+		proxies, _ = pac.FindProxyForAddr("10.2.3.4:80")
+		So(proxies, ShouldResemble, []string{"socks5://10.0.0.1:1080"})
+
+		proxies, _ = pac.FindProxyForAddr("www.example.com:443")
+		So(proxies, ShouldResemble, []string{"http://a.corp:8080", "socks5://b.corp:1080", ""})
+
+		proxies, _ = pac.FindProxyForAddr("www.example.com:80")
+		So(proxies, ShouldResemble, []string{"http://default.corp:3128"})
+	})
+
+	Convey("测试根据完整链接选择代理", t, func() {
+		pac, err := NewPAC([]byte(`
+function FindProxyForURL(url, host) {
+	if (shExpMatch(url, "*/api/*") || url.indexOf("?proxy=1") >= 0) {
+		return "PROXY api.corp:8080";
+	}
+	return "DIRECT";
+}
+`))
+		So(err, ShouldBeNil)
+
+		proxies, err := pac.FindProxy("https://www.example.com/api/v1", "www.example.com")
+		So(err, ShouldBeNil)
+		So(proxies, ShouldResemble, []string{"http://api.corp:8080"})
+
+		proxies, _ = pac.FindProxy("https://www.example.com/?proxy=1", "www.example.com")
+		So(proxies, ShouldResemble, []string{"http://api.corp:8080"})
+
+		proxies, _ = pac.FindProxy("https://www.example.com/index.html", "www.example.com")
+		So(proxies, ShouldResemble, []string{""})
+
+		// 并发执行
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			go func() {
+				_, err := pac.FindProxy("https://www.example.com/api/v1", "www.example.com")
+				errs <- err
+			}()
+		}
+		for i := 0; i < 10; i++ {
+			So(<-errs, ShouldBeNil)
+		}
+	})
+
+	Convey("测试执行超时", t, func() {
+		pac, err := NewPAC([]byte(`
+function FindProxyForURL(url, host) {
+	if (host === "loop") {
+		while (true) {}
+	}
+	return "DIRECT";
+}
+`))
+		So(err, ShouldBeNil)
+		pac.Timeout = 100 * time.Millisecond
+
+		start := time.Now()
+		_, err = pac.FindProxy("http://loop/", "loop")
+		So(err, ShouldEqual, ErrPACTimeout)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+
+		// 超时不影响之后的执行
+		proxies, err := pac.FindProxy("http://github.com/", "github.com")
+		So(err, ShouldBeNil)
+		So(proxies, ShouldResemble, []string{""})
+	})
+
+	Convey("测试没有 FindProxyForURL 的脚本", t, func() {
+		_, err := NewPAC([]byte(`var a = 1;`))
+		So(err, ShouldEqual, ErrNoFindProxyForURL)
+	})
+
+	Convey("测试时间函数", t, func() {
+		pac, err := NewPAC([]byte(`
+function FindProxyForURL(url, host) {
+	if (weekdayRange("SUN", "SAT") && timeRange(0, 23) && dateRange(1, 31) && dateRange("JAN", "DEC")) {
+		return "DIRECT";
+	}
+	return "PROXY a.corp:8080";
+}
+`))
+		So(err, ShouldBeNil)
+
+		proxies, err := pac.FindProxyForAddr("github.com:443")
+		So(err, ShouldBeNil)
+		So(proxies, ShouldResemble, []string{""})
+	})
+}
+
+func TestLimiter(t *testing.T) {
+	Convey("测试并发连接数限制", t, func() {
+		l := NewLimiter(Limit{MaxConns: 1})
+
+		p1, release1, err := l.Acquire(context.Background(), []string{"a", "b"})
+		So(err, ShouldBeNil)
+		So(p1, ShouldEqual, "a")
+
+		// a 已达到上限，选择 b
+		p2, release2, err := l.Acquire(context.Background(), []string{"a", "b"})
+		So(err, ShouldBeNil)
+		So(p2, ShouldEqual, "b")
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			release2()
+		}()
+
+		start := time.Now()
+		p3, release3, err := l.Acquire(context.Background(), []string{"a", "b"})
+		So(err, ShouldBeNil)
+		So(p3, ShouldEqual, "b")
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, _, err = l.Acquire(ctx, []string{"a", "b"})
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+
+		release1()
+		release3()
+	})
+
+	Convey("测试请求速率限制", t, func() {
+		l := NewLimiter(Limit{})
+		l.Set("a", Limit{Rate: 20, Burst: 2})
+
+		start := time.Now()
+		for i := 0; i < 4; i++ {
+			_, release, err := l.Acquire(context.Background(), []string{"a"})
+			So(err, ShouldBeNil)
+			release()
+		}
+		// 前两个请求使用突发容量，后两个请求各需要等待 50ms
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 90*time.Millisecond)
+	})
+
+	Convey("测试是否限制了请求速率", t, func() {
+		l := NewLimiter(Limit{MaxConns: 2})
+		l.Set("a", Limit{Rate: 1})
+
+		So(l.RateLimited("a"), ShouldBeTrue)
+		So(l.RateLimited("b", "a"), ShouldBeTrue)
+		So(l.RateLimited("b"), ShouldBeFalse)
+		So(l.RateLimited(""), ShouldBeFalse)
+		So(l.RateLimited(), ShouldBeFalse)
+
+		var empty *Limiter
+		So(empty.RateLimited("a"), ShouldBeFalse)
+	})
+}
@@ -3,49 +3,203 @@
  * @Email: thepoy@163.com
  * @File Name: socks5.go (c) 2021
  * @Created: 2021-07-23 09:22:36
- * @Modified: 2021-11-05 14:18:10
+ * @Modified: 2026-10-19 00:14:10
  */
 
 package proxy
 
 import (
 	"errors"
+	"io"
 	"net"
 	"net/url"
-
-	netProxy "golang.org/x/net/proxy"
+	"strconv"
+	"time"
 )
 
 var ErrAddrIsNULL = errors.New("ip and port cannot be empty")
 
+// DefaultSocks5Timeout 是 Socks5Proxy 连接代理服务器和握手的超时时间
+const DefaultSocks5Timeout = 10 * time.Second
+
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+)
+
+// Socks5Proxy 通过 socks5 代理连接目标地址，连接代理服务器和握手的时间
+// 不超过 DefaultSocks5Timeout，需要其他超时时间时使用 Socks5ProxyTimeout。
 func Socks5Proxy(proxyAddr string, addr string) (net.Conn, error) {
+	return Socks5ProxyTimeout(proxyAddr, addr, DefaultSocks5Timeout)
+}
+
+// Socks5ProxyTimeout 通过 socks5 代理连接目标地址，timeout 同时限制连接代理服务器和
+// 握手的时间，为 0 时不限制。
+func Socks5ProxyTimeout(proxyAddr, addr string, timeout time.Duration) (net.Conn, error) {
 	if proxyAddr == "" {
-		panic(ProxyErr{
+		return nil, &ProxyErr{
 			Code: ErrIPOrPortIsNullCode,
 			Msg:  "ip and port cannot be empty",
-			Args: map[string]string{
-				"invalid_proxy": proxyAddr,
-			},
-		})
-	}
-	var (
-		u      *url.URL
-		err    error
-		dialer netProxy.Dialer
-	)
-	if u, err = url.Parse(proxyAddr); err == nil {
-		dialer, err = netProxy.FromURL(u, netProxy.Direct)
-		if err != nil {
-			panic(err)
+			Err:  ErrAddrIsNULL,
+		}
+	}
+
+	u, err := parseProxyURL(proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dial(proxyAddr, u.Host, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withDeadline(conn, timeout, func() error {
+		return socks5Handshake(conn, proxyAddr, u, addr)
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// socks5Handshake 在已建立的连接上完成 socks5 的方法协商、认证和 CONNECT 请求，
+// 参考 RFC 1928 和 RFC 1929。
+//
+// 目标地址是域名时直接交给代理服务器解析，所以 socks5 与 socks5h 的行为相同。
+func socks5Handshake(conn net.Conn, proxyAddr string, u *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return &ProxyErr{Code: ErrWrongFormatCode, Msg: "invalid target address", Proxy: proxyAddr, Err: err}
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return &ProxyErr{
+			Code:  ErrWrongFormatCode,
+			Msg:   "invalid target port",
+			Proxy: proxyAddr,
+			Args:  map[string]string{"addr": addr},
+		}
+	}
+
+	method := byte(socks5AuthNone)
+	if u.User != nil {
+		method = socks5AuthPassword
+	}
+
+	if _, err = conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return newProxyErr(proxyAddr, PhaseHandshake, "cannot send socks5 greeting", err)
+	}
+
+	buf := make([]byte, 2)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		return newProxyErr(proxyAddr, PhaseHandshake, "cannot read socks5 greeting", err)
+	}
+	if buf[0] != socks5Version {
+		return newProxyErr(proxyAddr, PhaseHandshake, "unexpected socks version "+strconv.Itoa(int(buf[0])), nil)
+	}
+	if buf[1] == socks5AuthNoAcceptable || buf[1] != method {
+		return newProxyErr(proxyAddr, PhaseAuth, "no acceptable authentication method", nil)
+	}
+
+	if method == socks5AuthPassword {
+		if err = socks5Auth(conn, proxyAddr, u.User); err != nil {
+			return err
+		}
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip.To16()...)
 		}
 	} else {
-		panic(err)
+		if len(host) > 255 {
+			return &ProxyErr{Code: ErrWrongFormatCode, Msg: "target host is too long", Proxy: proxyAddr}
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+
+	if _, err = conn.Write(req); err != nil {
+		return newProxyErr(proxyAddr, PhaseHandshake, "cannot send socks5 connect request", err)
+	}
+
+	// VER REP RSV ATYP
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return newProxyErr(proxyAddr, PhaseHandshake, "cannot read socks5 connect reply", err)
+	}
+	if header[1] != 0 {
+		pe := newProxyErr(proxyAddr, PhaseConnect, "proxy refused to connect to the target", nil)
+		pe.Args = map[string]string{
+			"addr":  addr,
+			"reply": strconv.Itoa(int(header[1])),
+		}
+		return pe
 	}
 
-	// It would be nice if we could return the error here. But we can't
-	// change our API so just keep returning it in the returned Dial function.
-	// Besides the implementation of proxy.SOCKS5() at the time of writing this
-	// will always return nil as error.
+	// 丢弃代理服务器返回的绑定地址
+	var l int
+	switch header[3] {
+	case socks5AddrIPv4:
+		l = net.IPv4len
+	case socks5AddrIPv6:
+		l = net.IPv6len
+	case socks5AddrDomain:
+		if _, err = io.ReadFull(conn, header[:1]); err != nil {
+			return newProxyErr(proxyAddr, PhaseHandshake, "cannot read socks5 bound address", err)
+		}
+		l = int(header[0])
+	default:
+		return newProxyErr(proxyAddr, PhaseHandshake, "unknown address type "+strconv.Itoa(int(header[3])), nil)
+	}
+
+	if _, err = io.ReadFull(conn, make([]byte, l+2)); err != nil {
+		return newProxyErr(proxyAddr, PhaseHandshake, "cannot read socks5 bound address", err)
+	}
+	return nil
+}
+
+func socks5Auth(conn net.Conn, proxyAddr string, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return newProxyErr(proxyAddr, PhaseAuth, "username or password is too long", nil)
+	}
+
+	req := []byte{0x01, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+
+	if _, err := conn.Write(req); err != nil {
+		return newProxyErr(proxyAddr, PhaseHandshake, "cannot send socks5 authentication", err)
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return newProxyErr(proxyAddr, PhaseHandshake, "cannot read socks5 authentication reply", err)
+	}
+	if buf[1] != 0 {
+		return newProxyErr(proxyAddr, PhaseAuth, "username/password authentication failed", nil)
+	}
 
-	return dialer.Dial("tcp", addr)
+	return nil
 }
@@ -3,7 +3,7 @@
  * @Email: thepoy@163.com
  * @File Name: request.go
  * @Created: 2021-07-24 13:29:11
- * @Modified: 2021-10-11 22:41:32
+ * @Modified: 2026-10-18 22:39:33
  */
 
 package predator
@@ -22,6 +22,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	pctx "github.com/thep0y/predator/context"
 	"github.com/thep0y/predator/json"
@@ -53,6 +54,9 @@ type Request struct {
 	// 大于 0 时，允许最多重定向对应的次数。
 	// 重定向次数会影响爬虫效率。
 	maxRedirectsCount uint
+	// 缓存的过期时间，只有 cacheTTLSet 为 true 时才会覆盖 crawler 的设置
+	cacheTTL    time.Duration
+	cacheTTLSet bool
 }
 
 // New 使用原始请求的上下文创建一个新的请求
@@ -83,6 +87,13 @@ func (r *Request) AllowRedirect(maxRedirectsCount uint) {
 	r.maxRedirectsCount = maxRedirectsCount
 }
 
+// SetCacheTTL 设置本次请求的响应在缓存中的过期时间，覆盖 WithCacheTTL 的设置，
+// ttl 为 0 时永不过期。
+func (r *Request) SetCacheTTL(ttl time.Duration) {
+	r.cacheTTL = ttl
+	r.cacheTTLSet = true
+}
+
 func (r *Request) SetHeaders(headers map[string]string) {
 	for k, v := range headers {
 		r.Headers.Set(k, v)
@@ -160,18 +171,22 @@ func marshalPostBody(body map[string]string) []byte {
 	return b.Bytes()
 }
 
+// cacheBody 返回参与生成缓存键的请求体，有缓存字段时只使用缓存字段
+func (r Request) cacheBody() []byte {
+	if r.Method != fasthttp.MethodPost {
+		return nil
+	}
+	if len(r.cachedMap) > 0 {
+		return marshalPostBody(r.cachedMap)
+	}
+	return r.Body
+}
+
 func (r Request) marshal() ([]byte, error) {
 	cr := &cacheRequest{
 		URL:    r.URL,
 		Method: r.Method,
-	}
-
-	if r.Method == fasthttp.MethodPost {
-		if len(r.cachedMap) > 0 {
-			cr.Body = marshalPostBody(r.cachedMap)
-		} else {
-			cr.Body = r.Body
-		}
+		Body:   r.cacheBody(),
 	}
 
 	return json.Marshal(cr)
@@ -202,6 +217,8 @@ func (r *Request) Reset() {
 	r.crawler = nil
 	r.retryCounter = 0
 	r.maxRedirectsCount = 0
+	r.cacheTTL = 0
+	r.cacheTTLSet = false
 }
 
 var (
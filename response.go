@@ -3,7 +3,7 @@
  * @Email: thepoy@163.com
  * @File Name: response.go (c) 2021
  * @Created: 2021-07-24 13:34:44
- * @Modified: 2021-08-02 18:01:36
+ * @Modified: 2026-10-18 23:21:53
  */
 
 package predator
@@ -12,13 +12,14 @@ import (
 	"errors"
 	"io/ioutil"
 	"sync"
+	"time"
 
 	ctx "github.com/thep0y/predator/context"
-	"github.com/thep0y/predator/json"
 	"github.com/valyala/fasthttp"
 )
 
 var (
+	// Deprecated: Marshal 不再检查状态码，是否缓存由 CachePolicy 决定
 	ErrIncorrectResponse = errors.New("the response status code is not 200 or 201")
 )
 
@@ -35,6 +36,24 @@ type Response struct {
 	Headers fasthttp.ResponseHeader
 	// 是否从缓存中取得的响应
 	FromCache bool
+	// 是否是已过期的缓存，只在离线模式或 stale-if-error 时出现
+	Stale bool `json:"-"`
+	// 开启 HTTP 缓存语义时，用于判断缓存是否新鲜和重新验证
+	Validator *CacheValidator `json:",omitempty"`
+	// 重定向后最终的链接，没有重定向时与请求的链接相同
+	FinalURL string
+	// 获取到响应的时间，从缓存中取得的响应是最初请求的时间
+	FetchedAt time.Time
+	// 请求耗时
+	Latency time.Duration
+}
+
+// Emit 将处理响应时产生的数据交给爬虫的 ItemPipeline 处理和保存
+func (r *Response) Emit(item interface{}) error {
+	if r.Request == nil || r.Request.crawler == nil {
+		return ErrNoItemPipeline
+	}
+	return r.Request.crawler.Emit(item)
 }
 
 // Save writes response body to disk
@@ -65,13 +84,17 @@ func (r *Response) Reset() {
 	ReleaseRequest(r.Request)
 	r.Headers.Reset()
 	r.FromCache = false
+	r.Stale = false
+	r.Validator = nil
+	r.FinalURL = ""
+	r.FetchedAt = time.Time{}
+	r.Latency = 0
 }
 
+// Marshal 将响应序列化为带版本号的缓存记录，包括状态码、响应头、
+// 最终链接、请求时间、耗时、响应体和对应的请求信息
 func (r Response) Marshal() ([]byte, error) {
-	if r.StatusCode != fasthttp.StatusOK && r.StatusCode != fasthttp.StatusCreated {
-		return nil, ErrIncorrectResponse
-	}
-	return json.Marshal(r)
+	return marshalCacheRecord(&r)
 }
 
 var (
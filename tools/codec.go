@@ -0,0 +1,233 @@
+/*
+ * @Author: thepoy
+ * @Email: thepoy@163.com
+ * @File Name: codec.go
+ * @Created: 2026-10-18 23:03:04
+ * @Modified: 2026-10-18 23:03:04
+ */
+
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec 压缩算法。
+//
+// Encode 的结果以一个字节的标记开头，标记记录了使用的压缩算法，
+// 所以用不同的算法或压缩设置写入的数据可以混在一起，Decode 总能正确解码。
+type Codec byte
+
+// 标记的取值避开了 zlib 数据的第一个字节（低 4 位为 8）和 json 的 '{'，
+// 所以没有标记的旧数据也能被识别
+const (
+	CodecNone Codec = iota + 1
+	CodecZlib
+	CodecGzip
+	CodecZstd
+	CodecSnappy
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecZlib:
+		return "zlib"
+	case CodecGzip:
+		return "gzip"
+	case CodecZstd:
+		return "zstd"
+	case CodecSnappy:
+		return "snappy"
+	}
+	return fmt.Sprintf("Codec(%d)", byte(c))
+}
+
+// ParseCodec 根据名称返回压缩算法，名称不区分大小写
+func ParseCodec(name string) (Codec, error) {
+	switch strings.ToLower(name) {
+	case "none", "":
+		return CodecNone, nil
+	case "zlib":
+		return CodecZlib, nil
+	case "gzip":
+		return CodecGzip, nil
+	case "zstd":
+		return CodecZstd, nil
+	case "snappy":
+		return CodecSnappy, nil
+	}
+	return 0, fmt.Errorf("unknown codec: %s", name)
+}
+
+func (c Codec) valid() bool {
+	return c >= CodecNone && c <= CodecSnappy
+}
+
+var (
+	zstdMagic      = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	errInvalidZstd = errors.New("invalid zstd data")
+)
+
+// zstd 的编码器和解码器创建代价较高，EncodeAll 和 DecodeAll 可以并发使用，所以全局共用
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+// Encode 用 codec 压缩 src，结果以标记开头。
+// 压缩后没有变小时不压缩，标记为 CodecNone
+func Encode(codec Codec, src []byte) ([]byte, error) {
+	if !codec.valid() {
+		return nil, fmt.Errorf("unknown codec: %d", byte(codec))
+	}
+
+	dst := []byte{byte(codec)}
+
+	var err error
+	switch codec {
+	case CodecNone:
+		return append(dst, src...), nil
+	case CodecZlib:
+		dst, err = compressStream(dst, src, func(w io.Writer) io.WriteCloser {
+			return zlib.NewWriter(w)
+		})
+	case CodecGzip:
+		dst, err = compressStream(dst, src, func(w io.Writer) io.WriteCloser {
+			return gzip.NewWriter(w)
+		})
+	case CodecZstd:
+		if err = initZstd(); err == nil {
+			dst = zstdEncoder.EncodeAll(src, dst)
+		}
+	case CodecSnappy:
+		dst = append(dst, s2.EncodeSnappy(nil, src)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dst) > len(src) {
+		return Encode(CodecNone, src)
+	}
+	return dst, nil
+}
+
+func compressStream(dst, src []byte, newWriter func(io.Writer) io.WriteCloser) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := newWriter(buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CodecOf 返回数据使用的压缩算法。没有标记的旧数据，看起来像 zlib 数据时
+// 返回 CodecZlib，否则返回 0
+func CodecOf(data []byte) Codec {
+	if len(data) == 0 {
+		return 0
+	}
+	if c := Codec(data[0]); c.valid() {
+		return c
+	}
+	if isZlib(data) {
+		return CodecZlib
+	}
+	return 0
+}
+
+// isZlib 检查 zlib 头：压缩方法为 deflate，且前两个字节组成的数是 31 的倍数
+func isZlib(data []byte) bool {
+	return len(data) >= 2 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0
+}
+
+// Decode 解码 Encode 的结果。
+//
+// 也可以解码没有标记的旧数据：旧版本压缩过的数据是 zlib 格式，没有压缩的
+// 数据原样返回。CodecNone 返回的切片与 src 共用底层数组。
+func Decode(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+
+	codec := Codec(src[0])
+	if !codec.valid() {
+		if isZlib(src) {
+			// 恰好符合 zlib 头的未压缩数据会解压失败，此时原样返回
+			if dec, err := decompressZlib(src); err == nil {
+				return dec, nil
+			}
+		}
+		return src, nil
+	}
+
+	data := src[1:]
+	switch codec {
+	case CodecZlib:
+		return decompressZlib(data)
+	case CodecGzip:
+		return decompressStream(data, func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		})
+	case CodecZstd:
+		// DecodeAll 遇到不完整的帧头时不返回错误，所以先检查魔数
+		if !bytes.HasPrefix(data, zstdMagic) {
+			return nil, errInvalidZstd
+		}
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(data, nil)
+	case CodecSnappy:
+		return s2.Decode(nil, data)
+	}
+	return data, nil
+}
+
+func decompressZlib(src []byte) ([]byte, error) {
+	return decompressStream(src, func(r io.Reader) (io.ReadCloser, error) {
+		return zlib.NewReader(r)
+	})
+}
+
+func decompressStream(src []byte, newReader func(io.Reader) (io.ReadCloser, error)) ([]byte, error) {
+	r, err := newReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}